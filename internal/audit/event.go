@@ -0,0 +1,229 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
+
+// Decisions taken for a request.
+const (
+	// DecisionAllow is recorded when the request, or the upstream response, was forwarded after enforcement.
+	DecisionAllow = "allow"
+	// DecisionDeny is recorded when the request was rejected, by Giam or for lack of a valid identity.
+	DecisionDeny = "deny"
+	// DecisionBypass is recorded when the request skipped enforcement.
+	DecisionBypass = "bypass"
+	// DecisionError is recorded when the request was rejected because it couldn't be enforced.
+	DecisionError = "error"
+)
+
+// Event is the record of a request handled by the plugin, annotated by the handlers along the way.
+type Event struct {
+	mu sync.Mutex
+
+	Time time.Time `json:"time"`
+	// RequestID correlates the event with the log lines of the request.
+	RequestID string `json:"requestId,omitempty"`
+	Endpoint  string `json:"endpoint"`
+	Decision  string `json:"decision"`
+	// Rule is the bypass rule the request matched, e.g. "role:Admin" or "break_glass".
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Expires is when the break-glass access of the request expires.
+	Expires *time.Time `json:"expires,omitempty"`
+	// BreakGlassError is why the break-glass token of the request was rejected.
+	BreakGlassError string          `json:"breakGlassError,omitempty"`
+	UserID          int             `json:"userId"`
+	Login           string          `json:"login"`
+	Teams           []*grafana.Team `json:"teams"`
+	OrgID           int             `json:"orgId"`
+	Role            string          `json:"role,omitempty"`
+	Queries         []*Query        `json:"queries,omitempty"`
+	// Filtered is the number of series or label values removed from the upstream response.
+	Filtered int `json:"filtered,omitempty"`
+	// Shadow is set when the decision was only recorded, the original request and response being forwarded as is.
+	Shadow bool `json:"shadow,omitempty"`
+	// GiamStatusCode is the status code of the last Giam answer, 0 when Giam wasn't called or couldn't be reached.
+	GiamStatusCode int `json:"giamStatusCode,omitempty"`
+	// FailureOutcome is what the failure policy did with the request Giam couldn't enforce, e.g. "allow unmodified".
+	FailureOutcome string  `json:"failureOutcome,omitempty"`
+	StatusCode     int     `json:"statusCode"`
+	LatencyMs      float64 `json:"latencyMs"`
+	Method         string  `json:"method"`
+	Path           string  `json:"path"`
+	RemoteAddr     string  `json:"remoteAddr"`
+}
+
+// Query is a query of the request, or the datasource of a series or label values request, along with its rewritten
+// expression.
+type Query struct {
+	DatasourceUID  string `json:"datasourceUid"`
+	DatasourceType string `json:"datasourceType"`
+	Original       string `json:"original,omitempty"`
+	Rewritten      string `json:"rewritten,omitempty"`
+}
+
+type eventKey struct{}
+
+// WithEvent returns a copy of ctx carrying the event of the request.
+func WithEvent(ctx context.Context, event *Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, event)
+}
+
+// FromContext returns the event of the request, nil when the request isn't audited. Every method of Event does
+// nothing on a nil Event, and is safe for concurrent use.
+func FromContext(ctx context.Context) *Event {
+	event, _ := ctx.Value(eventKey{}).(*Event)
+
+	return event
+}
+
+// finish records the response of the request. A request without decision was forwarded untouched, or rejected
+// before enforcement.
+func (e *Event) finish(statusCode int, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.StatusCode = statusCode
+	e.LatencyMs = float64(latency.Microseconds()) / 1000
+
+	if e.Decision == "" {
+		e.Decision = DecisionAllow
+
+		if statusCode >= http.StatusBadRequest {
+			e.Decision = DecisionDeny
+		}
+	}
+}
+
+// SetEndpoint sets the endpoint of the handler enforcing the request, the last handler wins.
+func (e *Event) SetEndpoint(endpoint string) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Endpoint = endpoint
+}
+
+// SetIdentity records the identity the request was enforced for.
+func (e *Event) SetIdentity(id *identity.Identity) {
+	if e == nil || id == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if id.User != nil {
+		e.UserID = id.User.ID
+		e.Login = id.User.Login
+	}
+
+	e.Teams = id.Teams
+	e.OrgID = id.OrgID
+	e.Role = id.Role
+}
+
+func (e *Event) SetDecision(decision string) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Decision = decision
+}
+
+func (e *Event) SetGiamStatusCode(code int) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.GiamStatusCode = code
+}
+
+func (e *Event) AddQuery(query *Query) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Queries = append(e.Queries, query)
+}
+
+// SetShadow records whether the decision of the request is enforced or only recorded.
+func (e *Event) SetShadow(shadow bool) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Shadow = shadow
+}
+
+// SetFiltered records the number of series or label values removed from the upstream response.
+func (e *Event) SetFiltered(filtered int) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Filtered = filtered
+}
+
+// Bypass records that the request skipped enforcement with the rule.
+func (e *Event) Bypass(rule string, reason string, expires *time.Time) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.Decision = DecisionBypass
+	e.Rule = rule
+	e.Reason = reason
+	e.Expires = expires
+}
+
+// SetFailureOutcome records what the failure policy did with the request.
+func (e *Event) SetFailureOutcome(outcome string) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.FailureOutcome = outcome
+}
+
+// RejectBreakGlass records why the break-glass token of the request was rejected.
+func (e *Event) RejectBreakGlass(reason string) {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.BreakGlassError = reason
+}
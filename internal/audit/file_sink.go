@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// fileSink writes the events as JSON lines to a local file, rotated once it reaches its max size. The rotated files
+// are suffixed with .1, .2, ... from the newest to the oldest.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+type FileSinkDeps struct {
+	Path string
+	// MaxSize is the size in bytes the file is rotated at, it is never rotated when MaxSize is 0.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept, the older ones are removed.
+	MaxBackups int
+}
+
+func NewFileSink(deps *FileSinkDeps) (Sink, error) {
+	s := &fileSink{path: deps.Path, maxSize: deps.MaxSize, maxBackups: deps.MaxBackups}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileSink) Write(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rotateErr error
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(data)) > s.maxSize {
+		// A failed rotation keeps appending to the current file rather than losing the event.
+		if rotateErr = s.rotate(); s.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+
+	if err != nil {
+		return err
+	}
+
+	return rotateErr
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return fmt.Errorf("failed to stat audit file: %w", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+
+	return nil
+}
+
+// rotate shifts the rotated files, drops the oldest one and starts a new file.
+func (s *fileSink) rotate() error {
+	s.file.Close()
+	s.file = nil
+
+	shiftErr := s.shift()
+
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	return shiftErr
+}
+
+func (s *fileSink) shift() error {
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove audit file: %w", err)
+		}
+
+		return nil
+	}
+
+	os.Remove(s.backup(s.maxBackups))
+
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(s.backup(i), s.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit file: %w", err)
+		}
+	}
+
+	if err := os.Rename(s.path, s.backup(1)); err != nil {
+		return fmt.Errorf("failed to rotate audit file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *fileSink) backup(i int) string {
+	return s.path + "." + strconv.Itoa(i)
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func readEvents(t *testing.T, path string) []*Event {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+
+	defer file.Close()
+
+	var events []*Event
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+
+		events = append(events, &event)
+	}
+
+	return events
+}
+
+func TestFileSink_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	line, err := json.Marshal(&Event{Login: "user0"})
+	require.NoError(t, err)
+
+	// Every file holds two events.
+	sink, err := NewFileSink(&FileSinkDeps{Path: path, MaxSize: int64(2*len(line) + 2), MaxBackups: 2})
+	require.NoError(t, err)
+
+	for _, login := range []string{"user0", "user1", "user2", "user3", "user4", "user5", "user6"} {
+		require.NoError(t, sink.Write(&Event{Login: login}))
+	}
+
+	tests := []struct {
+		path           string
+		expectedLogins []string
+	}{
+		{path: path, expectedLogins: []string{"user6"}},
+		{path: path + ".1", expectedLogins: []string{"user4", "user5"}},
+		{path: path + ".2", expectedLogins: []string{"user2", "user3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			events := readEvents(t, tt.path)
+
+			logins := make([]string, len(events))
+			for i, event := range events {
+				logins[i] = event.Login
+			}
+
+			assert.Equal(t, tt.expectedLogins, logins)
+		})
+	}
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileSink_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := NewFileSink(&FileSinkDeps{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(&Event{Login: "user0"}))
+
+	// A restarted plugin appends to the existing file.
+	sink, err = NewFileSink(&FileSinkDeps{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(&Event{Login: "user1"}))
+
+	assert.Equal(t, 2, len(readEvents(t, path)))
+}
@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"strconv"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+)
+
+// metricsSink counts the requests by endpoint and decision, and the series and label values filtered out of the
+// upstream responses, from the events of the requests.
+type metricsSink struct {
+	requests *metrics.Counter
+	duration *metrics.Histogram
+	filtered *metrics.Counter
+}
+
+// NewMetricsSink returns a sink recording the events to the metrics registry, or nil when registry is nil.
+func NewMetricsSink(registry *metrics.Registry) Sink {
+	if registry == nil {
+		return nil
+	}
+
+	return &metricsSink{
+		requests: registry.NewCounter(
+			"giam_requests_total",
+			"Requests enforced by the plugin by endpoint, decision and status code, shadow decisions being only recorded.",
+			"endpoint", "decision", "code", "shadow",
+		),
+		duration: registry.NewHistogram(
+			"giam_request_duration_seconds",
+			"Latency of the requests enforced by the plugin, upstream included.",
+			metrics.DefaultBuckets,
+			"endpoint",
+		),
+		filtered: registry.NewCounter(
+			"giam_filtered_items_total",
+			"Series and label values removed from the upstream responses by endpoint and datasource type.",
+			"endpoint", "datasource",
+		),
+	}
+}
+
+func (s *metricsSink) Write(event *Event) error {
+	event.mu.Lock()
+	defer event.mu.Unlock()
+
+	s.requests.Inc(event.Endpoint, event.Decision, strconv.Itoa(event.StatusCode), strconv.FormatBool(event.Shadow))
+	s.duration.Observe(event.LatencyMs/1000, event.Endpoint)
+
+	if event.Filtered > 0 && len(event.Queries) > 0 {
+		s.filtered.Add(float64(event.Filtered), event.Endpoint, event.Queries[0].DatasourceType)
+	}
+
+	return nil
+}
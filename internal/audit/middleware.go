@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/requestid"
+)
+
+// Middleware records an event for every request matched by one of the handlers, which annotate the event of the
+// request found with FromContext. The other requests are passed through without being audited.
+type Middleware struct {
+	next     http.Handler
+	handlers []handler.Handler
+	sink     Sink
+	logger   *log.Logger
+}
+
+type MiddlewareDeps struct {
+	Next     http.Handler
+	Handlers []handler.Handler
+	Sink     Sink
+	Logger   *log.Logger
+}
+
+func NewMiddleware(deps *MiddlewareDeps) http.Handler {
+	return &Middleware{next: deps.Next, handlers: deps.Handlers, sink: deps.Sink, logger: deps.Logger}
+}
+
+func (m *Middleware) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !m.matches(req) {
+		m.next.ServeHTTP(rw, req)
+
+		return
+	}
+
+	start := time.Now()
+	event := &Event{
+		Time:       start.UTC(),
+		RequestID:  requestid.FromContext(req.Context()),
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		RemoteAddr: req.RemoteAddr,
+	}
+
+	w := &statusWriter{ResponseWriter: rw}
+	m.next.ServeHTTP(w, req.WithContext(WithEvent(req.Context(), event)))
+
+	event.finish(w.status(), time.Since(start))
+
+	if err := m.sink.Write(event); err != nil {
+		m.logger.Ctx(req.Context()).Errorw("failed to write audit event", "err", err)
+	}
+}
+
+func (m *Middleware) matches(req *http.Request) bool {
+	for _, h := range m.handlers {
+		if h.Match(req) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets the streamed responses through.
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+
+	return w.code
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+// queryHandler annotates the event of the /api/ds/query requests and rejects them when statusCode isn't 200.
+type queryHandler struct {
+	statusCode int
+}
+
+func (h *queryHandler) Match(req *http.Request) bool {
+	return req.URL.Path == "/api/ds/query"
+}
+
+func (h *queryHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	event := FromContext(req.Context())
+	event.SetEndpoint("query")
+	event.SetIdentity(&identity.Identity{
+		User:  &grafana.User{ID: 1, Login: "jane"},
+		Teams: []*grafana.Team{{ID: 2, Name: "payment"}},
+		OrgID: 3,
+	})
+	event.SetGiamStatusCode(h.statusCode)
+
+	if h.statusCode != http.StatusOK {
+		http.Error(rw, "Forbidden", h.statusCode)
+
+		return
+	}
+
+	event.AddQuery(&Query{
+		DatasourceUID:  "prometheusUID",
+		DatasourceType: "prometheus",
+		Original:       `up`,
+		Rewritten:      `up{team="payment"}`,
+	})
+
+	next.ServeHTTP(rw, req)
+}
+
+func TestMiddleware_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		statusCode       int
+		expectedEvent    bool
+		expectedDecision string
+		expectedQueries  int
+	}{
+		{
+			name:             "it should record an allowed request",
+			path:             "/api/ds/query",
+			statusCode:       http.StatusOK,
+			expectedEvent:    true,
+			expectedDecision: DecisionAllow,
+			expectedQueries:  1,
+		},
+		{
+			name:             "it should record a denied request",
+			path:             "/api/ds/query",
+			statusCode:       http.StatusForbidden,
+			expectedEvent:    true,
+			expectedDecision: DecisionDeny,
+		},
+		{
+			name:       "it should not record the requests no handler enforces",
+			path:       "/api/dashboards/home",
+			statusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records bytes.Buffer
+
+			handlers := []handler.Handler{&queryHandler{statusCode: tt.statusCode}}
+			middleware := NewMiddleware(&MiddlewareDeps{
+				Next:     handler.ChainHandlers(&mocks.NextHandler{}, handlers...),
+				Handlers: handlers,
+				Sink:     NewWriterSink(&records),
+				Logger:   log.New("FATAL"),
+			})
+
+			rr := httptest.NewRecorder()
+			middleware.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tt.path, nil))
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+
+			if !tt.expectedEvent {
+				assert.Equal(t, 0, records.Len())
+
+				return
+			}
+
+			var event Event
+			require.NoError(t, json.Unmarshal(records.Bytes(), &event))
+			assert.Equal(t, tt.expectedDecision, event.Decision)
+			assert.Equal(t, "query", event.Endpoint)
+			assert.Equal(t, "jane", event.Login)
+			assert.Equal(t, 3, event.OrgID)
+			assert.Equal(t, tt.statusCode, event.StatusCode)
+			assert.Equal(t, tt.statusCode, event.GiamStatusCode)
+			assert.Equal(t, tt.expectedQueries, len(event.Queries))
+			assert.Equal(t, http.MethodPost, event.Method)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Sinks the audit events can be written to.
+const (
+	SinkStderr  = "stderr"
+	SinkFile    = "file"
+	SinkWebhook = "webhook"
+)
+
+// Sink writes the audit events.
+type Sink interface {
+	Write(event *Event) error
+}
+
+// writerSink writes the events as JSON lines, e.g. to stderr.
+type writerSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func NewWriterSink(writer io.Writer) Sink {
+	return &writerSink{writer: writer}
+}
+
+func (s *writerSink) Write(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.writer.Write(append(data, '\n'))
+
+	return err
+}
+
+// multiSink writes the events to every sink, e.g. to the audit log and to the metrics.
+type multiSink struct {
+	sinks []Sink
+}
+
+// NewMultiSink returns a sink writing to the non-nil sinks, or nil when there are none.
+func NewMultiSink(sinks ...Sink) Sink {
+	var nonNil []Sink
+
+	for _, sink := range sinks {
+		if sink != nil {
+			nonNil = append(nonNil, sink)
+		}
+	}
+
+	switch len(nonNil) {
+	case 0:
+		return nil
+	case 1:
+		return nonNil[0]
+	default:
+		return &multiSink{sinks: nonNil}
+	}
+}
+
+// Write writes the event to every sink, even when one of them fails, and returns the first error.
+func (s *multiSink) Write(event *Event) error {
+	var firstErr error
+
+	for _, sink := range s.sinks {
+		if err := sink.Write(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// WebhookSink posts the events in batches, as a JSON array, to a webhook. A batch is sent once it is full or every
+// flush interval, whichever comes first. Events are dropped when the webhook can't keep up, so auditing never blocks
+// the requests.
+type WebhookSink struct {
+	url        string
+	headers    map[string]string
+	batchSize  int
+	maxPending int
+	interval   time.Duration
+	httpClient *http.Client
+	logger     *log.Logger
+
+	mu      sync.Mutex
+	pending []*Event
+	full    chan struct{}
+}
+
+type WebhookSinkDeps struct {
+	URL string
+	// Headers are added to every request, e.g. an Authorization header.
+	Headers   map[string]string
+	BatchSize int
+	// MaxPending bounds the number of events waiting to be sent, the newer events are dropped beyond it.
+	MaxPending    int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	Logger        *log.Logger
+}
+
+func NewWebhookSink(deps *WebhookSinkDeps) *WebhookSink {
+	return &WebhookSink{
+		url:        deps.URL,
+		headers:    deps.Headers,
+		batchSize:  deps.BatchSize,
+		maxPending: deps.MaxPending,
+		interval:   deps.FlushInterval,
+		httpClient: &http.Client{Timeout: deps.Timeout},
+		logger:     deps.Logger,
+		full:       make(chan struct{}, 1),
+	}
+}
+
+// Write queues the event, it is sent by Run.
+func (s *WebhookSink) Write(event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) >= s.maxPending {
+		return fmt.Errorf("audit webhook queue is full, dropping the event")
+	}
+
+	s.pending = append(s.pending, event)
+
+	if len(s.pending) >= s.batchSize {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Run sends the queued events until ctx is done, then sends the remaining ones.
+func (s *WebhookSink) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is done, the remaining events are sent with a context of their own.
+			s.Flush(context.Background())
+
+			return
+		case <-ticker.C:
+			s.Flush(ctx)
+		case <-s.full:
+			s.Flush(ctx)
+		}
+	}
+}
+
+// Flush sends the queued events, one batch at a time. A batch the webhook failed to receive is dropped.
+func (s *WebhookSink) Flush(ctx context.Context) {
+	for {
+		batch := s.next()
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := s.send(ctx, batch); err != nil {
+			s.logger.Ctx(ctx).Errorw("failed to send audit events", "events", len(batch), "err", err)
+		}
+	}
+}
+
+// next dequeues the next batch.
+func (s *WebhookSink) next() []*Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.pending)
+	if n > s.batchSize {
+		n = s.batchSize
+	}
+
+	batch := s.pending[:n:n]
+	s.pending = s.pending[n:]
+
+	return batch
+}
+
+func (s *WebhookSink) send(ctx context.Context, batch []*Event) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook answered with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
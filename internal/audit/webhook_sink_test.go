@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+// webhook records the batches it receives.
+type webhook struct {
+	mu      sync.Mutex
+	batches [][]*Event
+	headers []http.Header
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var batch []*Event
+	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.batches = append(w.batches, batch)
+	w.headers = append(w.headers, req.Header)
+}
+
+func (w *webhook) sizes() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	sizes := make([]int, len(w.batches))
+	for i, batch := range w.batches {
+		sizes[i] = len(batch)
+	}
+
+	return sizes
+}
+
+func TestWebhookSink_Flush(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	sink := NewWebhookSink(&WebhookSinkDeps{
+		URL:           server.URL,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+		BatchSize:     2,
+		MaxPending:    3,
+		FlushInterval: time.Minute,
+		Timeout:       time.Second,
+		Logger:        log.New("FATAL"),
+	})
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sink.Write(&Event{UserID: i}))
+	}
+
+	// The queue is full until the pending events are sent.
+	assert.Error(t, sink.Write(&Event{UserID: 3}))
+
+	sink.Flush(context.Background())
+
+	assert.Equal(t, []int{2, 1}, hook.sizes())
+	assert.Equal(t, 2, hook.batches[1][0].UserID)
+	assert.Equal(t, "Bearer token", hook.headers[0].Get("Authorization"))
+	assert.NoError(t, sink.Write(&Event{UserID: 4}))
+}
+
+func TestWebhookSink_Run(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	sink := NewWebhookSink(&WebhookSinkDeps{
+		URL:           server.URL,
+		BatchSize:     2,
+		MaxPending:    10,
+		FlushInterval: time.Minute,
+		Timeout:       time.Second,
+		Logger:        log.New("FATAL"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		sink.Run(ctx)
+		close(done)
+	}()
+
+	require.NoError(t, sink.Write(&Event{UserID: 1}))
+	require.NoError(t, sink.Write(&Event{UserID: 2}))
+
+	// A full batch is sent without waiting for the flush interval.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hook.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, []int{2}, hook.sizes())
+
+	// The remaining events are sent once ctx is done.
+	require.NoError(t, sink.Write(&Event{UserID: 3}))
+	cancel()
+	<-done
+
+	assert.Equal(t, []int{2, 1}, hook.sizes())
+}
@@ -2,13 +2,18 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"regexp"
 
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
 	"github.com/usegiam/giam-traefik-plugin/internal/authorization"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
 	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
 )
@@ -20,22 +25,32 @@ const datasourceQueryEndpointPattern = "^/api/ds/query"
 var datasourceQueryEndpointRegexExp = regexp.MustCompile(datasourceQueryEndpointPattern)
 
 type DatasourceHandler struct {
-	logger      *log.Logger
-	grafanaRepo grafana.Repo
-	service     authorization.Service
+	logger        *log.Logger
+	identities    identity.Resolver
+	service       authorization.Service
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
 }
 
 type DatasourceHandlerDeps struct {
-	Logger      *log.Logger
-	GrafanaRepo grafana.Repo
-	Service     authorization.Service
+	Logger        *log.Logger
+	Identities    identity.Resolver
+	Service       authorization.Service
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the request once authorized, the decision is only logged and audited.
+	Shadow bool
 }
 
 func NewDatasourceHandler(deps *DatasourceHandlerDeps) handler.Handler {
 	return &DatasourceHandler{
-		logger:      deps.Logger,
-		service:     deps.Service,
-		grafanaRepo: deps.GrafanaRepo,
+		logger:        deps.Logger,
+		service:       deps.Service,
+		identities:    deps.Identities,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
 	}
 }
 
@@ -44,7 +59,11 @@ func (d *DatasourceHandler) Match(req *http.Request) bool {
 }
 
 func (d *DatasourceHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
-	d.logger.Debug("instantiated a datasource authorize")
+	d.logger.Ctx(req.Context()).Debugw("instantiated a datasource authorize")
+
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(failure.EndpointDatasource)
+	event.SetShadow(d.shadow)
 
 	rawBody, err := io.ReadAll(req.Body)
 	if err != nil {
@@ -62,38 +81,38 @@ func (d *DatasourceHandler) Handle(rw http.ResponseWriter, req *http.Request, ne
 		return
 	}
 
-	grafanaSession, err := req.Cookie("grafana_session")
-	if err != nil {
-		http.Error(rw, "Forbidden", http.StatusForbidden)
-
-		return
-	}
-
-	user, err := d.grafanaRepo.GetUser(grafanaSession.Value)
+	id, err := d.identities.Resolve(req)
 	if err != nil {
-		d.logger.Debugf("user doesn't exists, err: %v", err)
+		d.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
 
-		http.Error(rw, "User doesn't exits", http.StatusBadRequest)
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
 
 		return
 	}
 
-	teams, err := d.grafanaRepo.GetUserTeams(grafanaSession.Value, user.ID)
-	if err != nil {
-		d.logger.Debugf("user doesn't have any team, err: %v", err)
+	event.SetIdentity(id)
 
-		http.Error(rw, "User not assigned to any team", http.StatusBadRequest)
+	if d.bypass.Allows(req, id) {
+		d.forward(rw, req, next, rawBody)
 
 		return
 	}
 
-	resp, err := d.service.AuthorizeQuery(&authorization.AuthorizeDatasourceReq{
-		User:    user,
-		Teams:   teams,
+	resp, ok := d.authorize(req.Context(), &authorization.AuthorizeDatasourceReq{
+		User:    id.User,
+		Teams:   id.Teams,
+		OrgID:   id.OrgID,
 		Queries: queryReq.Queries,
 	})
-	if err != nil {
-		d.logger.Debugf("unable to send authorize request to Giam, err: %v", err)
+	if !ok {
+		event.SetDecision(audit.DecisionError)
+
+		if d.shadow {
+			d.forward(rw, req, next, rawBody)
+
+			return
+		}
 
 		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
 
@@ -101,15 +120,63 @@ func (d *DatasourceHandler) Handle(rw http.ResponseWriter, req *http.Request, ne
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		d.logger.Debugf("user is not authorized to given datasource")
+		d.logger.Ctx(req.Context()).Debugw("user is not authorized to given datasource")
+
+		event.SetDecision(audit.DecisionDeny)
+
+		if d.shadow {
+			d.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the denied request",
+				"status", resp.StatusCode, "message", resp.Message)
+
+			d.forward(rw, req, next, rawBody)
+
+			return
+		}
 
 		http.Error(rw, resp.Message, resp.StatusCode)
 
 		return
 	}
 
-	req.Body = io.NopCloser(bytes.NewBuffer(rawBody))
-	req.ContentLength = int64(len(rawBody))
+	event.SetDecision(audit.DecisionAllow)
+	d.forward(rw, req, next, rawBody)
+}
+
+// forward sends the request to next with the body it was read from.
+func (d *DatasourceHandler) forward(rw http.ResponseWriter, req *http.Request, next http.Handler, body []byte) {
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+	req.ContentLength = int64(len(body))
 
 	next.ServeHTTP(rw, req)
 }
+
+// authorize authorizes the datasources of the queries with Giam, and applies the failure policy when Giam can't be
+// reached. It returns false when the request must be denied.
+func (d *DatasourceHandler) authorize(
+	ctx context.Context,
+	payload *authorization.AuthorizeDatasourceReq,
+) (*authorization.AuthorizeDatasourceResp, bool) {
+	resp, err := d.service.AuthorizeQuery(ctx, payload)
+	if err == nil {
+		d.failurePolicy.Remember("", failure.EndpointDatasource, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	d.logger.Ctx(ctx).Debugw("unable to send authorize request to Giam", "err", err)
+
+	decision, ok := d.failurePolicy.Fallback(
+		ctx,
+		"",
+		failure.EndpointDatasource,
+		payload,
+		err,
+		&authorization.AuthorizeDatasourceResp{StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*authorization.AuthorizeDatasourceResp), true
+}
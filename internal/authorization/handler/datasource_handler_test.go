@@ -3,6 +3,7 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -11,7 +12,10 @@ import (
 
 	"github.com/usegiam/giam-traefik-plugin/internal/authorization"
 	"github.com/usegiam/giam-traefik-plugin/internal/authorization/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
 	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
 	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
@@ -19,12 +23,20 @@ import (
 )
 
 func TestDatasourceHandler_Handle(t *testing.T) {
+	allowUnmodified, err := failure.NewPolicy(&failure.PolicyDeps{
+		Default: failure.ModeAllowUnmodified,
+		HashSvc: hash.NewService(),
+		Logger:  log.New("FATAL"),
+	})
+	require.NoError(t, err)
+
 	tests := []struct {
 		name               string
 		payload            *grafana.QueryReq
 		expectedBody       interface{}
 		service            authorization.Service
 		grafanaRepo        grafana.Repo
+		failurePolicy      *failure.Policy
 		expectedStatusCode int
 	}{
 		{
@@ -114,6 +126,47 @@ func TestDatasourceHandler_Handle(t *testing.T) {
 			},
 			expectedStatusCode: http.StatusPreconditionFailed,
 		},
+		{
+			name: "it should deny the request when giam is unreachable",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					map[string]interface{}{
+						"expr": `{cluster="customer3"}`,
+					},
+				},
+			},
+			expectedBody: "Unable to communicate with Giam service",
+			service:      &service.Mock{Error: errors.New("connection refused")},
+			grafanaRepo: &grafana.MockRepo{
+				User:  &grafana.User{ID: 1, Name: "user1"},
+				Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+			},
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name: "it should forward the request when giam is unreachable and the failure mode allows it",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					map[string]interface{}{
+						"expr": `{cluster="customer3"}`,
+					},
+				},
+			},
+			expectedBody: &grafana.QueryReq{
+				Queries: []interface{}{
+					map[string]interface{}{
+						"expr": `{cluster="customer3"}`,
+					},
+				},
+			},
+			service: &service.Mock{Error: errors.New("connection refused")},
+			grafanaRepo: &grafana.MockRepo{
+				User:  &grafana.User{ID: 1, Name: "user1"},
+				Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+			},
+			failurePolicy:      allowUnmodified,
+			expectedStatusCode: http.StatusOK,
+		},
 	}
 
 	for _, tt := range tests {
@@ -133,9 +186,13 @@ func TestDatasourceHandler_Handle(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			handler := &DatasourceHandler{
-				logger:      log.New("FATAL"),
-				service:     tt.service,
-				grafanaRepo: tt.grafanaRepo,
+				logger:  log.New("FATAL"),
+				service: tt.service,
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: tt.grafanaRepo,
+					Logger:      log.New("FATAL"),
+				}),
+				failurePolicy: tt.failurePolicy,
 			}
 
 			handler.Handle(rr, req, &mocks.NextHandler{})
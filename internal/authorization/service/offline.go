@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/authorization"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/internal/policy"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// offlineService authorizes the datasources against the last policy snapshot synced from Giam when the Giam API is
+// unreachable.
+type offlineService struct {
+	service  authorization.Service
+	policies *policy.Store
+	logger   *log.Logger
+}
+
+type OfflineDeps struct {
+	Service  authorization.Service
+	Policies *policy.Store
+	Logger   *log.Logger
+}
+
+func NewOffline(deps *OfflineDeps) authorization.Service {
+	return &offlineService{service: deps.Service, policies: deps.Policies, logger: deps.Logger}
+}
+
+func (s *offlineService) AuthorizeQuery(
+	ctx context.Context,
+	payload *authorization.AuthorizeDatasourceReq,
+) (*authorization.AuthorizeDatasourceResp, error) {
+	resp, err := s.service.AuthorizeQuery(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam authorize datasource failed, enforcing the policy snapshot", "err", err)
+
+	for _, query := range payload.Queries {
+		uid := datasource.QueryDatasourceUID(query)
+		if datasource.IsBuiltin(uid) {
+			continue
+		}
+
+		_, err := s.policies.Matchers(payload.Teams, uid)
+		if err == errors.ErrNoPolicy {
+			return &authorization.AuthorizeDatasourceResp{
+				Message:    "No policy allows access to this datasource",
+				StatusCode: http.StatusForbidden,
+			}, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &authorization.AuthorizeDatasourceResp{StatusCode: http.StatusOK}, nil
+}
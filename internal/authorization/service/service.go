@@ -1,71 +1,49 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/usegiam/giam-traefik-plugin/internal/authorization"
-	"io"
 	"net/http"
 
+	"github.com/usegiam/giam-traefik-plugin/internal/authorization"
+	"github.com/usegiam/giam-traefik-plugin/pkg/giam"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
 )
 
 type service struct {
-	apiUrl string
-	apiKey string
+	client *giam.Client
 	logger *log.Logger
 }
 
 type Deps struct {
-	APIUrl string
-	APIKey string
+	Client *giam.Client
 	Logger *log.Logger
 }
 
 func NewService(deps *Deps) authorization.Service {
-	return &service{apiUrl: deps.APIUrl, apiKey: deps.APIKey, logger: deps.Logger}
+	return &service{client: deps.Client, logger: deps.Logger}
 }
 
 func (s *service) AuthorizeQuery(
+	ctx context.Context,
 	payload *authorization.AuthorizeDatasourceReq,
 ) (*authorization.AuthorizeDatasourceResp, error) {
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest(http.MethodPost, s.apiUrl+"/authorization/datasource", bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", s.apiKey)
-
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/authorization/datasource",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	s.logger.Debugf(
-		"giam authorized datasource resp status code: %v, resp body: %s",
-		resp.StatusCode,
-		string(respBody),
-	)
+	s.logger.Ctx(ctx).Debugw("giam authorized datasource resp", "status", resp.StatusCode, "body", string(resp.Body))
 
 	var authorizationResp authorization.AuthorizeDatasourceResp
 
-	err = json.Unmarshal(respBody, &authorizationResp)
+	err = json.Unmarshal(resp.Body, &authorizationResp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode giam authorize datasource resp: %w", err)
 	}
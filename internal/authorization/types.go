@@ -1,14 +1,19 @@
 package authorization
 
-import "github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
 
 type Service interface {
-	AuthorizeQuery(payload *AuthorizeDatasourceReq) (*AuthorizeDatasourceResp, error)
+	AuthorizeQuery(ctx context.Context, payload *AuthorizeDatasourceReq) (*AuthorizeDatasourceResp, error)
 }
 
 type AuthorizeDatasourceReq struct {
 	User    *grafana.User   `json:"user"`
 	Teams   []*grafana.Team `json:"teams"`
+	OrgID   int             `json:"orgId"`
 	Queries []interface{}   `json:"queries"`
 }
 
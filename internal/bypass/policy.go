@@ -0,0 +1,258 @@
+package bypass
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// RuleBreakGlass is the rule recorded for the requests bypassing enforcement with a break-glass token.
+const RuleBreakGlass = "break_glass"
+
+// Policy decides which requests skip the enforcement of the policies: the ones of the users with a bypassing org role,
+// team or login, and the ones carrying a valid break-glass token. Every bypass is recorded to the audit log. A nil
+// Policy bypasses nothing.
+type Policy struct {
+	roles       map[string]bool
+	teams       map[string]bool
+	logins      map[string]bool
+	header      string
+	secret      []byte
+	maxDuration time.Duration
+	logger      *log.Logger
+	now         func() time.Time
+}
+
+type PolicyDeps struct {
+	// Roles are the grafana org roles whose users bypass enforcement, e.g. "Admin", matched case-insensitively.
+	Roles []string
+	// Teams are the names of the teams whose members bypass enforcement.
+	Teams []string
+	// Logins are the grafana logins of the users who bypass enforcement, matched case-insensitively.
+	Logins []string
+	// BreakGlassHeader is the header the break-glass token is read from, break-glass is disabled when
+	// BreakGlassSecret is empty.
+	BreakGlassHeader string
+	BreakGlassSecret string
+	// BreakGlassMaxDuration is the longest a break-glass token can be valid for, from its issuance to its expiry.
+	BreakGlassMaxDuration time.Duration
+	Logger                *log.Logger
+}
+
+// grant is the payload of a break-glass token, signed with HMAC-SHA256 by the shared secret.
+type grant struct {
+	// Login is the grafana login of the only user the token is valid for.
+	Login string `json:"login"`
+	// Iat and Exp are when the token was issued and when it expires, as unix timestamps.
+	Iat    int64  `json:"iat"`
+	Exp    int64  `json:"exp"`
+	Reason string `json:"reason"`
+}
+
+// NewPolicy returns nil when no rule is configured and break-glass is disabled.
+func NewPolicy(deps *PolicyDeps) (*Policy, error) {
+	if deps.BreakGlassSecret != "" && deps.BreakGlassHeader == "" {
+		return nil, fmt.Errorf("a break-glass header is required along with the break-glass secret")
+	}
+
+	if deps.BreakGlassSecret != "" && deps.BreakGlassMaxDuration <= 0 {
+		return nil, fmt.Errorf("invalid break-glass max duration %s", deps.BreakGlassMaxDuration)
+	}
+
+	if len(deps.Roles) == 0 && len(deps.Teams) == 0 && len(deps.Logins) == 0 && deps.BreakGlassSecret == "" {
+		return nil, nil
+	}
+
+	return &Policy{
+		roles:       set(deps.Roles, true),
+		teams:       set(deps.Teams, false),
+		logins:      set(deps.Logins, true),
+		header:      deps.BreakGlassHeader,
+		secret:      []byte(deps.BreakGlassSecret),
+		maxDuration: deps.BreakGlassMaxDuration,
+		logger:      deps.Logger,
+		now:         time.Now,
+	}, nil
+}
+
+func set(values []string, fold bool) map[string]bool {
+	s := make(map[string]bool, len(values))
+
+	for _, value := range values {
+		if fold {
+			value = strings.ToLower(value)
+		}
+
+		s[value] = true
+	}
+
+	return s
+}
+
+// Allows reports whether the request of the identity skips enforcement, and records the bypass to the audit event of
+// the request.
+func (p *Policy) Allows(req *http.Request, id *identity.Identity) bool {
+	if p == nil {
+		return false
+	}
+
+	event := audit.FromContext(req.Context())
+	token := p.token(req)
+
+	login := ""
+	if id.User != nil {
+		login = id.User.Login
+	}
+
+	if rule, ok := p.matchRule(id); ok {
+		p.logger.Ctx(req.Context()).Debugw("bypassing enforcement", "login", login, "rule", rule)
+
+		event.Bypass(rule, "", nil)
+
+		return true
+	}
+
+	if token == "" {
+		return false
+	}
+
+	g, err := p.verify(token, login)
+	if err != nil {
+		p.logger.Ctx(req.Context()).Infow("rejected break-glass token", "login", login, "err", err)
+
+		event.RejectBreakGlass(err.Error())
+
+		return false
+	}
+
+	p.logger.Ctx(req.Context()).Infow("break-glass access", "login", login, "reason", g.Reason)
+
+	expires := time.Unix(g.Exp, 0).UTC()
+	event.Bypass(RuleBreakGlass, g.Reason, &expires)
+
+	return true
+}
+
+type tokenKey struct{}
+
+// WithToken moves the break-glass token of the request from its header to its context, so it is never forwarded
+// upstream, whether the request is enforced or not.
+func (p *Policy) WithToken(req *http.Request) *http.Request {
+	if p == nil || len(p.secret) == 0 {
+		return req
+	}
+
+	token := req.Header.Get(p.header)
+	if token == "" {
+		return req
+	}
+
+	req.Header.Del(p.header)
+
+	return req.WithContext(context.WithValue(req.Context(), tokenKey{}, token))
+}
+
+// token returns the break-glass token moved to the context of the request, or the one of its header, which is
+// removed.
+func (p *Policy) token(req *http.Request) string {
+	if len(p.secret) == 0 {
+		return ""
+	}
+
+	if token, ok := req.Context().Value(tokenKey{}).(string); ok {
+		return token
+	}
+
+	token := req.Header.Get(p.header)
+	req.Header.Del(p.header)
+
+	return token
+}
+
+// matchRule returns the first rule the identity matches, e.g. "role:Admin".
+func (p *Policy) matchRule(id *identity.Identity) (string, bool) {
+	if id.Role != "" && p.roles[strings.ToLower(id.Role)] {
+		return "role:" + id.Role, true
+	}
+
+	if id.User != nil && id.User.Login != "" && p.logins[strings.ToLower(id.User.Login)] {
+		return "login:" + id.User.Login, true
+	}
+
+	for _, team := range id.Teams {
+		if p.teams[team.Name] {
+			return "team:" + team.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// verify checks the signature, the issuance and the expiry of the break-glass token, and that it was issued for the
+// login.
+func (p *Policy) verify(token string, login string) (*grant, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if !hmac.Equal(signature, sign(p.secret, parts[0])) {
+		return nil, fmt.Errorf("signature mismatch")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	var g grant
+	if err := json.Unmarshal(payload, &g); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	now := p.now()
+	issued := time.Unix(g.Iat, 0)
+	expires := time.Unix(g.Exp, 0)
+
+	if g.Iat == 0 || now.Before(issued) {
+		return nil, fmt.Errorf("invalid issuance time %d", g.Iat)
+	}
+
+	if !now.Before(expires) {
+		return nil, fmt.Errorf("token expired at %s", expires.UTC().Format(time.RFC3339))
+	}
+
+	// The validity is measured from the issuance, a token minted long before its use can't outlive the max duration.
+	if expires.Sub(issued) > p.maxDuration {
+		return nil, fmt.Errorf("token is valid for longer than %s", p.maxDuration)
+	}
+
+	if g.Login == "" || !strings.EqualFold(g.Login, login) {
+		return nil, fmt.Errorf("token was issued for %q", g.Login)
+	}
+
+	return &g, nil
+}
+
+// sign returns the HMAC-SHA256 of the base64url encoded payload of a break-glass token.
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,211 @@
+package bypass
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func breakGlassToken(t *testing.T, secret string, g *grant) string {
+	data, err := json.Marshal(g)
+	require.NoError(t, err)
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(secret), payload))
+}
+
+func TestPolicy_Allows(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	valid := &grant{Login: "jane", Iat: now.Add(-time.Minute).Unix(), Exp: now.Add(time.Hour).Unix(), Reason: "INC-1234"}
+
+	tests := []struct {
+		name                    string
+		identity                *identity.Identity
+		token                   string
+		expectedAllow           bool
+		expectedRule            string
+		expectedBreakGlassError bool
+	}{
+		{
+			name:          "it should bypass a bypassing role",
+			identity:      &identity.Identity{User: &grafana.User{Login: "jane"}, Role: "admin"},
+			expectedAllow: true,
+			expectedRule:  "role:admin",
+		},
+		{
+			name: "it should bypass a member of a bypassing team",
+			identity: &identity.Identity{
+				User:  &grafana.User{Login: "jane"},
+				Teams: []*grafana.Team{{ID: 1, Name: "payment"}, {ID: 2, Name: "oncall"}},
+				Role:  "Viewer",
+			},
+			expectedAllow: true,
+			expectedRule:  "team:oncall",
+		},
+		{
+			name:          "it should bypass a bypassing login",
+			identity:      &identity.Identity{User: &grafana.User{Login: "SRE-Bot"}},
+			expectedAllow: true,
+			expectedRule:  "login:SRE-Bot",
+		},
+		{
+			name:     "it should enforce the other users",
+			identity: &identity.Identity{User: &grafana.User{Login: "jane"}, Role: "Editor"},
+		},
+		{
+			name:          "it should bypass a valid break-glass token",
+			identity:      &identity.Identity{User: &grafana.User{Login: "jane"}},
+			token:         breakGlassToken(t, "secret", valid),
+			expectedAllow: true,
+			expectedRule:  RuleBreakGlass,
+		},
+		{
+			name:                    "it should reject a token signed with another secret",
+			identity:                &identity.Identity{User: &grafana.User{Login: "jane"}},
+			token:                   breakGlassToken(t, "other", valid),
+			expectedBreakGlassError: true,
+		},
+		{
+			name:     "it should reject an expired token",
+			identity: &identity.Identity{User: &grafana.User{Login: "jane"}},
+			token: breakGlassToken(t, "secret", &grant{
+				Login: "jane",
+				Iat:   now.Add(-time.Hour).Unix(),
+				Exp:   now.Add(-time.Minute).Unix(),
+			}),
+			expectedBreakGlassError: true,
+		},
+		{
+			name:     "it should reject a token valid for longer than the max duration",
+			identity: &identity.Identity{User: &grafana.User{Login: "jane"}},
+			token: breakGlassToken(t, "secret", &grant{
+				Login: "jane",
+				Iat:   now.Unix(),
+				Exp:   now.Add(48 * time.Hour).Unix(),
+			}),
+			expectedBreakGlassError: true,
+		},
+		{
+			name:     "it should reject a token minted long before its use with a far expiry",
+			identity: &identity.Identity{User: &grafana.User{Login: "jane"}},
+			token: breakGlassToken(t, "secret", &grant{
+				Login: "jane",
+				Iat:   now.Add(-30 * 24 * time.Hour).Unix(),
+				Exp:   now.Add(time.Hour).Unix(),
+			}),
+			expectedBreakGlassError: true,
+		},
+		{
+			name:     "it should reject a token without issuance time",
+			identity: &identity.Identity{User: &grafana.User{Login: "jane"}},
+			token: breakGlassToken(t, "secret", &grant{
+				Login: "jane",
+				Exp:   now.Add(time.Hour).Unix(),
+			}),
+			expectedBreakGlassError: true,
+		},
+		{
+			name:     "it should reject a token issued in the future",
+			identity: &identity.Identity{User: &grafana.User{Login: "jane"}},
+			token: breakGlassToken(t, "secret", &grant{
+				Login: "jane",
+				Iat:   now.Add(time.Hour).Unix(),
+				Exp:   now.Add(2 * time.Hour).Unix(),
+			}),
+			expectedBreakGlassError: true,
+		},
+		{
+			name:                    "it should reject a token issued for another user",
+			identity:                &identity.Identity{User: &grafana.User{Login: "john"}},
+			token:                   breakGlassToken(t, "secret", valid),
+			expectedBreakGlassError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(&PolicyDeps{
+				Roles:                 []string{"Admin"},
+				Teams:                 []string{"oncall"},
+				Logins:                []string{"sre-bot"},
+				BreakGlassHeader:      "X-Giam-Break-Glass",
+				BreakGlassSecret:      "secret",
+				BreakGlassMaxDuration: 4 * time.Hour,
+				Logger:                log.New("FATAL"),
+			})
+			require.NoError(t, err)
+
+			policy.now = func() time.Time { return now }
+
+			event := &audit.Event{}
+
+			req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+			require.NoError(t, err)
+
+			req = req.WithContext(audit.WithEvent(req.Context(), event))
+
+			if tt.token != "" {
+				req.Header.Set("X-Giam-Break-Glass", tt.token)
+			}
+
+			assert.Equal(t, tt.expectedAllow, policy.Allows(req, tt.identity))
+			assert.Equal(t, "", req.Header.Get("X-Giam-Break-Glass"))
+			assert.Equal(t, tt.expectedRule, event.Rule)
+			assert.Equal(t, tt.expectedBreakGlassError, event.BreakGlassError != "")
+
+			if tt.expectedAllow {
+				assert.Equal(t, audit.DecisionBypass, event.Decision)
+			}
+		})
+	}
+}
+
+func TestPolicy_WithToken(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	policy, err := NewPolicy(&PolicyDeps{
+		BreakGlassHeader:      "X-Giam-Break-Glass",
+		BreakGlassSecret:      "secret",
+		BreakGlassMaxDuration: 4 * time.Hour,
+		Logger:                log.New("FATAL"),
+	})
+	require.NoError(t, err)
+
+	policy.now = func() time.Time { return now }
+
+	req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("X-Giam-Break-Glass", breakGlassToken(t, "secret", &grant{
+		Login: "jane",
+		Iat:   now.Unix(),
+		Exp:   now.Add(time.Hour).Unix(),
+	}))
+
+	req = policy.WithToken(req)
+
+	// The token is no longer forwarded upstream, it is still verified.
+	assert.Equal(t, "", req.Header.Get("X-Giam-Break-Glass"))
+	assert.True(t, policy.Allows(req, &identity.Identity{User: &grafana.User{Login: "jane"}}))
+}
+
+func TestNewPolicy_Disabled(t *testing.T) {
+	policy, err := NewPolicy(&PolicyDeps{BreakGlassHeader: "X-Giam-Break-Glass", Logger: log.New("FATAL")})
+	require.NoError(t, err)
+	assert.True(t, policy == nil)
+
+	req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+	require.NoError(t, err)
+
+	assert.False(t, policy.Allows(req, &identity.Identity{User: &grafana.User{Login: "jane"}}))
+}
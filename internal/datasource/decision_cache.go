@@ -0,0 +1,180 @@
+package datasource
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/cache"
+	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+)
+
+// decisionsMaxEntries bounds the number of cached decisions.
+const decisionsMaxEntries = 10000
+
+// DecisionCache keeps the decisions taken for the queries of a user, so the identical queries of repeated dashboard
+// refreshes are authorized without calling Giam. A nil DecisionCache caches nothing.
+type DecisionCache struct {
+	decisions *cache.Cache
+	hashSvc   hash.Service
+	metrics   *metrics.Cache
+}
+
+type DecisionCacheDeps struct {
+	HashSvc hash.Service
+	// TTL is how long a decision is kept, zero disables the cache.
+	TTL time.Duration
+	// Name tells the decision caches apart in the metrics, e.g. "loki_decisions".
+	Name string
+	// Metrics records the hits and misses of the cache, nil disables it.
+	Metrics *metrics.Registry
+}
+
+func NewDecisionCache(deps *DecisionCacheDeps) *DecisionCache {
+	if deps.TTL <= 0 {
+		return nil
+	}
+
+	return &DecisionCache{
+		decisions: cache.New(decisionsMaxEntries, deps.TTL),
+		hashSvc:   deps.HashSvc,
+		metrics:   metrics.NewCache(deps.Metrics, deps.Name),
+	}
+}
+
+// decision holds the rewritten expressions rather than the rewritten queries, so a decision also applies to the
+// queries whose time range, interval or max data points changed since it was taken.
+type decision struct {
+	exprs      []string
+	message    string
+	statusCode int
+}
+
+// decisionKey is what a decision depends on. Grafana sends the teams in no particular order and the query fields
+// other than the datasource and the expression are never changed by the authorization. The users and teams are told
+// apart by their names too, the identity providers other than grafana leave their ids to zero, e.g. the auth proxy
+// users and the JWT groups which aren't mapped to a team.
+type decisionKey struct {
+	UserID    int               `json:"userId"`
+	UserLogin string            `json:"userLogin"`
+	UserEmail string            `json:"userEmail"`
+	OrgID     int               `json:"orgId"`
+	Teams     []decisionKeyTeam `json:"teams"`
+	Queries   []decisionKeyExpr `json:"queries"`
+}
+
+type decisionKeyTeam struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type decisionKeyExpr struct {
+	Datasource string `json:"datasource"`
+	Expr       string `json:"expr"`
+}
+
+// Get returns the decision taken for the same user, teams and queries, applied to the given queries.
+func (c *DecisionCache) Get(payload *AuthorizeQueriesReq) (*AuthorizedQueries, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	key, err := c.key(payload)
+	if err != nil {
+		return nil, false
+	}
+
+	value, ok := c.decisions.Get(key)
+	c.metrics.Record(ok)
+
+	if !ok {
+		return nil, false
+	}
+
+	d := value.(*decision)
+
+	if d.statusCode != http.StatusOK {
+		return &AuthorizedQueries{Message: d.message, StatusCode: d.statusCode}, true
+	}
+
+	queries := make([]interface{}, len(payload.Queries))
+
+	for i, query := range payload.Queries {
+		rewritten, err := RewriteQueryExpr(query, func(string) (string, error) {
+			return d.exprs[i], nil
+		})
+		if err != nil {
+			return nil, false
+		}
+
+		queries[i] = rewritten
+	}
+
+	return &AuthorizedQueries{Queries: queries, StatusCode: http.StatusOK}, true
+}
+
+// Set keeps the decision taken for the queries of the payload.
+func (c *DecisionCache) Set(payload *AuthorizeQueriesReq, resp *AuthorizedQueries) {
+	if c == nil {
+		return
+	}
+
+	key, err := c.key(payload)
+	if err != nil {
+		return
+	}
+
+	d := &decision{message: resp.Message, statusCode: resp.StatusCode}
+
+	if resp.StatusCode == http.StatusOK {
+		d.exprs = make([]string, len(resp.Queries))
+
+		for i, query := range resp.Queries {
+			q, ok := query.(map[string]interface{})
+			if !ok {
+				return
+			}
+
+			d.exprs[i], _ = q["expr"].(string)
+		}
+	}
+
+	c.decisions.Set(key, d)
+}
+
+func (c *DecisionCache) key(payload *AuthorizeQueriesReq) (string, error) {
+	key := decisionKey{
+		OrgID:   payload.OrgID,
+		Teams:   make([]decisionKeyTeam, len(payload.Teams)),
+		Queries: make([]decisionKeyExpr, len(payload.Queries)),
+	}
+
+	if payload.User != nil {
+		key.UserID = payload.User.ID
+		key.UserLogin = payload.User.Login
+		key.UserEmail = payload.User.Email
+	}
+
+	for i, team := range payload.Teams {
+		key.Teams[i] = decisionKeyTeam{ID: team.ID, Name: team.Name}
+	}
+
+	sort.Slice(key.Teams, func(i, j int) bool {
+		if key.Teams[i].ID != key.Teams[j].ID {
+			return key.Teams[i].ID < key.Teams[j].ID
+		}
+
+		return key.Teams[i].Name < key.Teams[j].Name
+	})
+
+	for i, query := range payload.Queries {
+		key.Queries[i] = decisionKeyExpr{
+			Datasource: QueryDatasourceUID(query),
+			Expr:       strings.TrimSpace(QueryExpr(query)),
+		}
+	}
+
+	return c.hashSvc.HashSlice(key)
+}
@@ -0,0 +1,321 @@
+package datasource
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/internal/types"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// DirectAPI enforces the requests sent to the HTTP API of a datasource directly, without grafana in front. It is
+// shared by the direct handlers of every datasource type: it resolves the identity of the requests, rewrites their
+// expressions the same way as the grafana queries, and forwards them, leaving the filter of the answered responses to
+// the handlers.
+type DirectAPI struct {
+	identities    identity.Resolver
+	authorizer    QueryAuthorizer
+	datasource    Datasource
+	datasourceUID string
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
+	logger        *log.Logger
+}
+
+type DirectAPIDeps struct {
+	Identities identity.Resolver
+	// Authorizer rewrites the expressions, it is the authorizer of the grafana queries of the datasource type.
+	Authorizer QueryAuthorizer
+	Datasource Datasource
+	// DatasourceUID is the uid of the grafana datasource whose policies are enforced.
+	DatasourceUID string
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the original requests and the unfiltered responses, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
+}
+
+func NewDirectAPI(deps *DirectAPIDeps) *DirectAPI {
+	return &DirectAPI{
+		identities:    deps.Identities,
+		authorizer:    deps.Authorizer,
+		datasource:    deps.Datasource,
+		datasourceUID: deps.DatasourceUID,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
+		logger:        deps.Logger,
+	}
+}
+
+// Authenticate reads the parameters and resolves the identity of the request to the endpoint, whose failure mode is
+// audited. It returns false when the request was already answered: rejected, or forwarded as is by a break-glass
+// token.
+func (a *DirectAPI) Authenticate(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	endpoint string,
+) (*APIParams, *identity.Identity, bool) {
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(endpoint)
+	event.SetShadow(a.shadow)
+
+	// Requests without credentials are rejected before reaching the upstream.
+	_, err := a.identities.Credentials(req)
+	if err != nil {
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return nil, nil, false
+	}
+
+	params, err := ReadAPIParams(req)
+	if err != nil {
+		http.Error(rw, "Unable to read request body", http.StatusBadRequest)
+
+		return nil, nil, false
+	}
+
+	id, err := a.identities.Resolve(req)
+	if err != nil {
+		a.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
+
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return nil, nil, false
+	}
+
+	event.SetIdentity(id)
+
+	if a.bypass.Allows(req, id) {
+		params.Forward(rw, req, next)
+
+		return nil, nil, false
+	}
+
+	return params, id, true
+}
+
+// RewriteQuery rewrites the expression of the query parameter, as Rewrite does. The request without query is
+// rejected, it would reach the upstream unenforced.
+func (a *DirectAPI) RewriteQuery(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *APIParams,
+	id *identity.Identity,
+	endpoint string,
+) bool {
+	if params.Get("query") == "" {
+		http.Error(rw, "Missing query parameter", http.StatusBadRequest)
+
+		return false
+	}
+
+	return a.Rewrite(rw, req, next, params, "query", id, endpoint)
+}
+
+// Rewrite rewrites every expression of the parameter through the authorizer, with the failure mode of the endpoint. It
+// returns false when the request was already answered: rejected, or forwarded as is in shadow mode.
+func (a *DirectAPI) Rewrite(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *APIParams,
+	key string,
+	id *identity.Identity,
+	endpoint string,
+) bool {
+	exprs := params.All(key)
+	if len(exprs) == 0 {
+		return true
+	}
+
+	event := audit.FromContext(req.Context())
+
+	queries := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		queries[i] = map[string]interface{}{
+			"refId":      key,
+			"expr":       expr,
+			"datasource": map[string]interface{}{"uid": a.datasourceUID, "type": string(a.datasource)},
+		}
+	}
+
+	authorized, err := a.authorize(req.Context(), endpoint, &AuthorizeQueriesReq{
+		User:    id.User,
+		Teams:   id.Teams,
+		OrgID:   id.OrgID,
+		Queries: queries,
+	})
+	if err != nil {
+		a.logger.Ctx(req.Context()).Debugw("unable to authorize the expressions", "datasource", a.datasource, "err", err)
+
+		event.SetDecision(audit.DecisionError)
+
+		if a.shadow {
+			params.Forward(rw, req, next)
+
+			return false
+		}
+
+		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
+
+		return false
+	}
+
+	if authorized.StatusCode != http.StatusOK {
+		event.SetDecision(audit.DecisionDeny)
+
+		if a.shadow {
+			a.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the denied request",
+				"path", req.URL.Path, "status", authorized.StatusCode, "message", authorized.Message)
+
+			params.Forward(rw, req, next)
+
+			return false
+		}
+
+		http.Error(rw, authorized.Message, authorized.StatusCode)
+
+		return false
+	}
+
+	rewritten := make([]string, len(exprs))
+
+	for i, expr := range exprs {
+		rewritten[i] = QueryExpr(authorized.Queries[i])
+
+		event.AddQuery(&audit.Query{
+			DatasourceUID:  a.datasourceUID,
+			DatasourceType: string(a.datasource),
+			Original:       expr,
+			Rewritten:      rewritten[i],
+		})
+
+		if a.shadow && expr != rewritten[i] {
+			a.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the original expression",
+				"path", req.URL.Path, "original", expr, "rewritten", rewritten[i])
+		}
+	}
+
+	if !a.shadow {
+		params.Set(key, rewritten...)
+	}
+
+	return true
+}
+
+// Forward forwards the rewritten request, and its response as answered.
+func (a *DirectAPI) Forward(rw http.ResponseWriter, req *http.Request, next http.Handler, params *APIParams) {
+	audit.FromContext(req.Context()).SetDecision(audit.DecisionAllow)
+
+	params.Forward(rw, req, next)
+}
+
+// Filter forwards the rewritten request, decodes the answered response into resp and filters it with filter, which
+// returns the number of items it filtered out of resp, or false when Giam can't be reached. The errors answered by the
+// upstream are forwarded as is, as is the unfiltered response in shadow mode.
+func (a *DirectAPI) Filter(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *APIParams,
+	resp interface{},
+	filter func() (int, bool),
+) {
+	event := audit.FromContext(req.Context())
+
+	w := &types.ResponseWriter{
+		ResponseWriter: rw,
+		Body:           &bytes.Buffer{},
+		Status:         http.StatusOK,
+	}
+
+	params.Forward(w, req, next)
+
+	upstreamBody := w.Body.Bytes()
+
+	err := ReadResponse(w, resp)
+	if err != nil || w.Status != http.StatusOK {
+		// Errors answered by the upstream don't carry anything to filter.
+		event.SetDecision(audit.DecisionAllow)
+
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	filtered, ok := filter()
+	if !ok {
+		event.SetDecision(audit.DecisionError)
+
+		if a.shadow {
+			rw.WriteHeader(w.Status)
+			rw.Write(upstreamBody)
+
+			return
+		}
+
+		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	event.SetDecision(audit.DecisionAllow)
+	event.SetFiltered(filtered)
+
+	if a.shadow {
+		if filtered > 0 {
+			a.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the unfiltered response",
+				"path", req.URL.Path, "filtered", filtered)
+		}
+
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	WriteResponse(rw, w.Status, resp)
+}
+
+// authorize rewrites the expressions with the authorizer, and applies the failure policy of the endpoint when Giam
+// can't be reached.
+func (a *DirectAPI) authorize(
+	ctx context.Context,
+	endpoint string,
+	payload *AuthorizeQueriesReq,
+) (*AuthorizedQueries, error) {
+	result, err := a.authorizer.AuthorizeQueries(ctx, payload)
+	if err == nil {
+		a.failurePolicy.Remember(string(a.datasource), endpoint, payload, result)
+		audit.FromContext(ctx).SetGiamStatusCode(result.StatusCode)
+
+		return result, nil
+	}
+
+	decision, ok := a.failurePolicy.Fallback(
+		ctx,
+		string(a.datasource),
+		endpoint,
+		payload,
+		err,
+		&AuthorizedQueries{Queries: payload.Queries, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, err
+	}
+
+	return decision.(*AuthorizedQueries), nil
+}
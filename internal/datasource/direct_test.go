@@ -0,0 +1,125 @@
+package datasource
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestDirectAPI(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		params             url.Values
+		authorizer         *MockQueryAuthorizer
+		filterOK           bool
+		shadow             bool
+		expectedQuery      string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:               "It should rewrite the query and filter the response",
+			params:             url.Values{"query": {"up"}},
+			authorizer:         NewRewritingQueryAuthorizer(`up{team="payment"}`),
+			filterOK:           true,
+			expectedQuery:      `up{team="payment"}`,
+			expectedBody:       `{"data":["payment"]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should reject the request without query",
+			params:             url.Values{"time": {"1700000000"}},
+			authorizer:         &MockQueryAuthorizer{},
+			expectedBody:       "Missing query parameter",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:   "It should forward the original denied request in shadow mode",
+			params: url.Values{"query": {"up"}},
+			authorizer: &MockQueryAuthorizer{AuthorizedQueries: &AuthorizedQueries{
+				Message:    "no policy allows access to the datasource",
+				StatusCode: http.StatusForbidden,
+			}},
+			shadow:             true,
+			expectedQuery:      "up",
+			expectedBody:       `{"data": ["menu", "payment"]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should reject the response which can't be filtered",
+			params:             url.Values{"query": {"up"}},
+			authorizer:         NewRewritingQueryAuthorizer(`up{team="payment"}`),
+			filterOK:           false,
+			expectedQuery:      `up{team="payment"}`,
+			expectedBody:       "Unable to communicate with Giam service",
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:               "It should forward the response which can't be filtered in shadow mode",
+			params:             url.Values{"query": {"up"}},
+			authorizer:         NewRewritingQueryAuthorizer(`up{team="payment"}`),
+			filterOK:           false,
+			shadow:             true,
+			expectedQuery:      "up",
+			expectedBody:       `{"data": ["menu", "payment"]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query?"+tt.params.Encode(), nil)
+			req.RemoteAddr = "192.0.2.10:41234"
+			req.Header.Set("X-Webauth-User", "jdoe")
+
+			rr := httptest.NewRecorder()
+
+			api := NewDirectAPI(&DirectAPIDeps{
+				Identities: identity.NewAuthProxy(&identity.AuthProxyDeps{
+					UserHeader: "X-Webauth-User",
+					Trusted:    []*net.IPNet{trusted},
+					Logger:     log.New("FATAL"),
+				}),
+				Authorizer:    tt.authorizer,
+				Datasource:    Prometheus,
+				DatasourceUID: "P0dfd3df3dfd",
+				Shadow:        tt.shadow,
+				Logger:        log.New("FATAL"),
+			})
+
+			next := &mocks.NextHandler{RespBody: []byte(`{"data": ["menu", "payment"]}`)}
+
+			params, id, ok := api.Authenticate(rr, req, next, failure.EndpointQuery)
+			assert.True(t, ok)
+
+			if api.RewriteQuery(rr, req, next, params, id, failure.EndpointQuery) {
+				var resp struct {
+					Data []string `json:"data"`
+				}
+
+				api.Filter(rr, req, next, params, &resp, func() (int, bool) {
+					resp.Data = []string{"payment"}
+
+					return 1, tt.filterOK
+				})
+			}
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+			assert.Equal(t, tt.expectedBody, strings.TrimSpace(rr.Body.String()))
+			assert.Equal(t, tt.expectedQuery, req.URL.Query().Get("query"))
+		})
+	}
+}
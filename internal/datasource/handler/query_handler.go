@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// queryEndpointPattern this endpoint is for grafana querying. We don't include the base url in the pattern because
+// in proxy it will be without the base url.
+const queryEndpointPattern = "^/api/ds/query"
+
+var queryEndpointRegexExp = regexp.MustCompile(queryEndpointPattern)
+
+// QueryHandler routes every query of a grafana query request to the authorizer of its datasource type. It supports
+// the "-- Mixed --" datasource where loki and prometheus queries are sent in the same request.
+type QueryHandler struct {
+	identities    identity.Resolver
+	resolver      *datasource.Resolver
+	authorizers   map[datasource.Datasource]datasource.QueryAuthorizer
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
+	logger        *log.Logger
+}
+
+type QueryHandlerDeps struct {
+	Identities identity.Resolver
+	Resolver   *datasource.Resolver
+	// Authorizers are the query authorizers by datasource type, queries of other types are forwarded untouched.
+	Authorizers   map[datasource.Datasource]datasource.QueryAuthorizer
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the original queries once authorized, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
+}
+
+// queryGroup is the set of queries of a request which target the same datasource type.
+type queryGroup struct {
+	datasource datasource.Datasource
+	indexes    []int
+	result     *datasource.AuthorizedQueries
+	err        error
+}
+
+func NewQueryHandler(deps *QueryHandlerDeps) handler.Handler {
+	return &QueryHandler{
+		identities:    deps.Identities,
+		resolver:      deps.Resolver,
+		authorizers:   deps.Authorizers,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
+		logger:        deps.Logger,
+	}
+}
+
+// Match doesn't rely on the ds_type query parameter since it is sent by the client, the type of every query is
+// resolved from grafana in Handle instead.
+func (q *QueryHandler) Match(req *http.Request) bool {
+	return queryEndpointRegexExp.MatchString(req.RequestURI)
+}
+
+func (q *QueryHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	q.logger.Ctx(req.Context()).Debugw("instantiated a query authorize")
+
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(failure.EndpointQuery)
+	event.SetShadow(q.shadow)
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(rw, "Unable to read request body", http.StatusBadRequest)
+
+		return
+	}
+
+	defer req.Body.Close()
+
+	var queryReq grafana.QueryReq
+	if err := json.Unmarshal(body, &queryReq); err != nil {
+		http.Error(rw, "Invalid JSON", http.StatusBadRequest)
+
+		return
+	}
+
+	creds, err := q.identities.Credentials(req)
+	if err != nil {
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	// The identity is resolved first, the datasources are looked up in its organization, which the request may not
+	// select.
+	id, err := q.identities.Resolve(req)
+	if err != nil {
+		q.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
+
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	event.SetIdentity(id)
+
+	if creds.OrgID == 0 {
+		orgCreds := *creds
+		orgCreds.OrgID = id.OrgID
+		creds = &orgCreds
+	}
+
+	types, err := q.resolver.ResolveQueries(req.Context(), creds, queryReq.Queries)
+	if err != nil {
+		q.logger.Ctx(req.Context()).Debugw("unable to resolve the queries datasource", "err", err)
+
+		http.Error(rw, "Unable to resolve datasource", http.StatusBadRequest)
+
+		return
+	}
+
+	groups := q.groupQueries(types)
+	if len(groups) == 0 {
+		q.forward(rw, req, next, body)
+
+		return
+	}
+
+	if q.bypass.Allows(req, id) {
+		q.forward(rw, req, next, body)
+
+		return
+	}
+
+	q.authorizeGroups(req.Context(), id, queryReq.Queries, groups)
+
+	for _, group := range groups {
+		if group.err != nil {
+			q.logger.Ctx(req.Context()).Debugw("unable to authorize queries", "datasource", group.datasource, "err", group.err)
+
+			event.SetDecision(audit.DecisionError)
+
+			if q.shadow {
+				q.forward(rw, req, next, body)
+
+				return
+			}
+
+			http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
+
+			return
+		}
+
+		if group.result.StatusCode != http.StatusOK {
+			event.SetDecision(audit.DecisionDeny)
+
+			if q.shadow {
+				q.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the denied queries",
+					"datasource", group.datasource, "status", group.result.StatusCode, "message", group.result.Message)
+
+				q.forward(rw, req, next, body)
+
+				return
+			}
+
+			http.Error(rw, group.result.Message, group.result.StatusCode)
+
+			return
+		}
+	}
+
+	for _, group := range groups {
+		for i, index := range group.indexes {
+			query := &audit.Query{
+				DatasourceUID:  datasource.QueryDatasourceUID(queryReq.Queries[index]),
+				DatasourceType: string(group.datasource),
+				Original:       datasource.QueryExpr(queryReq.Queries[index]),
+				Rewritten:      datasource.QueryExpr(group.result.Queries[i]),
+			}
+
+			event.AddQuery(query)
+
+			if q.shadow && query.Original != query.Rewritten {
+				q.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the original query",
+					"datasource_uid", query.DatasourceUID, "original", query.Original, "rewritten", query.Rewritten)
+			}
+
+			queryReq.Queries[index] = group.result.Queries[i]
+		}
+	}
+
+	event.SetDecision(audit.DecisionAllow)
+
+	if q.shadow {
+		q.forward(rw, req, next, body)
+
+		return
+	}
+
+	updatedBody, err := json.Marshal(queryReq)
+	if err != nil {
+		http.Error(rw, "Error marshaling JSON", http.StatusInternalServerError)
+
+		return
+	}
+
+	q.logger.Ctx(req.Context()).Debugw("rewritten request", "body", string(updatedBody))
+
+	q.forward(rw, req, next, updatedBody)
+}
+
+// forward sends the request to next with the given body.
+func (q *QueryHandler) forward(rw http.ResponseWriter, req *http.Request, next http.Handler, body []byte) {
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+	req.ContentLength = int64(len(body))
+
+	next.ServeHTTP(rw, req)
+}
+
+// groupQueries groups the query indexes by datasource type, ordered by the position of their first query so the
+// outcome doesn't depend on map iteration order.
+func (q *QueryHandler) groupQueries(types []datasource.Datasource) []*queryGroup {
+	var groups []*queryGroup
+
+	for ds := range q.authorizers {
+		if indexes := datasource.Select(types, ds); len(indexes) > 0 {
+			groups = append(groups, &queryGroup{datasource: ds, indexes: indexes})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].indexes[0] < groups[j].indexes[0]
+	})
+
+	return groups
+}
+
+// authorizeGroups authorizes every group in parallel with the authorizer of its datasource type.
+func (q *QueryHandler) authorizeGroups(
+	ctx context.Context,
+	id *identity.Identity,
+	queries []interface{},
+	groups []*queryGroup,
+) {
+	var wg sync.WaitGroup
+
+	for _, group := range groups {
+		groupQueries := make([]interface{}, len(group.indexes))
+		for i, index := range group.indexes {
+			groupQueries[i] = queries[index]
+		}
+
+		wg.Add(1)
+
+		go func(group *queryGroup, groupQueries []interface{}) {
+			defer wg.Done()
+
+			group.result, group.err = q.authorize(ctx, group.datasource, &datasource.AuthorizeQueriesReq{
+				User:    id.User,
+				Teams:   id.Teams,
+				OrgID:   id.OrgID,
+				Queries: groupQueries,
+			})
+		}(group, groupQueries)
+	}
+
+	wg.Wait()
+}
+
+// authorize authorizes the queries with the authorizer of their datasource type, and applies the failure policy when
+// Giam can't be reached.
+func (q *QueryHandler) authorize(
+	ctx context.Context,
+	ds datasource.Datasource,
+	payload *datasource.AuthorizeQueriesReq,
+) (*datasource.AuthorizedQueries, error) {
+	result, err := q.authorizers[ds].AuthorizeQueries(ctx, payload)
+	if err == nil {
+		q.failurePolicy.Remember(string(ds), failure.EndpointQuery, payload, result)
+		audit.FromContext(ctx).SetGiamStatusCode(result.StatusCode)
+
+		return result, nil
+	}
+
+	decision, ok := q.failurePolicy.Fallback(
+		ctx,
+		string(ds),
+		failure.EndpointQuery,
+		payload,
+		err,
+		&datasource.AuthorizedQueries{Queries: payload.Queries, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, err
+	}
+
+	return decision.(*datasource.AuthorizedQueries), nil
+}
@@ -0,0 +1,454 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func query(expr, uid string) map[string]interface{} {
+	return map[string]interface{}{
+		"expr": expr,
+		"datasource": map[string]interface{}{
+			"uid": uid,
+		},
+	}
+}
+
+func TestQueryHandler_Handle(t *testing.T) {
+	grafanaRepo := &grafana.MockRepo{
+		User:  &grafana.User{ID: 1, Name: "user1"},
+		Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+		Datasources: map[string]*grafana.Datasource{
+			"lokiUID":       {UID: "lokiUID", Type: "loki"},
+			"prometheusUID": {UID: "prometheusUID", Type: "prometheus"},
+		},
+	}
+
+	tests := []struct {
+		name                    string
+		payload                 *grafana.QueryReq
+		withoutSession          bool
+		lokiAuthorizer          *datasource.MockQueryAuthorizer
+		prometheusAuthorizer    *datasource.MockQueryAuthorizer
+		expectedLokiQueries     []interface{}
+		expectedPrometheusQuery []interface{}
+		expectedBody            interface{}
+		expectedStatusCode      int
+	}{
+		{
+			name: "Test with mixed queries it should route and reassemble every query in order",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					query(`up{cluster="customer1"}`, "prometheusUID"),
+					query(`{cluster="customer1"}`, "lokiUID"),
+					query(`$A + $B`, "__expr__"),
+					query(`http_requests_total`, "prometheusUID"),
+				},
+			},
+			lokiAuthorizer: &datasource.MockQueryAuthorizer{
+				AuthorizedQueries: &datasource.AuthorizedQueries{
+					Queries: []interface{}{
+						query(`{cluster="customer1", team="menu"}`, "lokiUID"),
+					},
+					StatusCode: http.StatusOK,
+				},
+			},
+			prometheusAuthorizer: &datasource.MockQueryAuthorizer{
+				AuthorizedQueries: &datasource.AuthorizedQueries{
+					Queries: []interface{}{
+						query(`up{cluster="customer1", team="menu"}`, "prometheusUID"),
+						query(`http_requests_total{team="menu"}`, "prometheusUID"),
+					},
+					StatusCode: http.StatusOK,
+				},
+			},
+			expectedLokiQueries: []interface{}{
+				query(`{cluster="customer1"}`, "lokiUID"),
+			},
+			expectedPrometheusQuery: []interface{}{
+				query(`up{cluster="customer1"}`, "prometheusUID"),
+				query(`http_requests_total`, "prometheusUID"),
+			},
+			expectedBody: &grafana.QueryReq{
+				Queries: []interface{}{
+					query(`up{cluster="customer1", team="menu"}`, "prometheusUID"),
+					query(`{cluster="customer1", team="menu"}`, "lokiUID"),
+					query(`$A + $B`, "__expr__"),
+					query(`http_requests_total{team="menu"}`, "prometheusUID"),
+				},
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Test without any loki or prometheus query it should forward the request untouched",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					query(`$A * 2`, "__expr__"),
+				},
+			},
+			lokiAuthorizer:       &datasource.MockQueryAuthorizer{},
+			prometheusAuthorizer: &datasource.MockQueryAuthorizer{},
+			expectedBody: &grafana.QueryReq{
+				Queries: []interface{}{
+					query(`$A * 2`, "__expr__"),
+				},
+			},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "Test when one of the datasources rejects its queries",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					query(`up`, "prometheusUID"),
+					query(`{cluster="customer1"}`, "lokiUID"),
+				},
+			},
+			lokiAuthorizer: &datasource.MockQueryAuthorizer{
+				AuthorizedQueries: &datasource.AuthorizedQueries{
+					Message:    "No Team Assigned",
+					StatusCode: http.StatusPreconditionFailed,
+				},
+			},
+			prometheusAuthorizer: &datasource.MockQueryAuthorizer{
+				AuthorizedQueries: &datasource.AuthorizedQueries{
+					Queries:    []interface{}{query(`up{team="menu"}`, "prometheusUID")},
+					StatusCode: http.StatusOK,
+				},
+			},
+			expectedBody:       "No Team Assigned",
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name: "Test when giam is unreachable",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					query(`up`, "prometheusUID"),
+				},
+			},
+			lokiAuthorizer:       &datasource.MockQueryAuthorizer{},
+			prometheusAuthorizer: &datasource.MockQueryAuthorizer{Error: errors.New("connection refused")},
+			expectedBody:         "Unable to communicate with Giam service",
+			expectedStatusCode:   http.StatusPreconditionFailed,
+		},
+		{
+			name: "Test with a query without datasource uid",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					map[string]interface{}{"expr": `up`},
+				},
+			},
+			lokiAuthorizer:       &datasource.MockQueryAuthorizer{},
+			prometheusAuthorizer: &datasource.MockQueryAuthorizer{},
+			expectedBody:         "Unable to resolve datasource",
+			expectedStatusCode:   http.StatusBadRequest,
+		},
+		{
+			name: "Test without grafana session",
+			payload: &grafana.QueryReq{
+				Queries: []interface{}{
+					query(`up`, "prometheusUID"),
+				},
+			},
+			withoutSession:       true,
+			lokiAuthorizer:       &datasource.MockQueryAuthorizer{},
+			prometheusAuthorizer: &datasource.MockQueryAuthorizer{},
+			expectedBody:         "Forbidden",
+			expectedStatusCode:   http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonPayload, err := json.Marshal(tt.payload)
+
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/ds/query?ds_type=loki", bytes.NewBuffer(jsonPayload))
+			req.Header.Set("Content-Type", "application/json")
+
+			if !tt.withoutSession {
+				req.AddCookie(&http.Cookie{
+					Name:  "grafana_session",
+					Value: "mocked_session_value",
+				})
+			}
+
+			rr := httptest.NewRecorder()
+			handler := &QueryHandler{
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: grafanaRepo,
+					Logger:      log.New("FATAL"),
+				}),
+				resolver: datasource.NewResolver(&datasource.ResolverDeps{
+					GrafanaRepo: grafanaRepo,
+					Logger:      log.New("FATAL"),
+				}),
+				authorizers: map[datasource.Datasource]datasource.QueryAuthorizer{
+					datasource.Loki:       tt.lokiAuthorizer,
+					datasource.Prometheus: tt.prometheusAuthorizer,
+				},
+				logger: log.New("FATAL"),
+			}
+
+			handler.Handle(rr, req, &mocks.NextHandler{})
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			if expectedCastedBody, matched := tt.expectedBody.(*grafana.QueryReq); matched {
+				var actualRequestBody grafana.QueryReq
+
+				modifiedBody, err := io.ReadAll(req.Body)
+				require.NoError(t, err)
+
+				err = json.Unmarshal(modifiedBody, &actualRequestBody)
+
+				require.NoError(t, err)
+				assert.Equal(t, req.ContentLength, int64(len(modifiedBody)))
+				assert.CompareJson(t, expectedCastedBody.Queries, actualRequestBody.Queries)
+				assert.CompareJson(t, tt.expectedLokiQueries, tt.lokiAuthorizer.Received)
+				assert.CompareJson(t, tt.expectedPrometheusQuery, tt.prometheusAuthorizer.Received)
+			} else {
+				wantedBody := strings.TrimSpace(rr.Body.String())
+				expectedCastedBody := strings.TrimSpace(tt.expectedBody.(string))
+
+				if expectedCastedBody != wantedBody {
+					t.Errorf("Handle() = %v, want = %v", wantedBody, expectedCastedBody)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryHandler_HandleBypass(t *testing.T) {
+	grafanaRepo := &grafana.MockRepo{
+		User:  &grafana.User{ID: 1, Login: "user1"},
+		Teams: []*grafana.Team{{ID: 1, Name: "oncall"}},
+		Datasources: map[string]*grafana.Datasource{
+			"prometheusUID": {UID: "prometheusUID", Type: "prometheus"},
+		},
+	}
+
+	bypassPolicy, err := bypass.NewPolicy(&bypass.PolicyDeps{Teams: []string{"oncall"}, Logger: log.New("FATAL")})
+	require.NoError(t, err)
+
+	prometheusAuthorizer := &datasource.MockQueryAuthorizer{Error: errors.New("connection refused")}
+	handler := &QueryHandler{
+		identities: identity.NewSession(&identity.SessionDeps{GrafanaRepo: grafanaRepo, Logger: log.New("FATAL")}),
+		resolver: datasource.NewResolver(&datasource.ResolverDeps{
+			GrafanaRepo: grafanaRepo,
+			Logger:      log.New("FATAL"),
+		}),
+		authorizers: map[datasource.Datasource]datasource.QueryAuthorizer{
+			datasource.Prometheus: prometheusAuthorizer,
+		},
+		bypass: bypassPolicy,
+		logger: log.New("FATAL"),
+	}
+
+	payload := []byte(`{"queries":[{"expr":"up","datasource":{"uid":"prometheusUID"}}],"from":"now-1h","to":"now"}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/ds/query", bytes.NewBuffer(payload))
+	req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "mocked_session_value"})
+
+	rr := httptest.NewRecorder()
+	handler.Handle(rr, req, &mocks.NextHandler{})
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	forwardedBody, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), string(forwardedBody))
+	assert.True(t, prometheusAuthorizer.Received == nil)
+}
+
+// orgsRepo holds the users by session, and the datasources by organization id.
+type orgsRepo struct {
+	grafana.MockRepo
+	users       map[string]*grafana.User
+	datasources map[int]map[string]*grafana.Datasource
+}
+
+func (o *orgsRepo) GetUser(_ context.Context, creds *grafana.Credentials) (*grafana.User, error) {
+	return o.users[creds.Session], nil
+}
+
+func (o *orgsRepo) GetDatasource(
+	_ context.Context,
+	creds *grafana.Credentials,
+	uid string,
+) (*grafana.Datasource, error) {
+	return o.datasources[creds.OrgID][uid], nil
+}
+
+func TestQueryHandler_HandleOrgs(t *testing.T) {
+	grafanaRepo := &orgsRepo{
+		MockRepo: grafana.MockRepo{Teams: []*grafana.Team{{ID: 1, Name: "team1"}}},
+		users: map[string]*grafana.User{
+			"admin":  {ID: 1, Login: "admin", OrgID: 2},
+			"viewer": {ID: 2, Login: "viewer", OrgID: 1},
+		},
+		datasources: map[int]map[string]*grafana.Datasource{
+			1: {"shared": {UID: "shared", Type: "prometheus"}},
+			2: {"shared": {UID: "shared", Type: "tempo"}},
+		},
+	}
+
+	prometheusAuthorizer := &datasource.MockQueryAuthorizer{AuthorizedQueries: &datasource.AuthorizedQueries{
+		Queries:    []interface{}{query(`up{team="team1"}`, "shared")},
+		StatusCode: http.StatusOK,
+	}}
+
+	handler := &QueryHandler{
+		identities: identity.NewSession(&identity.SessionDeps{GrafanaRepo: grafanaRepo, Logger: log.New("FATAL")}),
+		resolver: datasource.NewResolver(&datasource.ResolverDeps{
+			GrafanaRepo: grafanaRepo,
+			CacheTTL:    time.Minute,
+			Logger:      log.New("FATAL"),
+		}),
+		authorizers: map[datasource.Datasource]datasource.QueryAuthorizer{
+			datasource.Prometheus: prometheusAuthorizer,
+		},
+		logger: log.New("FATAL"),
+	}
+
+	payload := []byte(`{"queries":[{"expr":"up","datasource":{"uid":"shared"}}]}`)
+
+	// Neither request selects its organization, the datasource sharing the uid in the organization of the admin must
+	// not be served to the viewer.
+	for _, session := range []string{"admin", "viewer"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/ds/query", bytes.NewBuffer(payload))
+		req.AddCookie(&http.Cookie{Name: "grafana_session", Value: session})
+
+		rr := httptest.NewRecorder()
+		handler.Handle(rr, req, &mocks.NextHandler{})
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	assert.CompareJson(t, []interface{}{query("up", "shared")}, prometheusAuthorizer.Received)
+}
+
+func TestQueryHandler_HandleShadow(t *testing.T) {
+	tests := []struct {
+		name             string
+		authorized       *datasource.AuthorizedQueries
+		expectedDecision string
+		expectedQueries  int
+	}{
+		{
+			name: "it should forward the original queries Giam rewrote",
+			authorized: &datasource.AuthorizedQueries{
+				Queries:    []interface{}{map[string]interface{}{"expr": `up{team="payment"}`}},
+				StatusCode: http.StatusOK,
+			},
+			expectedDecision: audit.DecisionAllow,
+			expectedQueries:  1,
+		},
+		{
+			name:             "it should forward the queries Giam denied",
+			authorized:       &datasource.AuthorizedQueries{Message: "forbidden", StatusCode: http.StatusForbidden},
+			expectedDecision: audit.DecisionDeny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grafanaRepo := &grafana.MockRepo{
+				User:  &grafana.User{ID: 1, Login: "user1"},
+				Teams: []*grafana.Team{{ID: 1, Name: "payment"}},
+				Datasources: map[string]*grafana.Datasource{
+					"prometheusUID": {UID: "prometheusUID", Type: "prometheus"},
+				},
+			}
+
+			handler := &QueryHandler{
+				identities: identity.NewSession(&identity.SessionDeps{GrafanaRepo: grafanaRepo, Logger: log.New("FATAL")}),
+				resolver: datasource.NewResolver(&datasource.ResolverDeps{
+					GrafanaRepo: grafanaRepo,
+					Logger:      log.New("FATAL"),
+				}),
+				authorizers: map[datasource.Datasource]datasource.QueryAuthorizer{
+					datasource.Prometheus: &datasource.MockQueryAuthorizer{AuthorizedQueries: tt.authorized},
+				},
+				shadow: true,
+				logger: log.New("FATAL"),
+			}
+
+			payload := []byte(`{"queries":[{"expr":"up","datasource":{"uid":"prometheusUID"}}],"from":"now-1h","to":"now"}`)
+
+			event := &audit.Event{}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/ds/query", bytes.NewBuffer(payload))
+			req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "mocked_session_value"})
+			req = req.WithContext(audit.WithEvent(req.Context(), event))
+
+			rr := httptest.NewRecorder()
+			handler.Handle(rr, req, &mocks.NextHandler{})
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+
+			forwardedBody, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, string(payload), string(forwardedBody))
+
+			assert.True(t, event.Shadow)
+			assert.Equal(t, tt.expectedDecision, event.Decision)
+			assert.Equal(t, tt.expectedQueries, len(event.Queries))
+
+			if tt.expectedQueries > 0 {
+				assert.Equal(t, `up{team="payment"}`, event.Queries[0].Rewritten)
+			}
+		})
+	}
+}
+
+func TestQueryHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *http.Request
+		want bool
+	}{
+		{
+			name: "it should return true when the endpoint is for query",
+			req:  httptest.NewRequest(http.MethodPost, "/api/ds/query?ds_type=prometheus", nil),
+			want: true,
+		},
+		{
+			name: "it should return true regardless of the ds_type sent by the client",
+			req:  httptest.NewRequest(http.MethodPost, "/api/ds/query", nil),
+			want: true,
+		},
+		{
+			name: "it should return false when the endpoint is not for query",
+			req:  httptest.NewRequest(http.MethodGet, "/api/datasources/uid/lokiUID/resources/labels", nil),
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &QueryHandler{}
+			if got := q.Match(tt.req); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package label
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// MatchType is the operator of a label matcher, the same operators are used by PromQL and LogQL.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher is a single label constraint such as team=~"payment|menu".
+type Matcher struct {
+	Name  string    `json:"name"`
+	Type  MatchType `json:"type"`
+	Value string    `json:"value"`
+
+	re *regexp.Regexp
+}
+
+// NewMatcher returns a compiled matcher.
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	if err := m.compile(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Compile validates the given matchers and compiles their regular expressions. Matchers decoded from JSON must be
+// compiled before being used concurrently.
+func Compile(matchers []*Matcher) error {
+	for _, m := range matchers {
+		if err := m.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Matcher) compile() error {
+	switch m.Type {
+	case MatchEqual, MatchNotEqual:
+		return nil
+	case MatchRegexp, MatchNotRegexp:
+		// Label regular expressions are always fully anchored.
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid regular expression of label %s: %w", m.Name, err)
+		}
+
+		m.re = re
+
+		return nil
+	default:
+		return fmt.Errorf("invalid match type %q of label %s", m.Type, m.Name)
+	}
+}
+
+// Matches reports whether the label value v satisfies the matcher. A missing label has the empty value.
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp, MatchNotRegexp:
+		re := m.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+				return false
+			}
+		}
+
+		return re.MatchString(v) == (m.Type == MatchRegexp)
+	default:
+		return false
+	}
+}
+
+func (m *Matcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// MatchesLabels reports whether the label set satisfies every matcher.
+func MatchesLabels(matchers []*Matcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AppendMissing appends to existing the matchers it doesn't already contain with the very same operator and value.
+func AppendMissing(existing []*Matcher, matchers []*Matcher) []*Matcher {
+	for _, m := range matchers {
+		if !contains(existing, m) {
+			existing = append(existing, m)
+		}
+	}
+
+	return existing
+}
+
+func contains(matchers []*Matcher, m *Matcher) bool {
+	for _, existing := range matchers {
+		if existing.Name == m.Name && existing.Type == m.Type && existing.Value == m.Value {
+			return true
+		}
+	}
+
+	return false
+}
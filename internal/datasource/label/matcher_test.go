@@ -0,0 +1,58 @@
+package label
+
+import (
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestMatchesLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		matchers []*Matcher
+		labels   map[string]string
+		want     bool
+	}{
+		{
+			name:     "equal matcher",
+			matchers: []*Matcher{{Name: "team", Type: MatchEqual, Value: "payment"}},
+			labels:   map[string]string{"team": "payment"},
+			want:     true,
+		},
+		{
+			name:     "not equal matcher on a missing label",
+			matchers: []*Matcher{{Name: "team", Type: MatchNotEqual, Value: "payment"}},
+			labels:   map[string]string{},
+			want:     true,
+		},
+		{
+			name:     "regexp matchers are fully anchored",
+			matchers: []*Matcher{{Name: "team", Type: MatchRegexp, Value: "pay"}},
+			labels:   map[string]string{"team": "payment"},
+			want:     false,
+		},
+		{
+			name: "every matcher must match",
+			matchers: []*Matcher{
+				{Name: "team", Type: MatchRegexp, Value: "payment|menu"},
+				{Name: "env", Type: MatchNotRegexp, Value: "prod.*"},
+			},
+			labels: map[string]string{"team": "menu", "env": "production"},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, Compile(tt.matchers))
+
+			assert.Equal(t, tt.want, MatchesLabels(tt.matchers, tt.labels))
+		})
+	}
+}
+
+func TestCompile_InvalidMatcher(t *testing.T) {
+	assert.Error(t, Compile([]*Matcher{{Name: "team", Type: MatchRegexp, Value: "("}}))
+	assert.Error(t, Compile([]*Matcher{{Name: "team", Type: "~", Value: "payment"}}))
+}
@@ -0,0 +1,45 @@
+package label
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Unquote returns the value of a string quoted with double quotes, single quotes or backticks, as written in PromQL
+// and LogQL queries.
+func Unquote(s string) (string, error) {
+	if len(s) < 2 {
+		return "", fmt.Errorf("invalid quoted string %s", s)
+	}
+
+	quote := s[0]
+	if s[len(s)-1] != quote {
+		return "", fmt.Errorf("invalid quoted string %s", s)
+	}
+
+	if quote == '`' {
+		return s[1 : len(s)-1], nil
+	}
+
+	var b strings.Builder
+
+	rest := s[1 : len(s)-1]
+	for len(rest) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(rest, quote)
+		if err != nil {
+			return "", err
+		}
+
+		if r < utf8.RuneSelf || !multibyte {
+			b.WriteByte(byte(r))
+		} else {
+			b.WriteRune(r)
+		}
+
+		rest = tail
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// Endpoints of the loki HTTP API enforced by the DirectHandler.
+const (
+	directQuery       = "query"
+	directQueryRange  = "query_range"
+	directSeries      = "series"
+	directLabels      = "labels"
+	directLabelValues = "label_values"
+)
+
+// DirectHandler enforces the requests sent to the loki HTTP API directly, without grafana in front, e.g. by logcli.
+// The LogQL expressions of the query and match[] parameters are rewritten the same way as the grafana queries, and the
+// series and label values answered are filtered the same way as the grafana ones. The label names are not filtered, as
+// in grafana, they are only narrowed down by the rewritten query when one is sent. Every request is enforced with the
+// policies of the configured datasource.
+type DirectHandler struct {
+	api           *datasource.DirectAPI
+	service       loki.Service
+	datasourceUID string
+	pattern       *regexp.Regexp
+	failurePolicy *failure.Policy
+	logger        *log.Logger
+}
+
+type DirectHandlerDeps struct {
+	Identities identity.Resolver
+	// Authorizer rewrites the expressions, it is the authorizer of the loki grafana queries.
+	Authorizer datasource.QueryAuthorizer
+	// Service filters the series and the label values answered.
+	Service loki.Service
+	// DatasourceUID is the uid of the grafana datasource whose policies are enforced.
+	DatasourceUID string
+	// PathPrefix is the path the loki HTTP API is served under, e.g. "/logs", none when empty.
+	PathPrefix    string
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the original requests and the unfiltered responses, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
+}
+
+func NewDirectHandler(deps *DirectHandlerDeps) handler.Handler {
+	endpoints := `(query|query_range|series|labels|label/([^/]+)/values)`
+	pattern := `^` + regexp.QuoteMeta(deps.PathPrefix) + `/loki/api/v1/` + endpoints + `$`
+
+	return &DirectHandler{
+		api: datasource.NewDirectAPI(&datasource.DirectAPIDeps{
+			Identities:    deps.Identities,
+			Authorizer:    deps.Authorizer,
+			Datasource:    datasource.Loki,
+			DatasourceUID: deps.DatasourceUID,
+			FailurePolicy: deps.FailurePolicy,
+			Bypass:        deps.Bypass,
+			Shadow:        deps.Shadow,
+			Logger:        deps.Logger,
+		}),
+		service:       deps.Service,
+		datasourceUID: deps.DatasourceUID,
+		pattern:       regexp.MustCompile(pattern),
+		failurePolicy: deps.FailurePolicy,
+		logger:        deps.Logger,
+	}
+}
+
+func (d *DirectHandler) Match(req *http.Request) bool {
+	return d.pattern.MatchString(req.URL.Path)
+}
+
+func (d *DirectHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	d.logger.Ctx(req.Context()).Debugw("instantiated a loki direct api authorize", "path", req.URL.Path)
+
+	matches := d.pattern.FindStringSubmatch(req.URL.Path)
+
+	endpoint, name := matches[1], matches[2]
+	if name != "" {
+		endpoint = directLabelValues
+	}
+
+	params, id, ok := d.api.Authenticate(rw, req, next, failureEndpoint(endpoint))
+	if !ok {
+		return
+	}
+
+	switch endpoint {
+	case directQuery, directQueryRange:
+		if d.api.RewriteQuery(rw, req, next, params, id, failure.EndpointQuery) {
+			d.api.Forward(rw, req, next, params)
+		}
+	case directSeries:
+		if d.api.Rewrite(rw, req, next, params, "match[]", id, failure.EndpointSeries) {
+			d.handleSeries(rw, req, next, params, id)
+		}
+	case directLabels:
+		// The label names are forwarded unfiltered, as in grafana.
+		if d.api.Rewrite(rw, req, next, params, "query", id, failure.EndpointLabelNames) {
+			d.api.Forward(rw, req, next, params)
+		}
+	case directLabelValues:
+		if d.api.Rewrite(rw, req, next, params, "query", id, failure.EndpointLabelValues) {
+			d.handleLabelValues(rw, req, next, params, id, name)
+		}
+	}
+}
+
+// handleSeries forwards the series request, and filters the answered series.
+func (d *DirectHandler) handleSeries(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *datasource.APIParams,
+	id *identity.Identity,
+) {
+	var seriesResp grafana.SeriesReq
+
+	d.api.Filter(rw, req, next, params, &seriesResp, func() (int, bool) {
+		resp, ok := d.filterSeries(req.Context(), &loki.FilterSeriesReq{
+			User:       id.User,
+			Teams:      id.Teams,
+			OrgID:      id.OrgID,
+			Series:     seriesResp.Series,
+			Datasource: grafana.Datasource{UID: d.datasourceUID},
+		})
+		if !ok {
+			return 0, false
+		}
+
+		filtered := len(seriesResp.Series) - len(resp.Data)
+		seriesResp.Series = resp.Data
+
+		return filtered, true
+	})
+}
+
+// handleLabelValues forwards the label values request, and filters the answered label values.
+func (d *DirectHandler) handleLabelValues(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *datasource.APIParams,
+	id *identity.Identity,
+	name string,
+) {
+	var valuesResp grafana.LabelValuesReq
+
+	d.api.Filter(rw, req, next, params, &valuesResp, func() (int, bool) {
+		resp, ok := d.filterLabelValues(req.Context(), &loki.FilterLabelValuesReq{
+			User:  id.User,
+			Teams: id.Teams,
+			OrgID: id.OrgID,
+			Label: &loki.Label{
+				Name:   name,
+				Values: valuesResp.Data,
+			},
+			Datasource: grafana.Datasource{UID: d.datasourceUID},
+		})
+		if !ok {
+			return 0, false
+		}
+
+		filtered := len(valuesResp.Data) - len(resp.Data)
+		valuesResp.Data = resp.Data
+
+		return filtered, true
+	})
+}
+
+// filterSeries filters the series with Giam, and applies the failure policy when Giam can't be reached. It returns
+// false when the request must be denied.
+func (d *DirectHandler) filterSeries(
+	ctx context.Context,
+	payload *loki.FilterSeriesReq,
+) (*loki.FilterSeriesResp, bool) {
+	resp, err := d.service.FilterSeries(ctx, payload)
+	if err == nil {
+		d.failurePolicy.Remember(string(datasource.Loki), failure.EndpointSeries, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	d.logger.Ctx(ctx).Debugw("unable to send loki filter series request to Giam", "err", err)
+
+	decision, ok := d.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Loki),
+		failure.EndpointSeries,
+		payload,
+		err,
+		&loki.FilterSeriesResp{Data: payload.Series, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*loki.FilterSeriesResp), true
+}
+
+// filterLabelValues filters the label values with Giam, and applies the failure policy when Giam can't be reached.
+// It returns false when the request must be denied.
+func (d *DirectHandler) filterLabelValues(
+	ctx context.Context,
+	payload *loki.FilterLabelValuesReq,
+) (*loki.FilterLabelValuesResp, bool) {
+	resp, err := d.service.FilterLabelValues(ctx, payload)
+	if err == nil {
+		d.failurePolicy.Remember(string(datasource.Loki), failure.EndpointLabelValues, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	d.logger.Ctx(ctx).Debugw("unable to send loki filter label request to Giam", "err", err)
+
+	decision, ok := d.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Loki),
+		failure.EndpointLabelValues,
+		payload,
+		err,
+		&loki.FilterLabelValuesResp{Data: payload.Label.Values, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*loki.FilterLabelValuesResp), true
+}
+
+// failureEndpoint returns the endpoint the failure mode of a request to the loki HTTP API is configured for.
+func failureEndpoint(endpoint string) string {
+	switch endpoint {
+	case directSeries:
+		return failure.EndpointSeries
+	case directLabels:
+		return failure.EndpointLabelNames
+	case directLabelValues:
+		return failure.EndpointLabelValues
+	default:
+		return failure.EndpointQuery
+	}
+}
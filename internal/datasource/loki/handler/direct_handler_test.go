@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestDirectHandler_Handle(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		method             string
+		path               string
+		params             url.Values
+		contentType        string
+		authorizer         *datasource.MockQueryAuthorizer
+		service            loki.Service
+		respBody           string
+		expectedParams     url.Values
+		expectedData       string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:               "It should rewrite the query of an instant query",
+			method:             http.MethodGet,
+			path:               "/loki/api/v1/query",
+			params:             url.Values{"query": {`count_over_time({app="api"}[5m])`}, "limit": {"100"}},
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`count_over_time({app="api", team="payment"}[5m])`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": {"resultType": "vector", "result": []}}`,
+			expectedParams:     url.Values{"query": {`count_over_time({app="api", team="payment"}[5m])`}, "limit": {"100"}},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should rewrite the query of a range query sent as a form",
+			method:             http.MethodPost,
+			path:               "/loki/api/v1/query_range",
+			params:             url.Values{"query": {`{app="api"} |= "error"`}, "direction": {"backward"}},
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`{app="api", team="payment"} |= "error"`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": {"resultType": "streams", "result": []}}`,
+			expectedParams:     url.Values{"query": {`{app="api", team="payment"} |= "error"`}, "direction": {"backward"}},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "It should rewrite every match[] of a series request and filter the series",
+			method:     http.MethodGet,
+			path:       "/loki/api/v1/series",
+			params:     url.Values{"match[]": {`{app="api"}`, `{app="web"}`}},
+			authorizer: datasource.NewRewritingQueryAuthorizer(`{app="api", team="payment"}`, `{app="web", team="payment"}`),
+			service: &service.Mock{FilterSeriesResp: &loki.FilterSeriesResp{
+				Data: []map[string]string{{"app": "api", "team": "payment"}},
+			}},
+			respBody: `{"status": "success", "data": [
+				{"app": "api", "team": "payment"},
+				{"app": "api", "team": "menu"}
+			]}`,
+			expectedParams:     url.Values{"match[]": {`{app="api", team="payment"}`, `{app="web", team="payment"}`}},
+			expectedData:       `[{"app": "api", "team": "payment"}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should rewrite the query of a label names request and forward the label names",
+			method:             http.MethodGet,
+			path:               "/loki/api/v1/labels",
+			params:             url.Values{"query": {`{app="api"}`}},
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`{app="api", team="payment"}`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": ["app", "team"]}`,
+			expectedParams:     url.Values{"query": {`{app="api", team="payment"}`}},
+			expectedData:       `["app", "team"]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "It should filter the label values",
+			method:     http.MethodGet,
+			path:       "/loki/api/v1/label/team/values",
+			params:     url.Values{},
+			authorizer: &datasource.MockQueryAuthorizer{},
+			service: &service.Mock{FilterLabelValuesResp: &loki.FilterLabelValuesResp{
+				Data: []string{"payment"},
+			}},
+			respBody:           `{"status": "success", "data": ["menu", "payment"]}`,
+			expectedParams:     url.Values{},
+			expectedData:       `["payment"]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should reject a range query without expression",
+			method:             http.MethodGet,
+			path:               "/loki/api/v1/query_range",
+			params:             url.Values{"limit": {"100"}},
+			authorizer:         &datasource.MockQueryAuthorizer{},
+			service:            &service.Mock{},
+			expectedBody:       "Missing query parameter",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "It should reject a body which can't be read",
+			method:             http.MethodPost,
+			path:               "/loki/api/v1/query",
+			params:             url.Values{"query": {`{app="api"}`}},
+			contentType:        "application/json",
+			authorizer:         &datasource.MockQueryAuthorizer{},
+			service:            &service.Mock{},
+			expectedBody:       "Unable to read request body",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:   "It should reject a denied query",
+			method: http.MethodGet,
+			path:   "/loki/api/v1/query",
+			params: url.Values{"query": {`{app="api"}`}},
+			authorizer: &datasource.MockQueryAuthorizer{AuthorizedQueries: &datasource.AuthorizedQueries{
+				Message:    "no policy allows access to the datasource",
+				StatusCode: http.StatusForbidden,
+			}},
+			service:            &service.Mock{},
+			expectedBody:       "no policy allows access to the datasource",
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.method == http.MethodPost {
+				contentType := "application/x-www-form-urlencoded"
+				if tt.contentType != "" {
+					contentType = tt.contentType
+				}
+
+				req = httptest.NewRequest(http.MethodPost, "/logs"+tt.path, strings.NewReader(tt.params.Encode()))
+				req.Header.Set("Content-Type", contentType)
+			} else {
+				req = httptest.NewRequest(http.MethodGet, "/logs"+tt.path+"?"+tt.params.Encode(), nil)
+			}
+
+			req.RemoteAddr = "192.0.2.10:41234"
+			req.Header.Set("X-Webauth-User", "jdoe")
+			req.Header.Set("X-Webauth-Groups", "payment")
+
+			rr := httptest.NewRecorder()
+
+			handler := NewDirectHandler(&DirectHandlerDeps{
+				Identities: identity.NewAuthProxy(&identity.AuthProxyDeps{
+					UserHeader:   "X-Webauth-User",
+					GroupsHeader: "X-Webauth-Groups",
+					Trusted:      []*net.IPNet{trusted},
+					GroupTeams:   map[string]int{"payment": 2},
+					Logger:       log.New("FATAL"),
+				}),
+				Authorizer:    tt.authorizer,
+				Service:       tt.service,
+				DatasourceUID: "P0dfd3df3dfd",
+				PathPrefix:    "/logs",
+				Logger:        log.New("FATAL"),
+			})
+
+			assert.True(t, handler.Match(req))
+
+			next := &mocks.NextHandler{RespBody: []byte(tt.respBody)}
+
+			handler.Handle(rr, req, next)
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			if tt.expectedStatusCode != http.StatusOK {
+				assert.False(t, next.Called)
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(rr.Body.String()))
+
+				return
+			}
+
+			for _, query := range tt.authorizer.Received {
+				assert.Equal(t, "P0dfd3df3dfd", datasource.QueryDatasourceUID(query))
+			}
+
+			forwarded := req.URL.Query()
+			if tt.method == http.MethodPost {
+				forwarded, err = url.ParseQuery(string(next.ReceivedBody))
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectedParams.Encode(), forwarded.Encode())
+
+			if tt.expectedData != "" {
+				var actualResponse struct {
+					Data json.RawMessage `json:"data"`
+				}
+
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&actualResponse))
+				assert.JsonEq(t, tt.expectedData, string(actualResponse.Data))
+			}
+		})
+	}
+}
+
+func TestDirectHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "it should return true for the loki HTTP API under the prefix",
+			url:  "/logs/loki/api/v1/query_range?query=%7Bapp%3D%22api%22%7D",
+			want: true,
+		},
+		{
+			name: "it should return true for the label values",
+			url:  "/logs/loki/api/v1/label/team/values",
+			want: true,
+		},
+		{
+			name: "it should return false for the loki HTTP API without the prefix",
+			url:  "/loki/api/v1/query?query=%7Bapp%3D%22api%22%7D",
+			want: false,
+		},
+		{
+			name: "it should return false for the endpoints which aren't enforced",
+			url:  "/logs/loki/api/v1/tail",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewDirectHandler(&DirectHandlerDeps{DatasourceUID: "P0dfd3df3dfd", PathPrefix: "/logs"})
+			if got := h.Match(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,14 +2,19 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"regexp"
 	"strconv"
 
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
 	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
 	"github.com/usegiam/giam-traefik-plugin/internal/types"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
@@ -20,19 +25,33 @@ const labelValuesEndpointPattern = `^/api/datasources/uid/([a-zA-Z0-9-]+)/resour
 var labelValuesEndpointRegexExp = regexp.MustCompile(labelValuesEndpointPattern)
 
 type LabelValuesHandler struct {
-	service     loki.Service
-	grafanaRepo grafana.Repo
-	logger      *log.Logger
+	service       loki.Service
+	identities    identity.Resolver
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
+	logger        *log.Logger
 }
 
 type LabelValuesHandlerDeps struct {
-	Service     loki.Service
-	GrafanaRepo grafana.Repo
-	Logger      *log.Logger
+	Service       loki.Service
+	Identities    identity.Resolver
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the upstream response once filtered, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
 }
 
 func NewLabelValueHandler(deps *LabelValuesHandlerDeps) handler.Handler {
-	return &LabelValuesHandler{service: deps.Service, grafanaRepo: deps.GrafanaRepo, logger: deps.Logger}
+	return &LabelValuesHandler{
+		service:       deps.Service,
+		identities:    deps.Identities,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
+		logger:        deps.Logger,
+	}
 }
 
 func (l *LabelValuesHandler) Match(req *http.Request) bool {
@@ -48,7 +67,11 @@ func (l *LabelValuesHandler) Match(req *http.Request) bool {
 }
 
 func (l *LabelValuesHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
-	l.logger.Debug("instantiated a loki label values filter")
+	l.logger.Ctx(req.Context()).Debugw("instantiated a loki label values filter")
+
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(failure.EndpointLabelValues)
+	event.SetShadow(l.shadow)
 
 	matches := labelValuesEndpointRegexExp.FindStringSubmatch(req.RequestURI)
 
@@ -58,16 +81,18 @@ func (l *LabelValuesHandler) Handle(rw http.ResponseWriter, req *http.Request, n
 		Status:         http.StatusOK,
 	}
 
-	grafanaSession, err := req.Cookie("grafana_session")
+	// Requests without credentials are rejected before reaching the upstream.
+	_, err := l.identities.Credentials(req)
 	if err != nil {
-		http.Error(rw, "Forbidden", http.StatusForbidden)
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
 
 		return
 	}
 
 	next.ServeHTTP(w, req)
 
-	l.logger.Debugf("grafana response %s", string(w.Body.Bytes()))
+	l.logger.Ctx(req.Context()).Debugw("grafana response", "body", string(w.Body.Bytes()))
 
 	var grafanaResp grafana.LabelValuesReq
 
@@ -78,41 +103,68 @@ func (l *LabelValuesHandler) Handle(rw http.ResponseWriter, req *http.Request, n
 		return
 	}
 
-	user, err := l.grafanaRepo.GetUser(grafanaSession.Value)
+	id, err := l.identities.Resolve(req)
 	if err != nil {
-		l.logger.Debugf("user doesn't exists, err: %v", err)
+		l.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
 
-		http.Error(rw, "User doesn't exits", http.StatusBadRequest)
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
 
 		return
 	}
 
-	teams, err := l.grafanaRepo.GetUserTeams(grafanaSession.Value, user.ID)
-	if err != nil {
-		l.logger.Debugf("user doesn't have any team, err: %v", err)
+	event.SetIdentity(id)
 
-		http.Error(rw, "User not assigned to any team", http.StatusBadRequest)
+	// Bypassing requests get the upstream response as is.
+	if l.bypass.Allows(req, id) {
+		rw.WriteHeader(w.Status)
+		rw.Write(w.Body.Bytes())
 
 		return
 	}
 
-	resp, err := l.service.FilterLabelValues(&loki.FilterLabelValuesReq{
-		User:  user,
-		Teams: teams,
+	resp, ok := l.filterLabelValues(req.Context(), &loki.FilterLabelValuesReq{
+		User:  id.User,
+		Teams: id.Teams,
+		OrgID: id.OrgID,
 		Label: &loki.Label{
 			Name:   matches[2], // Label name exists in second index of the regx
 			Values: grafanaResp.Data,
 		},
 		Datasource: grafana.Datasource{UID: matches[1]}, // Uid exists in first index of the regx
 	})
-	if err != nil {
-		l.logger.Debugf("unable to send loki filter label request to Giam, err: %v", err)
+	if !ok {
+		event.SetDecision(audit.DecisionError)
+
+		if l.shadow {
+			rw.WriteHeader(w.Status)
+			rw.Write(w.Body.Bytes())
+
+			return
+		}
 
 		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
 
 		return
 	}
 
+	event.SetDecision(audit.DecisionAllow)
+	event.AddQuery(&audit.Query{DatasourceUID: matches[1], DatasourceType: string(datasource.Loki)})
+	filtered := len(grafanaResp.Data) - len(resp.Data)
+	event.SetFiltered(filtered)
+
+	if l.shadow {
+		if filtered > 0 {
+			l.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the unfiltered label values",
+				"datasource_uid", matches[1], "filtered", filtered)
+		}
+
+		rw.WriteHeader(w.Status)
+		rw.Write(w.Body.Bytes())
+
+		return
+	}
+
 	grafanaResp.Data = resp.Data
 
 	responseBody, err := json.Marshal(grafanaResp)
@@ -126,3 +178,34 @@ func (l *LabelValuesHandler) Handle(rw http.ResponseWriter, req *http.Request, n
 	rw.WriteHeader(w.Status)
 	rw.Write(responseBody)
 }
+
+// filterLabelValues filters the label values with Giam, and applies the failure policy when Giam can't be reached.
+// It returns false when the request must be denied.
+func (l *LabelValuesHandler) filterLabelValues(
+	ctx context.Context,
+	payload *loki.FilterLabelValuesReq,
+) (*loki.FilterLabelValuesResp, bool) {
+	resp, err := l.service.FilterLabelValues(ctx, payload)
+	if err == nil {
+		l.failurePolicy.Remember(string(datasource.Loki), failure.EndpointLabelValues, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	l.logger.Ctx(ctx).Debugw("unable to send loki filter label request to Giam", "err", err)
+
+	decision, ok := l.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Loki),
+		failure.EndpointLabelValues,
+		payload,
+		err,
+		&loki.FilterLabelValuesResp{Data: payload.Label.Values, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*loki.FilterLabelValuesResp), true
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
 	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
@@ -69,9 +70,12 @@ func TestLabelValuesHandler_Handle(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			handler := &LabelValuesHandler{
-				logger:      log.New("FATAL"),
-				service:     tt.service,
-				grafanaRepo: tt.grafanaRepo,
+				logger:  log.New("FATAL"),
+				service: tt.service,
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: tt.grafanaRepo,
+					Logger:      log.New("FATAL"),
+				}),
 			}
 
 			handler.Handle(rr, req, &mocks.NextHandler{
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+)
+
+// QueryAuthorizer rewrites the loki queries of a grafana query request through Giam.
+type QueryAuthorizer struct {
+	service   loki.Service
+	decisions *datasource.DecisionCache
+	logger    *log.Logger
+}
+
+type QueryAuthorizerDeps struct {
+	Service loki.Service
+	HashSvc hash.Service
+	// DecisionTTL is how long the decision taken for identical queries is reused, zero disables it.
+	DecisionTTL time.Duration
+	// Metrics records the hits and misses of the decision cache, nil disables it.
+	Metrics *metrics.Registry
+	Logger  *log.Logger
+}
+
+func NewQueryAuthorizer(deps *QueryAuthorizerDeps) datasource.QueryAuthorizer {
+	return &QueryAuthorizer{
+		service: deps.Service,
+		decisions: datasource.NewDecisionCache(&datasource.DecisionCacheDeps{
+			HashSvc: deps.HashSvc,
+			TTL:     deps.DecisionTTL,
+			Name:    "loki_decisions",
+			Metrics: deps.Metrics,
+		}),
+		logger: deps.Logger,
+	}
+}
+
+func (l *QueryAuthorizer) AuthorizeQueries(
+	ctx context.Context,
+	payload *datasource.AuthorizeQueriesReq,
+) (*datasource.AuthorizedQueries, error) {
+	l.logger.Ctx(ctx).Debugw("instantiated a loki query authorize")
+
+	if resp, ok := l.decisions.Get(payload); ok {
+		l.logger.Ctx(ctx).Debugw("loki queries authorized from the decision cache")
+
+		return resp, nil
+	}
+
+	resp, err := l.service.AuthorizeQuery(ctx, &loki.AuthorizeQueryReq{
+		User:    payload.User,
+		Teams:   payload.Teams,
+		OrgID:   payload.OrgID,
+		Queries: payload.Queries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to send loki authorize query request to Giam: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		denied := &datasource.AuthorizedQueries{Message: resp.Message, StatusCode: resp.StatusCode}
+		l.decisions.Set(payload, denied)
+
+		return denied, nil
+	}
+
+	if len(resp.Queries) != len(payload.Queries) {
+		return nil, fmt.Errorf("giam returned %d queries for %d loki queries", len(resp.Queries), len(payload.Queries))
+	}
+
+	l.logger.Ctx(ctx).Debugw("original loki queries", "queries", payload.Queries)
+	l.logger.Ctx(ctx).Debugw("rewritten loki queries", "queries", resp.Queries)
+
+	authorized := &datasource.AuthorizedQueries{Queries: resp.Queries, StatusCode: http.StatusOK}
+	l.decisions.Set(payload, authorized)
+
+	return authorized, nil
+}
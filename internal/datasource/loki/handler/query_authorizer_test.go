@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki/service"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+func TestQueryAuthorizer_AuthorizeQueries(t *testing.T) {
+	tests := []struct {
+		name         string
+		queries      []interface{}
+		service      loki.Service
+		expectedResp *datasource.AuthorizedQueries
+		expectError  bool
+	}{
+		{
+			name: "Test with multiple queries",
+			queries: []interface{}{
+				map[string]interface{}{
+					"expr": `{cluster="customer1"}`,
+				},
+				map[string]interface{}{
+					"expr": `{cluster="customer2", team="payment"}`,
+				},
+			},
+			service: &service.Mock{
+				AuthorizedQueryResp: &loki.AuthorizedQueryResp{
+					Queries: []interface{}{
+						map[string]interface{}{
+							"expr": `{cluster="customer1", team=~"menu|^$"}`,
+						},
+						map[string]interface{}{
+							"expr": `{cluster="customer2", team="payment"}`,
+						},
+					},
+					Message:    "random message",
+					StatusCode: http.StatusOK,
+				},
+			},
+			expectedResp: &datasource.AuthorizedQueries{
+				Queries: []interface{}{
+					map[string]interface{}{
+						"expr": `{cluster="customer1", team=~"menu|^$"}`,
+					},
+					map[string]interface{}{
+						"expr": `{cluster="customer2", team="payment"}`,
+					},
+				},
+				StatusCode: http.StatusOK,
+			},
+		},
+		{
+			name: "Test with single query when there is no team assigned for the user",
+			queries: []interface{}{
+				map[string]interface{}{
+					"expr": `{cluster="customer3"}`,
+				},
+			},
+			service: &service.Mock{
+				AuthorizedQueryResp: &loki.AuthorizedQueryResp{
+					Message:    "No Team Assigned",
+					StatusCode: http.StatusPreconditionFailed,
+				},
+			},
+			expectedResp: &datasource.AuthorizedQueries{
+				Message:    "No Team Assigned",
+				StatusCode: http.StatusPreconditionFailed,
+			},
+		},
+		{
+			name: "Test when giam doesn't return as many queries as it received",
+			queries: []interface{}{
+				map[string]interface{}{
+					"expr": `{cluster="customer3"}`,
+				},
+			},
+			service: &service.Mock{
+				AuthorizedQueryResp: &loki.AuthorizedQueryResp{
+					StatusCode: http.StatusOK,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Test when giam is unreachable",
+			queries: []interface{}{
+				map[string]interface{}{
+					"expr": `{cluster="customer3"}`,
+				},
+			},
+			service:     &service.Mock{Error: errors.New("connection refused")},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorizer := &QueryAuthorizer{
+				logger:  log.New("FATAL"),
+				service: tt.service,
+			}
+
+			resp, err := authorizer.AuthorizeQueries(context.Background(), &datasource.AuthorizeQueriesReq{
+				User:    &grafana.User{ID: 1, Name: "user1"},
+				Teams:   []*grafana.Team{{ID: 1, Name: "team1"}},
+				Queries: tt.queries,
+			})
+
+			if tt.expectError {
+				assert.Error(t, err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResp.StatusCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedResp.Message, resp.Message)
+			assert.CompareJson(t, tt.expectedResp.Queries, resp.Queries)
+		})
+	}
+}
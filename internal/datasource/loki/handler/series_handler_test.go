@@ -11,6 +11,7 @@ import (
 
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
 	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
@@ -169,9 +170,12 @@ func TestSeriesHandler_Handle(t *testing.T) {
 			}
 
 			handler := &SeriesHandler{
-				logger:      log.New("FATAL"),
-				service:     tt.service,
-				grafanaRepo: tt.grafanaRepo,
+				logger:  log.New("FATAL"),
+				service: tt.service,
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: tt.grafanaRepo,
+					Logger:      log.New("FATAL"),
+				}),
 			}
 
 			handler.Handle(rr, req, &mocks.NextHandler{
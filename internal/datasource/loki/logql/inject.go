@@ -0,0 +1,39 @@
+package logql
+
+import (
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+)
+
+// InjectMatchers appends the given matchers to every stream selector of the query, in log queries as well as in
+// metric queries such as sum by (app) (rate({app="api"} |= "error" [5m])). Matchers are ANDed with the existing
+// ones, so the rewritten selector selects the intersection of the requested streams and the streams the matchers
+// allow. Matchers already present with the very same operator and value are not duplicated.
+func InjectMatchers(query string, matchers []*label.Matcher) (string, error) {
+	selectors, err := ParseSelectors(query)
+	if err != nil {
+		return "", err
+	}
+
+	if len(matchers) == 0 {
+		return query, nil
+	}
+
+	var b strings.Builder
+
+	last := 0
+
+	for _, selector := range selectors {
+		selector.Matchers = label.AppendMissing(selector.Matchers, matchers)
+
+		b.WriteString(query[last:selector.start])
+		b.WriteString(selector.String())
+
+		last = selector.end + 1
+	}
+
+	b.WriteString(query[last:])
+
+	return b.String(), nil
+}
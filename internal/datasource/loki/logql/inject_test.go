@@ -0,0 +1,98 @@
+package logql
+
+import (
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestInjectMatchers(t *testing.T) {
+	team, err := label.NewMatcher(label.MatchEqual, "team", "payment")
+	require.NoError(t, err)
+
+	env, err := label.NewMatcher(label.MatchNotRegexp, "env", "dev|test")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		query    string
+		matchers []*label.Matcher
+		expected string
+	}{
+		{
+			name:     "it should add matchers to a log query",
+			query:    `{app="api"}`,
+			matchers: []*label.Matcher{team, env},
+			expected: `{app="api", team="payment", env!~"dev|test"}`,
+		},
+		{
+			name:     "it should not duplicate a matcher already present",
+			query:    `{team="payment",app=~"api|web"}`,
+			matchers: []*label.Matcher{team},
+			expected: `{team="payment", app=~"api|web"}`,
+		},
+		{
+			name:     "it should keep the pipeline untouched",
+			query:    `{app="api"} |= "{app=\"web\"}" | json | line_format "{{.msg}}" | level!="debug"`,
+			matchers: []*label.Matcher{team},
+			expected: `{app="api", team="payment"} |= "{app=\"web\"}" | json | line_format "{{.msg}}" | level!="debug"`,
+		},
+		{
+			name:     "it should add matchers inside metric queries",
+			query:    `sum by () (rate({app="api"} |~ ` + "`err(or)?`" + ` [$__auto]))`,
+			matchers: []*label.Matcher{team},
+			expected: `sum by () (rate({app="api", team="payment"} |~ ` + "`err(or)?`" + ` [$__auto]))`,
+		},
+		{
+			name:     "it should add matchers to every selector of binary operations",
+			query:    `sum(count_over_time({app="api"}[5m])) / sum(count_over_time({app=~".+"} | logfmt | unwrap bytes [5m]))`,
+			matchers: []*label.Matcher{team},
+			expected: `sum(count_over_time({app="api", team="payment"}[5m])) / sum(count_over_time({app=~".+", team="payment"} | logfmt | unwrap bytes [5m]))`,
+		},
+		{
+			name:     "it should skip comments",
+			query:    "{app=\"api\"} # {app=\"web\"}\n|= \"x\"",
+			matchers: []*label.Matcher{team},
+			expected: "{app=\"api\", team=\"payment\"} # {app=\"web\"}\n|= \"x\"",
+		},
+		{
+			name:     "it should return the query untouched without matchers",
+			query:    `{app = "api"}`,
+			expected: `{app = "api"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := InjectMatchers(tt.query, tt.matchers)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestInjectMatchers_InvalidQuery(t *testing.T) {
+	queries := []string{
+		`{app="api"`,
+		`{}`,
+		`{app=api}`,
+		`{app~"api"}`,
+		`{app=~"("}`,
+		`{app="api"} |= "unterminated`,
+		`{app="api"} }`,
+		`'{app="api"}'`,
+		`{app="api"} // {team="payment"}`,
+	}
+
+	team, err := label.NewMatcher(label.MatchEqual, "team", "payment")
+	require.NoError(t, err)
+
+	for _, query := range queries {
+		_, err := InjectMatchers(query, []*label.Matcher{team})
+
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,226 @@
+package logql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+)
+
+// Selector is a stream selector of a LogQL query, e.g. {app="api", env=~"prod|staging"}.
+type Selector struct {
+	Matchers []*label.Matcher
+
+	// start and end are the offsets of the opening and closing braces in the query.
+	start int
+	end   int
+}
+
+func (s *Selector) String() string {
+	matchers := make([]string, len(s.Matchers))
+	for i, m := range s.Matchers {
+		matchers[i] = m.String()
+	}
+
+	return "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// ParseSelectors returns the stream selectors of a log or metric query, in the order they appear in the query.
+//
+// Outside string literals and comments, LogQL only uses braces for stream selectors: line filters, parsers,
+// label_format and line_format templates all take their arguments as strings. The query is therefore scanned the way
+// Loki lexes it, without having to understand every pipeline stage, so a selector can never be hidden from the plugin.
+func ParseSelectors(query string) ([]*Selector, error) {
+	var selectors []*Selector
+
+	s := &scanner{input: query}
+
+	for s.pos < len(s.input) {
+		c := s.input[s.pos]
+
+		switch {
+		case c == '"' || c == '`':
+			if _, err := s.scanString(); err != nil {
+				return nil, err
+			}
+		case c == '#':
+			s.skipComment()
+		case c == '\'':
+			return nil, fmt.Errorf("unexpected character ' at position %d", s.pos)
+		case strings.HasPrefix(s.input[s.pos:], "//") || strings.HasPrefix(s.input[s.pos:], "/*"):
+			return nil, fmt.Errorf("unexpected %s at position %d, comments start with #", s.input[s.pos:s.pos+2], s.pos)
+		case c == '{':
+			selector, err := s.scanSelector()
+			if err != nil {
+				return nil, err
+			}
+
+			selectors = append(selectors, selector)
+		case c == '}':
+			return nil, fmt.Errorf("unexpected } at position %d", s.pos)
+		default:
+			s.pos++
+		}
+	}
+
+	return selectors, nil
+}
+
+type scanner struct {
+	input string
+	pos   int
+}
+
+func (s *scanner) scanSelector() (*Selector, error) {
+	selector := &Selector{start: s.pos}
+	s.pos++
+
+	for {
+		s.skipSpacesAndComments()
+
+		if s.pos < len(s.input) && s.input[s.pos] == '}' {
+			if len(selector.Matchers) == 0 {
+				return nil, fmt.Errorf("empty stream selector at position %d", selector.start)
+			}
+
+			selector.end = s.pos
+			s.pos++
+
+			return selector, nil
+		}
+
+		matcher, err := s.scanMatcher()
+		if err != nil {
+			return nil, err
+		}
+
+		selector.Matchers = append(selector.Matchers, matcher)
+
+		s.skipSpacesAndComments()
+
+		if s.pos >= len(s.input) {
+			return nil, fmt.Errorf("unterminated stream selector at position %d", selector.start)
+		}
+
+		switch s.input[s.pos] {
+		case ',':
+			s.pos++
+		case '}':
+		default:
+			return nil, fmt.Errorf("unexpected %s in stream selector at position %d", s.current(), s.pos)
+		}
+	}
+}
+
+// scanMatcher scans a label matcher such as app=~"api|web".
+func (s *scanner) scanMatcher() (*label.Matcher, error) {
+	start := s.pos
+
+	for s.pos < len(s.input) && isLabelChar(s.input[s.pos], s.pos == start) {
+		s.pos++
+	}
+
+	if s.pos == start {
+		return nil, fmt.Errorf("expected label name in stream selector at position %d, got %s", s.pos, s.current())
+	}
+
+	name := s.input[start:s.pos]
+
+	s.skipSpacesAndComments()
+
+	var matchType label.MatchType
+
+	for _, t := range []label.MatchType{label.MatchRegexp, label.MatchNotRegexp, label.MatchNotEqual, label.MatchEqual} {
+		if strings.HasPrefix(s.input[s.pos:], string(t)) {
+			matchType = t
+			s.pos += len(t)
+
+			break
+		}
+	}
+
+	if matchType == "" {
+		return nil, fmt.Errorf("expected label matching operator after %s at position %d", name, s.pos)
+	}
+
+	s.skipSpacesAndComments()
+
+	if s.pos >= len(s.input) || (s.input[s.pos] != '"' && s.input[s.pos] != '`') {
+		return nil, fmt.Errorf("expected string after %s%s at position %d", name, matchType, s.pos)
+	}
+
+	quoted, err := s.scanString()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := label.Unquote(quoted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid string %s: %w", quoted, err)
+	}
+
+	return label.NewMatcher(matchType, name, value)
+}
+
+// scanString scans a string quoted with double quotes or backticks and returns it as written in the query.
+func (s *scanner) scanString() (string, error) {
+	start := s.pos
+	quote := s.input[s.pos]
+	s.pos++
+
+	for s.pos < len(s.input) {
+		c := s.input[s.pos]
+
+		switch {
+		case c == '\\' && quote != '`':
+			s.pos += 2
+		case c == quote:
+			s.pos++
+
+			return s.input[start:s.pos], nil
+		case c == '\n' && quote != '`':
+			return "", fmt.Errorf("unterminated string at position %d", start)
+		default:
+			s.pos++
+		}
+	}
+
+	return "", fmt.Errorf("unterminated string at position %d", start)
+}
+
+func (s *scanner) skipComment() {
+	for s.pos < len(s.input) && s.input[s.pos] != '\n' {
+		s.pos++
+	}
+}
+
+func (s *scanner) skipSpacesAndComments() {
+	for s.pos < len(s.input) {
+		r, size := utf8.DecodeRuneInString(s.input[s.pos:])
+
+		switch {
+		case unicode.IsSpace(r):
+			s.pos += size
+		case r == '#':
+			s.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (s *scanner) current() string {
+	if s.pos >= len(s.input) {
+		return "end of input"
+	}
+
+	r, _ := utf8.DecodeRuneInString(s.input[s.pos:])
+
+	return fmt.Sprintf("%q", r)
+}
+
+func isLabelChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
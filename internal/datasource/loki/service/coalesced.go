@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/pkg/singleflight"
+)
+
+// coalescedService shares a single call to Giam between the concurrent identical calls, such as the ones of the
+// panels of a dashboard being loaded.
+type coalescedService struct {
+	service loki.Service
+	calls   singleflight.Group
+}
+
+func NewCoalesced(service loki.Service) loki.Service {
+	return &coalescedService{service: service}
+}
+
+func (s *coalescedService) AuthorizeQuery(
+	ctx context.Context,
+	payload *loki.AuthorizeQueryReq,
+) (*loki.AuthorizedQueryResp, error) {
+	resp, err := s.do(ctx, "authorize_query", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.AuthorizeQuery(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*loki.AuthorizedQueryResp), nil
+}
+
+func (s *coalescedService) FilterSeries(
+	ctx context.Context,
+	payload *loki.FilterSeriesReq,
+) (*loki.FilterSeriesResp, error) {
+	resp, err := s.do(ctx, "filter_series", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.FilterSeries(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*loki.FilterSeriesResp), nil
+}
+
+func (s *coalescedService) FilterLabelValues(
+	ctx context.Context,
+	payload *loki.FilterLabelValuesReq,
+) (*loki.FilterLabelValuesResp, error) {
+	resp, err := s.do(ctx, "filter_label_values", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.FilterLabelValues(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*loki.FilterLabelValuesResp), nil
+}
+
+func (s *coalescedService) GetPolicy(ctx context.Context, payload *loki.GetPolicyReq) (*loki.GetPolicyResp, error) {
+	resp, err := s.do(ctx, "get_policy", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.GetPolicy(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*loki.GetPolicyResp), nil
+}
+
+// do coalesces the calls of the method whose payloads are identical.
+func (s *coalescedService) do(
+	ctx context.Context,
+	method string,
+	payload interface{},
+	fn func(ctx context.Context) (interface{}, error),
+) (interface{}, error) {
+	key, err := json.Marshal(payload)
+	if err != nil {
+		return fn(ctx)
+	}
+
+	return s.calls.DoContext(ctx, method+":"+string(key), fn)
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func newLocal(service *Mock) loki.Service {
+	return NewLocal(&LocalDeps{
+		Service:   service,
+		PolicyTTL: time.Minute,
+		Logger:    log.New("FATAL"),
+	})
+}
+
+func TestLocalService_AuthorizeQuery(t *testing.T) {
+	team, err := label.NewMatcher(label.MatchEqual, "team", "payment")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		policy       *loki.GetPolicyResp
+		queries      []interface{}
+		expectedResp *loki.AuthorizedQueryResp
+	}{
+		{
+			name:   "it should inject the policy matchers into every query",
+			policy: &loki.GetPolicyResp{Matchers: []*label.Matcher{team}, StatusCode: http.StatusOK},
+			queries: []interface{}{
+				map[string]interface{}{
+					"refId": "A",
+					"expr":  `{app="api"} |= "error"`,
+					"datasource": map[string]interface{}{
+						"uid": "dummyUID1",
+					},
+				},
+				map[string]interface{}{
+					"refId": "B",
+					"expr":  `sum by () (rate({app="api"}[5m]))`,
+					"datasource": map[string]interface{}{
+						"uid": "dummyUID1",
+					},
+				},
+			},
+			expectedResp: &loki.AuthorizedQueryResp{
+				Queries: []interface{}{
+					map[string]interface{}{
+						"refId": "A",
+						"expr":  `{app="api", team="payment"} |= "error"`,
+						"datasource": map[string]interface{}{
+							"uid": "dummyUID1",
+						},
+					},
+					map[string]interface{}{
+						"refId": "B",
+						"expr":  `sum by () (rate({app="api", team="payment"}[5m]))`,
+						"datasource": map[string]interface{}{
+							"uid": "dummyUID1",
+						},
+					},
+				},
+				StatusCode: http.StatusOK,
+			},
+		},
+		{
+			name:   "it should return the policy rejection",
+			policy: &loki.GetPolicyResp{Message: "No Team Assigned", StatusCode: http.StatusPreconditionFailed},
+			queries: []interface{}{
+				map[string]interface{}{"expr": `{app="api"}`},
+			},
+			expectedResp: &loki.AuthorizedQueryResp{
+				Message:    "No Team Assigned",
+				StatusCode: http.StatusPreconditionFailed,
+			},
+		},
+		{
+			name:   "it should reject queries it cannot parse",
+			policy: &loki.GetPolicyResp{Matchers: []*label.Matcher{team}, StatusCode: http.StatusOK},
+			queries: []interface{}{
+				map[string]interface{}{"expr": `{app="api"`},
+			},
+			expectedResp: &loki.AuthorizedQueryResp{
+				Message:    `invalid LogQL query: unterminated stream selector at position 0`,
+				StatusCode: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newLocal(&Mock{GetPolicyResp: tt.policy}).AuthorizeQuery(context.Background(), &loki.AuthorizeQueryReq{
+				User:    &grafana.User{ID: 1, Name: "user1"},
+				Teams:   []*grafana.Team{{ID: 1, Name: "team1"}},
+				Queries: tt.queries,
+			})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedResp.StatusCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedResp.Message, resp.Message)
+			assert.CompareJson(t, tt.expectedResp.Queries, resp.Queries)
+		})
+	}
+}
+
+func TestLocalService_FilterSeries(t *testing.T) {
+	team, err := label.NewMatcher(label.MatchRegexp, "team", "payment|menu")
+	require.NoError(t, err)
+
+	svc := newLocal(&Mock{GetPolicyResp: &loki.GetPolicyResp{Matchers: []*label.Matcher{team}, StatusCode: http.StatusOK}})
+
+	resp, err := svc.FilterSeries(context.Background(), &loki.FilterSeriesReq{
+		User:  &grafana.User{ID: 1, Name: "user1"},
+		Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+		Series: []map[string]string{
+			{"app": "api", "team": "payment"},
+			{"app": "api", "team": "search"},
+			{"app": "api"},
+			{"app": "web", "team": "menu"},
+		},
+		Datasource: grafana.Datasource{UID: "dummyUID1"},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"app": "api", "team": "payment"},
+		{"app": "web", "team": "menu"},
+	}, resp.Data)
+}
+
+func TestLocalService_FilterLabelValues(t *testing.T) {
+	team, err := label.NewMatcher(label.MatchRegexp, "team", "payment|menu")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		label        *loki.Label
+		expectedData []string
+	}{
+		{
+			name:         "it should filter the values of a label constrained by the policy",
+			label:        &loki.Label{Name: "team", Values: []string{"payment", "search", "menu"}},
+			expectedData: []string{"payment", "menu"},
+		},
+		{
+			name:         "it should let Giam filter the values of other labels",
+			label:        &loki.Label{Name: "app", Values: []string{"api", "web"}},
+			expectedData: []string{"api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newLocal(&Mock{
+				GetPolicyResp:         &loki.GetPolicyResp{Matchers: []*label.Matcher{team}, StatusCode: http.StatusOK},
+				FilterLabelValuesResp: &loki.FilterLabelValuesResp{Data: []string{"api"}, StatusCode: http.StatusOK},
+			})
+
+			resp, err := svc.FilterLabelValues(context.Background(), &loki.FilterLabelValuesReq{
+				User:       &grafana.User{ID: 1, Name: "user1"},
+				Teams:      []*grafana.Team{{ID: 1, Name: "team1"}},
+				Label:      tt.label,
+				Datasource: grafana.Datasource{UID: "dummyUID1"},
+			})
+
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, tt.expectedData, resp.Data)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/internal/policy"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// offlineService enforces the last policy snapshot synced from Giam in the plugin when the Giam API is unreachable.
+type offlineService struct {
+	service loki.Service
+	local   loki.Service
+	logger  *log.Logger
+}
+
+type OfflineDeps struct {
+	Service  loki.Service
+	Policies *policy.Store
+	Logger   *log.Logger
+}
+
+func NewOffline(deps *OfflineDeps) loki.Service {
+	return &offlineService{
+		service: deps.Service,
+		local:   &localService{service: &snapshotService{policies: deps.Policies}, logger: deps.Logger},
+		logger:  deps.Logger,
+	}
+}
+
+func (s *offlineService) AuthorizeQuery(
+	ctx context.Context,
+	payload *loki.AuthorizeQueryReq,
+) (*loki.AuthorizedQueryResp, error) {
+	resp, err := s.service.AuthorizeQuery(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam loki authorize query failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.AuthorizeQuery(ctx, payload)
+}
+
+func (s *offlineService) FilterSeries(
+	ctx context.Context,
+	payload *loki.FilterSeriesReq,
+) (*loki.FilterSeriesResp, error) {
+	resp, err := s.service.FilterSeries(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam loki filter series failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.FilterSeries(ctx, payload)
+}
+
+func (s *offlineService) FilterLabelValues(
+	ctx context.Context,
+	payload *loki.FilterLabelValuesReq,
+) (*loki.FilterLabelValuesResp, error) {
+	resp, err := s.service.FilterLabelValues(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam loki filter label values failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.FilterLabelValues(ctx, payload)
+}
+
+func (s *offlineService) GetPolicy(ctx context.Context, payload *loki.GetPolicyReq) (*loki.GetPolicyResp, error) {
+	resp, err := s.service.GetPolicy(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam loki policy failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.GetPolicy(ctx, payload)
+}
+
+// snapshotService serves the policies of the snapshot, everything else needs the Giam API.
+type snapshotService struct {
+	policies *policy.Store
+}
+
+func (s *snapshotService) AuthorizeQuery(
+	ctx context.Context,
+	payload *loki.AuthorizeQueryReq,
+) (*loki.AuthorizedQueryResp, error) {
+	return nil, errors.ErrUnsupportedOffline
+}
+
+func (s *snapshotService) FilterSeries(
+	ctx context.Context,
+	payload *loki.FilterSeriesReq,
+) (*loki.FilterSeriesResp, error) {
+	return nil, errors.ErrUnsupportedOffline
+}
+
+func (s *snapshotService) FilterLabelValues(
+	ctx context.Context,
+	payload *loki.FilterLabelValuesReq,
+) (*loki.FilterLabelValuesResp, error) {
+	return nil, errors.ErrUnsupportedOffline
+}
+
+func (s *snapshotService) GetPolicy(ctx context.Context, payload *loki.GetPolicyReq) (*loki.GetPolicyResp, error) {
+	matchers, err := s.policies.Matchers(payload.Teams, payload.Datasource.UID)
+	if err == errors.ErrNoPolicy {
+		return &loki.GetPolicyResp{
+			Message:    "No policy allows access to this datasource",
+			StatusCode: http.StatusForbidden,
+		}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &loki.GetPolicyResp{Matchers: matchers, StatusCode: http.StatusOK}, nil
+}
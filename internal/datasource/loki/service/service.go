@@ -1,69 +1,50 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
+	"github.com/usegiam/giam-traefik-plugin/pkg/giam"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
 )
 
 type service struct {
-	apiUrl string
-	apiKey string
+	client *giam.Client
 	logger *log.Logger
 }
 
 type Deps struct {
-	APIUrl string
-	APIKey string
+	Client *giam.Client
 	Logger *log.Logger
 }
 
 func New(deps *Deps) loki.Service {
-	return &service{apiUrl: deps.APIUrl, apiKey: deps.APIKey, logger: deps.Logger}
+	return &service{client: deps.Client, logger: deps.Logger}
 }
 
-func (s *service) AuthorizeQuery(payload *loki.AuthorizeQueryReq) (*loki.AuthorizedQueryResp, error) {
-	payloadBytes, err := json.Marshal(payload)
+func (s *service) AuthorizeQuery(
+	ctx context.Context,
+	payload *loki.AuthorizeQueryReq,
+) (*loki.AuthorizedQueryResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/loki/query/authorize",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, s.apiUrl+"/loki/query/authorize", bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", s.apiKey)
-
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	s.logger.Debugf(
-		"giam loki authorized query resp status code: %v, resp body: %s",
-		resp.StatusCode,
-		string(respBody),
-	)
+	s.logger.Ctx(ctx).Debugw("giam loki authorized query resp", "status", resp.StatusCode, "body", string(resp.Body))
 
 	var queryResp loki.AuthorizedQueryResp
 
-	err = json.Unmarshal(respBody, &queryResp)
+	err = json.Unmarshal(resp.Body, &queryResp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode giam loki authorize query resp: %w", err)
 	}
@@ -73,39 +54,22 @@ func (s *service) AuthorizeQuery(payload *loki.AuthorizeQueryReq) (*loki.Authori
 	return &queryResp, nil
 }
 
-func (s *service) FilterSeries(payload *loki.FilterSeriesReq) (*loki.FilterSeriesResp, error) {
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest(http.MethodPost, s.apiUrl+"/loki/series/filter", bytes.NewBuffer(payloadBytes))
+func (s *service) FilterSeries(ctx context.Context, payload *loki.FilterSeriesReq) (*loki.FilterSeriesResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/loki/series/filter",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", s.apiKey)
-
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	s.logger.Debugf("giam loki filter series resp status code: %v, resp body: %s", resp.StatusCode, string(respBody))
+	s.logger.Ctx(ctx).Debugw("giam loki filter series resp", "status", resp.StatusCode, "body", string(resp.Body))
 
 	var filterSeriesResp loki.FilterSeriesResp
 
-	err = json.Unmarshal(respBody, &filterSeriesResp)
+	err = json.Unmarshal(resp.Body, &filterSeriesResp)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode giam loki filter series resp: %w", err)
 	}
@@ -115,48 +79,59 @@ func (s *service) FilterSeries(payload *loki.FilterSeriesReq) (*loki.FilterSerie
 	return &filterSeriesResp, nil
 }
 
-func (s *service) FilterLabelValues(payload *loki.FilterLabelValuesReq) (*loki.FilterLabelValuesResp, error) {
-	payloadBytes, err := json.Marshal(payload)
+func (s *service) FilterLabelValues(
+	ctx context.Context,
+	payload *loki.FilterLabelValuesReq,
+) (*loki.FilterLabelValuesResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/loki/label/filter",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, s.apiUrl+"/loki/label/filter", bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return nil, err
-	}
+	s.logger.Ctx(ctx).Debugw("giam loki filter label values resp", "status", resp.StatusCode, "body", string(resp.Body))
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", s.apiKey)
-
-	client := &http.Client{}
+	var filterLabelValuesResp loki.FilterLabelValuesResp
 
-	resp, err := client.Do(req)
+	err = json.Unmarshal(resp.Body, &filterLabelValuesResp)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode giam loki filter label values resp: %w", err)
 	}
 
-	defer resp.Body.Close()
+	filterLabelValuesResp.StatusCode = resp.StatusCode
+
+	return &filterLabelValuesResp, nil
+}
 
-	respBody, err := io.ReadAll(resp.Body)
+func (s *service) GetPolicy(ctx context.Context, payload *loki.GetPolicyReq) (*loki.GetPolicyResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/loki/policy",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	s.logger.Debugf(
-		"giam loki filter label values resp status code: %v, resp body: %s",
-		resp.StatusCode,
-		string(respBody),
-	)
+	s.logger.Ctx(ctx).Debugw("giam loki policy resp", "status", resp.StatusCode, "body", string(resp.Body))
 
-	var filterLabelValuesResp loki.FilterLabelValuesResp
+	var policyResp loki.GetPolicyResp
 
-	err = json.Unmarshal(respBody, &filterLabelValuesResp)
+	err = json.Unmarshal(resp.Body, &policyResp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode giam loki filter label values resp: %w", err)
+		return nil, fmt.Errorf("failed to decode giam loki policy resp: %w", err)
 	}
 
-	filterLabelValuesResp.StatusCode = resp.StatusCode
+	if err := label.Compile(policyResp.Matchers); err != nil {
+		return nil, fmt.Errorf("invalid giam loki policy: %w", err)
+	}
 
-	return &filterLabelValuesResp, nil
+	policyResp.StatusCode = resp.StatusCode
+
+	return &policyResp, nil
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
 )
 
@@ -9,16 +10,24 @@ type Mock struct {
 	AuthorizedQueryResp   *loki.AuthorizedQueryResp
 	FilterSeriesResp      *loki.FilterSeriesResp
 	FilterLabelValuesResp *loki.FilterLabelValuesResp
+	GetPolicyResp         *loki.GetPolicyResp
 }
 
-func (m *Mock) AuthorizeQuery(payload *loki.AuthorizeQueryReq) (*loki.AuthorizedQueryResp, error) {
+func (m *Mock) AuthorizeQuery(ctx context.Context, payload *loki.AuthorizeQueryReq) (*loki.AuthorizedQueryResp, error) {
 	return m.AuthorizedQueryResp, m.Error
 }
 
-func (m *Mock) FilterSeries(payload *loki.FilterSeriesReq) (*loki.FilterSeriesResp, error) {
+func (m *Mock) FilterSeries(ctx context.Context, payload *loki.FilterSeriesReq) (*loki.FilterSeriesResp, error) {
 	return m.FilterSeriesResp, m.Error
 }
 
-func (m *Mock) FilterLabelValues(payload *loki.FilterLabelValuesReq) (*loki.FilterLabelValuesResp, error) {
+func (m *Mock) FilterLabelValues(
+	ctx context.Context,
+	payload *loki.FilterLabelValuesReq,
+) (*loki.FilterLabelValuesResp, error) {
 	return m.FilterLabelValuesResp, m.Error
 }
+
+func (m *Mock) GetPolicy(ctx context.Context, payload *loki.GetPolicyReq) (*loki.GetPolicyResp, error) {
+	return m.GetPolicyResp, m.Error
+}
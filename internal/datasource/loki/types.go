@@ -1,13 +1,25 @@
 package loki
 
 import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 )
 
+// Modes of the loki service.
+const (
+	// ModeRemote rewrites the queries through the Giam API.
+	ModeRemote = "remote"
+	// ModeLocal fetches the policy from the Giam API and rewrites the queries in the plugin.
+	ModeLocal = "local"
+)
+
 type Service interface {
-	AuthorizeQuery(payload *AuthorizeQueryReq) (*AuthorizedQueryResp, error)
-	FilterLabelValues(payload *FilterLabelValuesReq) (*FilterLabelValuesResp, error)
-	FilterSeries(payload *FilterSeriesReq) (*FilterSeriesResp, error)
+	AuthorizeQuery(ctx context.Context, payload *AuthorizeQueryReq) (*AuthorizedQueryResp, error)
+	FilterLabelValues(ctx context.Context, payload *FilterLabelValuesReq) (*FilterLabelValuesResp, error)
+	FilterSeries(ctx context.Context, payload *FilterSeriesReq) (*FilterSeriesResp, error)
+	GetPolicy(ctx context.Context, payload *GetPolicyReq) (*GetPolicyResp, error)
 }
 
 type AuthorizedQueryResp struct {
@@ -19,12 +31,14 @@ type AuthorizedQueryResp struct {
 type AuthorizeQueryReq struct {
 	User    *grafana.User   `json:"user"`
 	Teams   []*grafana.Team `json:"teams"`
+	OrgID   int             `json:"orgId"`
 	Queries []interface{}   `json:"queries"`
 }
 
 type FilterSeriesReq struct {
 	User       *grafana.User       `json:"user"`
 	Teams      []*grafana.Team     `json:"teams"`
+	OrgID      int                 `json:"orgId"`
 	Series     []map[string]string `json:"series"`
 	Datasource grafana.Datasource  `json:"datasource"`
 }
@@ -42,6 +56,7 @@ type Label struct {
 type FilterLabelValuesReq struct {
 	User       *grafana.User      `json:"user"`
 	Teams      []*grafana.Team    `json:"teams"`
+	OrgID      int                `json:"orgId"`
 	Label      *Label             `json:"label"`
 	Datasource grafana.Datasource `json:"datasource"`
 }
@@ -50,3 +65,17 @@ type FilterLabelValuesResp struct {
 	Data       []string `json:"data"`
 	StatusCode int      `json:"status_code"`
 }
+
+type GetPolicyReq struct {
+	User       *grafana.User      `json:"user"`
+	Teams      []*grafana.Team    `json:"teams"`
+	OrgID      int                `json:"orgId"`
+	Datasource grafana.Datasource `json:"datasource"`
+}
+
+// GetPolicyResp holds the label matchers every stream selector of the user queries must be constrained with.
+type GetPolicyResp struct {
+	Matchers   []*label.Matcher `json:"matchers"`
+	Message    string           `json:"message"`
+	StatusCode int              `json:"status_code"`
+}
@@ -0,0 +1,162 @@
+package datasource
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+)
+
+// formMethods are the methods whose form encoded body is read by http.Request.ParseForm.
+var formMethods = map[string]bool{http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true}
+
+// APIParams holds the parameters of a prometheus or loki HTTP API request, sent in the url or in a form encoded or
+// multipart body, read the same way as the upstream reads them with http.Request.FormValue. The parameters of the body
+// come before the ones of the url.
+type APIParams struct {
+	values url.Values
+	form   url.Values
+	body   []byte
+}
+
+// ReadAPIParams reads the parameters of the request, consuming its body. It fails when the body may carry parameters
+// but can't be read the way the upstream reads it, so no parameter is missed. A multipart body is forwarded form
+// encoded, along with its new Content-Type.
+func ReadAPIParams(req *http.Request) (*APIParams, error) {
+	params := &APIParams{values: req.URL.Query()}
+
+	if req.Body == nil {
+		return params, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	defer req.Body.Close()
+
+	params.body = body
+
+	if len(body) == 0 {
+		return params, nil
+	}
+
+	// The media type is matched case-insensitively, as the upstream does.
+	mediaType, mediaParams, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	switch {
+	case err == nil && mediaType == "multipart/form-data":
+		// The multipart body is read whatever the method, as http.Request.ParseMultipartForm does.
+		form, err := readMultipartForm(body, mediaParams["boundary"])
+		if err != nil {
+			return nil, err
+		}
+
+		params.form = form
+		params.body = []byte(form.Encode())
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	case !formMethods[req.Method]:
+		// The upstream ignores any other body of the other methods.
+	case err != nil:
+		return nil, fmt.Errorf("invalid content type: %w", err)
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+
+		params.form = form
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	return params, nil
+}
+
+// readMultipartForm returns the values of a multipart body, its files are ignored as the upstream ignores them.
+func readMultipartForm(body []byte, boundary string) (url.Values, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("missing multipart boundary")
+	}
+
+	form := url.Values{}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := part.FormName()
+		if name == "" || part.FileName() != "" {
+			continue
+		}
+
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		form.Add(name, string(value))
+	}
+}
+
+// Get returns the first value of the parameter, or an empty string.
+func (p *APIParams) Get(key string) string {
+	values := p.All(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// All returns every value of the parameter, the ones of the body first, e.g. the selectors of match[].
+func (p *APIParams) All(key string) []string {
+	values := make([]string, 0, len(p.form[key])+len(p.values[key]))
+	values = append(values, p.form[key]...)
+
+	return append(values, p.values[key]...)
+}
+
+// Set replaces the values of the parameter where they were sent, in the same order as All, the values left over are
+// set in the url.
+func (p *APIParams) Set(key string, values ...string) {
+	if n := len(p.form[key]); n > 0 {
+		if n > len(values) {
+			n = len(values)
+		}
+
+		p.form[key] = values[:n]
+		p.body = []byte(p.form.Encode())
+		values = values[n:]
+	}
+
+	if len(values) == 0 {
+		delete(p.values, key)
+
+		return
+	}
+
+	p.values[key] = values
+}
+
+// Forward sends the request to next with the parameters.
+func (p *APIParams) Forward(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	req.URL.RawQuery = p.values.Encode()
+	req.RequestURI = req.URL.RequestURI()
+	req.Body = io.NopCloser(bytes.NewBuffer(p.body))
+	req.ContentLength = int64(len(p.body))
+
+	next.ServeHTTP(rw, req)
+}
@@ -0,0 +1,181 @@
+package datasource
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func multipartBody(t *testing.T, fields map[string]string) (string, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for name, value := range fields {
+		require.NoError(t, writer.WriteField(name, value))
+	}
+
+	require.NoError(t, writer.Close())
+
+	return body.String(), writer.FormDataContentType()
+}
+
+func TestReadAPIParams(t *testing.T) {
+	formData, formDataType := multipartBody(t, map[string]string{"query": "up"})
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		wantErr     bool
+		wantQuery   string
+	}{
+		{
+			name:      "It should read the parameters of the url",
+			method:    http.MethodGet,
+			target:    "/api/v1/query?query=up",
+			wantQuery: "up",
+		},
+		{
+			name:        "It should read the form encoded body",
+			method:      http.MethodPost,
+			target:      "/api/v1/query",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "query=up",
+			wantQuery:   "up",
+		},
+		{
+			name:        "It should read the form encoded body whatever the case of its content type",
+			method:      http.MethodPost,
+			target:      "/api/v1/query",
+			contentType: "Application/X-WWW-Form-Urlencoded; charset=UTF-8",
+			body:        "query=up",
+			wantQuery:   "up",
+		},
+		{
+			name:        "It should read the multipart body",
+			method:      http.MethodPost,
+			target:      "/api/v1/query",
+			contentType: formDataType,
+			body:        formData,
+			wantQuery:   "up",
+		},
+		{
+			name:        "It should read the multipart body of a GET request",
+			method:      http.MethodGet,
+			target:      "/api/v1/query",
+			contentType: formDataType,
+			body:        formData,
+			wantQuery:   "up",
+		},
+		{
+			name:        "It should ignore the form encoded body of a GET request",
+			method:      http.MethodGet,
+			target:      "/api/v1/query",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "query=up",
+			wantQuery:   "",
+		},
+		{
+			name:        "It should fail when the content type of the body is unsupported",
+			method:      http.MethodPost,
+			target:      "/api/v1/query",
+			contentType: "application/json",
+			body:        `{"query":"up"}`,
+			wantErr:     true,
+		},
+		{
+			name:    "It should fail when the body has no content type",
+			method:  http.MethodPost,
+			target:  "/api/v1/query",
+			body:    "query=up",
+			wantErr: true,
+		},
+		{
+			name:        "It should fail when the multipart body has no boundary",
+			method:      http.MethodPost,
+			target:      "/api/v1/query",
+			contentType: "multipart/form-data",
+			body:        formData,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			params, err := ReadAPIParams(req)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantQuery, params.Get("query"))
+		})
+	}
+}
+
+func TestAPIParams_Set(t *testing.T) {
+	body := "match[]=up&start=1"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/series?match[]=down", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	params, err := ReadAPIParams(req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"up", "down"}, params.All("match[]"))
+
+	params.Set("match[]", "up_rewritten", "down_rewritten")
+
+	next := &forwardedRequest{}
+	params.Forward(httptest.NewRecorder(), req, next)
+
+	assert.Equal(t, "match%5B%5D=down_rewritten", next.query)
+	assert.Equal(t, "match%5B%5D=up_rewritten&start=1", next.body)
+}
+
+func TestAPIParams_Forward_Multipart(t *testing.T) {
+	body, contentType := multipartBody(t, map[string]string{"query": "up"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/query", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+
+	params, err := ReadAPIParams(req)
+	require.NoError(t, err)
+
+	params.Set("query", "up_rewritten")
+
+	next := &forwardedRequest{}
+	params.Forward(httptest.NewRecorder(), req, next)
+
+	assert.Equal(t, "application/x-www-form-urlencoded", next.contentType)
+	assert.Equal(t, "query=up_rewritten", next.body)
+}
+
+type forwardedRequest struct {
+	query       string
+	body        string
+	contentType string
+}
+
+func (f *forwardedRequest) ServeHTTP(_ http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	f.query = req.URL.RawQuery
+	f.body = string(body)
+	f.contentType = req.Header.Get("Content-Type")
+}
@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// Endpoints of the prometheus HTTP API enforced by the DirectHandler.
+const (
+	directQuery       = "query"
+	directQueryRange  = "query_range"
+	directSeries      = "series"
+	directLabels      = "labels"
+	directLabelValues = "label_values"
+	directExemplars   = "query_exemplars"
+)
+
+// anySeries is the selector sent to the label values endpoint when none is, so the rewritten one narrows down the
+// values to the visible series.
+const anySeries = `{__name__=~".+"}`
+
+// DirectHandler enforces the requests sent to the prometheus HTTP API directly, without grafana in front, e.g. by
+// notebooks or scripts. The expressions of the query and match[] parameters are rewritten the same way as the grafana
+// queries, and the series, label names, label values and exemplars answered are filtered the same way as the grafana
+// ones. Every request is enforced with the policies of the configured datasource.
+type DirectHandler struct {
+	api           *datasource.DirectAPI
+	service       prometheus.Service
+	datasourceUID string
+	pattern       *regexp.Regexp
+	failurePolicy *failure.Policy
+	logger        *log.Logger
+}
+
+type DirectHandlerDeps struct {
+	Identities identity.Resolver
+	// Authorizer rewrites the expressions, it is the authorizer of the prometheus grafana queries.
+	Authorizer datasource.QueryAuthorizer
+	// Service filters the series, the label names and the label values answered.
+	Service prometheus.Service
+	// DatasourceUID is the uid of the grafana datasource whose policies are enforced.
+	DatasourceUID string
+	// PathPrefix is the path the prometheus HTTP API is served under, e.g. "/prometheus", none when empty.
+	PathPrefix    string
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the original requests and the unfiltered responses, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
+}
+
+func NewDirectHandler(deps *DirectHandlerDeps) handler.Handler {
+	endpoints := `(query|query_range|series|labels|query_exemplars|label/([^/]+)/values)`
+	pattern := `^` + regexp.QuoteMeta(deps.PathPrefix) + `/api/v1/` + endpoints + `$`
+
+	return &DirectHandler{
+		api: datasource.NewDirectAPI(&datasource.DirectAPIDeps{
+			Identities:    deps.Identities,
+			Authorizer:    deps.Authorizer,
+			Datasource:    datasource.Prometheus,
+			DatasourceUID: deps.DatasourceUID,
+			FailurePolicy: deps.FailurePolicy,
+			Bypass:        deps.Bypass,
+			Shadow:        deps.Shadow,
+			Logger:        deps.Logger,
+		}),
+		service:       deps.Service,
+		datasourceUID: deps.DatasourceUID,
+		pattern:       regexp.MustCompile(pattern),
+		failurePolicy: deps.FailurePolicy,
+		logger:        deps.Logger,
+	}
+}
+
+func (d *DirectHandler) Match(req *http.Request) bool {
+	return d.pattern.MatchString(req.URL.Path)
+}
+
+func (d *DirectHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	d.logger.Ctx(req.Context()).Debugw("instantiated a prometheus direct api authorize", "path", req.URL.Path)
+
+	matches := d.pattern.FindStringSubmatch(req.URL.Path)
+
+	endpoint, name := matches[1], matches[2]
+	if name != "" {
+		endpoint = directLabelValues
+	}
+
+	params, id, ok := d.api.Authenticate(rw, req, next, failureEndpoint(endpoint))
+	if !ok {
+		return
+	}
+
+	switch endpoint {
+	case directQuery, directQueryRange:
+		if d.api.RewriteQuery(rw, req, next, params, id, failure.EndpointQuery) {
+			d.api.Forward(rw, req, next, params)
+		}
+	case directSeries:
+		if d.api.Rewrite(rw, req, next, params, "match[]", id, failure.EndpointSeries) {
+			d.handleSeries(rw, req, next, params, id)
+		}
+	case directLabels:
+		if d.api.Rewrite(rw, req, next, params, "match[]", id, failure.EndpointLabelNames) {
+			d.handleLabels(rw, req, next, params, id)
+		}
+	case directLabelValues:
+		// The values of every series are answered without selector, one is sent to be rewritten.
+		if len(params.All("match[]")) == 0 {
+			params.Set("match[]", anySeries)
+		}
+
+		if !d.api.Rewrite(rw, req, next, params, "match[]", id, failure.EndpointLabelValues) {
+			return
+		}
+
+		// The metric names are only narrowed down by the rewritten selectors, as in grafana.
+		if name == metricNameLabel {
+			d.api.Forward(rw, req, next, params)
+
+			return
+		}
+
+		d.handleLabelValues(rw, req, next, params, id, name)
+	case directExemplars:
+		if d.api.RewriteQuery(rw, req, next, params, id, failure.EndpointExemplars) {
+			d.handleExemplars(rw, req, next, params, id)
+		}
+	}
+}
+
+// handleSeries forwards the series request, and filters the answered series.
+func (d *DirectHandler) handleSeries(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *datasource.APIParams,
+	id *identity.Identity,
+) {
+	var seriesResp grafana.SeriesReq
+
+	d.api.Filter(rw, req, next, params, &seriesResp, func() (int, bool) {
+		resp, ok := d.filterSeries(req.Context(), failure.EndpointSeries, &prometheus.FilterSeriesReq{
+			User:       id.User,
+			Teams:      id.Teams,
+			OrgID:      id.OrgID,
+			Series:     seriesResp.Series,
+			Datasource: grafana.Datasource{UID: d.datasourceUID},
+		})
+		if !ok {
+			return 0, false
+		}
+
+		filtered := len(seriesResp.Series) - len(resp.Data)
+		seriesResp.Series = resp.Data
+
+		return filtered, true
+	})
+}
+
+// handleLabels forwards the label names request, and filters the answered label names.
+func (d *DirectHandler) handleLabels(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *datasource.APIParams,
+	id *identity.Identity,
+) {
+	var labelsResp grafana.LabelValuesReq
+
+	d.api.Filter(rw, req, next, params, &labelsResp, func() (int, bool) {
+		resp, ok := d.filterLabelNames(req.Context(), &prometheus.FilterLabelNamesReq{
+			User:       id.User,
+			Teams:      id.Teams,
+			OrgID:      id.OrgID,
+			Names:      labelsResp.Data,
+			Datasource: grafana.Datasource{UID: d.datasourceUID},
+		})
+		if !ok {
+			return 0, false
+		}
+
+		filtered := len(labelsResp.Data) - len(resp.Data)
+		labelsResp.Data = resp.Data
+
+		return filtered, true
+	})
+}
+
+// handleLabelValues forwards the label values request, and filters the answered values.
+func (d *DirectHandler) handleLabelValues(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *datasource.APIParams,
+	id *identity.Identity,
+	name string,
+) {
+	var valuesResp grafana.LabelValuesReq
+
+	d.api.Filter(rw, req, next, params, &valuesResp, func() (int, bool) {
+		resp, ok := d.filterLabelValues(req.Context(), &prometheus.FilterLabelValuesReq{
+			User:       id.User,
+			Teams:      id.Teams,
+			OrgID:      id.OrgID,
+			Label:      &prometheus.Label{Name: name, Values: valuesResp.Data},
+			Datasource: grafana.Datasource{UID: d.datasourceUID},
+		})
+		if !ok {
+			return 0, false
+		}
+
+		filtered := len(valuesResp.Data) - len(resp.Data)
+		valuesResp.Data = resp.Data
+
+		return filtered, true
+	})
+}
+
+// handleExemplars forwards the exemplars request, and filters the answered exemplars by their series.
+func (d *DirectHandler) handleExemplars(
+	rw http.ResponseWriter,
+	req *http.Request,
+	next http.Handler,
+	params *datasource.APIParams,
+	id *identity.Identity,
+) {
+	var exemplarsResp grafana.ExemplarsReq
+
+	d.api.Filter(rw, req, next, params, &exemplarsResp, func() (int, bool) {
+		resp, ok := d.filterSeries(req.Context(), failure.EndpointExemplars, &prometheus.FilterSeriesReq{
+			User:       id.User,
+			Teams:      id.Teams,
+			OrgID:      id.OrgID,
+			Series:     exemplarSeries(exemplarsResp.Data),
+			Datasource: grafana.Datasource{UID: d.datasourceUID},
+		})
+		if !ok {
+			return 0, false
+		}
+
+		exemplars := visibleExemplars(exemplarsResp.Data, resp.Data)
+
+		filtered := len(exemplarsResp.Data) - len(exemplars)
+		exemplarsResp.Data = exemplars
+
+		return filtered, true
+	})
+}
+
+// filterSeries filters the series with Giam, and applies the failure policy of the endpoint when Giam can't be
+// reached. It returns false when the request must be denied.
+func (d *DirectHandler) filterSeries(
+	ctx context.Context,
+	endpoint string,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, bool) {
+	resp, err := d.service.FilterSeries(ctx, payload)
+	if err == nil {
+		d.failurePolicy.Remember(string(datasource.Prometheus), endpoint, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	d.logger.Ctx(ctx).Debugw("unable to send prometheus filter series request to Giam", "err", err)
+
+	decision, ok := d.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		endpoint,
+		payload,
+		err,
+		&prometheus.FilterSeriesResp{Data: payload.Series, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*prometheus.FilterSeriesResp), true
+}
+
+// filterLabelNames filters the label names with Giam, and applies the failure policy when Giam can't be reached. It
+// returns false when the request must be denied.
+func (d *DirectHandler) filterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, bool) {
+	resp, err := d.service.FilterLabelNames(ctx, payload)
+	if err == nil {
+		d.failurePolicy.Remember(string(datasource.Prometheus), failure.EndpointLabelNames, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	d.logger.Ctx(ctx).Debugw("unable to send prometheus filter label names request to Giam", "err", err)
+
+	decision, ok := d.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		failure.EndpointLabelNames,
+		payload,
+		err,
+		&prometheus.FilterLabelNamesResp{Data: payload.Names, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*prometheus.FilterLabelNamesResp), true
+}
+
+// filterLabelValues filters the label values with Giam, and applies the failure policy when Giam can't be reached. It
+// returns false when the request must be denied.
+func (d *DirectHandler) filterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, bool) {
+	resp, err := d.service.FilterLabelValues(ctx, payload)
+	if err == nil {
+		d.failurePolicy.Remember(string(datasource.Prometheus), failure.EndpointLabelValues, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	d.logger.Ctx(ctx).Debugw("unable to send prometheus filter label values request to Giam", "err", err)
+
+	decision, ok := d.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		failure.EndpointLabelValues,
+		payload,
+		err,
+		&prometheus.FilterLabelValuesResp{Data: payload.Label.Values, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*prometheus.FilterLabelValuesResp), true
+}
+
+// failureEndpoint returns the endpoint the failure mode of a request to the prometheus HTTP API is configured for.
+func failureEndpoint(endpoint string) string {
+	switch endpoint {
+	case directSeries:
+		return failure.EndpointSeries
+	case directLabels:
+		return failure.EndpointLabelNames
+	case directLabelValues:
+		return failure.EndpointLabelValues
+	case directExemplars:
+		return failure.EndpointExemplars
+	default:
+		return failure.EndpointQuery
+	}
+}
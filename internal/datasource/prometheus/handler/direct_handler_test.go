@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+// multipartParams returns the params encoded as a multipart body, along with its content type.
+func multipartParams(t *testing.T, params url.Values) (string, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for name, values := range params {
+		for _, value := range values {
+			require.NoError(t, writer.WriteField(name, value))
+		}
+	}
+
+	require.NoError(t, writer.Close())
+
+	return body.String(), writer.FormDataContentType()
+}
+
+func TestDirectHandler_Handle(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		method             string
+		path               string
+		params             url.Values
+		contentType        string
+		multipart          bool
+		authorizer         *datasource.MockQueryAuthorizer
+		service            prometheus.Service
+		respBody           string
+		expectedParams     url.Values
+		expectedData       string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:               "It should rewrite the query of an instant query",
+			method:             http.MethodGet,
+			path:               "/api/v1/query",
+			params:             url.Values{"query": {"up"}, "time": {"1700000000"}},
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`up{team="payment"}`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": {"resultType": "vector", "result": []}}`,
+			expectedParams:     url.Values{"query": {`up{team="payment"}`}, "time": {"1700000000"}},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should rewrite the query of a range query sent as a form",
+			method:             http.MethodPost,
+			path:               "/api/v1/query_range",
+			params:             url.Values{"query": {"rate(http_requests_total[5m])"}, "step": {"30"}},
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`rate(http_requests_total{team="payment"}[5m])`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": {"resultType": "matrix", "result": []}}`,
+			expectedParams:     url.Values{"query": {`rate(http_requests_total{team="payment"}[5m])`}, "step": {"30"}},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should rewrite the query of a form whatever the case of its content type",
+			method:             http.MethodPost,
+			path:               "/api/v1/query",
+			params:             url.Values{"query": {"up"}},
+			contentType:        "Application/X-WWW-Form-Urlencoded",
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`up{team="payment"}`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": {"resultType": "vector", "result": []}}`,
+			expectedParams:     url.Values{"query": {`up{team="payment"}`}},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should rewrite the query of a multipart body and forward it as a form",
+			method:             http.MethodPost,
+			path:               "/api/v1/query",
+			params:             url.Values{"query": {"up"}},
+			multipart:          true,
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`up{team="payment"}`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": {"resultType": "vector", "result": []}}`,
+			expectedParams:     url.Values{"query": {`up{team="payment"}`}},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should reject a body which can't be read",
+			method:             http.MethodPost,
+			path:               "/api/v1/query",
+			params:             url.Values{"query": {"up"}},
+			contentType:        "application/json",
+			authorizer:         &datasource.MockQueryAuthorizer{},
+			service:            &service.Mock{},
+			expectedBody:       "Unable to read request body",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "It should reject a query without expression",
+			method:             http.MethodGet,
+			path:               "/api/v1/query_range",
+			params:             url.Values{"step": {"30"}},
+			authorizer:         &datasource.MockQueryAuthorizer{},
+			service:            &service.Mock{},
+			expectedBody:       "Missing query parameter",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "It should rewrite every match[] of a series request and filter the series",
+			method:     http.MethodGet,
+			path:       "/api/v1/series",
+			params:     url.Values{"match[]": {"up", "http_requests_total"}},
+			authorizer: datasource.NewRewritingQueryAuthorizer(`up{team="payment"}`, `http_requests_total{team="payment"}`),
+			service: &service.Mock{FilterSeriesResp: &prometheus.FilterSeriesResp{
+				Data: []map[string]string{{"__name__": "up", "team": "payment"}},
+			}},
+			respBody: `{"status": "success", "data": [
+				{"__name__": "up", "team": "payment"},
+				{"__name__": "up", "team": "menu"}
+			]}`,
+			expectedParams:     url.Values{"match[]": {`up{team="payment"}`, `http_requests_total{team="payment"}`}},
+			expectedData:       `[{"__name__": "up", "team": "payment"}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "It should filter the label names",
+			method:     http.MethodGet,
+			path:       "/api/v1/labels",
+			params:     url.Values{},
+			authorizer: &datasource.MockQueryAuthorizer{},
+			service: &service.Mock{FilterLabelNamesResp: &prometheus.FilterLabelNamesResp{
+				Data: []string{"__name__", "team"},
+			}},
+			respBody:           `{"status": "success", "data": ["__name__", "secret", "team"]}`,
+			expectedParams:     url.Values{},
+			expectedData:       `["__name__", "team"]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "It should rewrite the selector sent to the label values endpoint and filter the values",
+			method:     http.MethodGet,
+			path:       "/api/v1/label/team/values",
+			params:     url.Values{},
+			authorizer: datasource.NewRewritingQueryAuthorizer(`{__name__=~".+",team="payment"}`),
+			service: &service.Mock{FilterLabelValuesResp: &prometheus.FilterLabelValuesResp{
+				Data: []string{"payment"},
+			}},
+			respBody:           `{"status": "success", "data": ["menu", "payment"]}`,
+			expectedParams:     url.Values{"match[]": {`{__name__=~".+",team="payment"}`}},
+			expectedData:       `["payment"]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should narrow down the metric names with the rewritten selector",
+			method:             http.MethodGet,
+			path:               "/api/v1/label/__name__/values",
+			params:             url.Values{"match[]": {`{job="api"}`}},
+			authorizer:         datasource.NewRewritingQueryAuthorizer(`{job="api",team="payment"}`),
+			service:            &service.Mock{},
+			respBody:           `{"status": "success", "data": ["up"]}`,
+			expectedParams:     url.Values{"match[]": {`{job="api",team="payment"}`}},
+			expectedData:       `["up"]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:       "It should rewrite the query of an exemplars request and filter the exemplar series",
+			method:     http.MethodGet,
+			path:       "/api/v1/query_exemplars",
+			params:     url.Values{"query": {"http_request_duration_seconds_bucket"}},
+			authorizer: datasource.NewRewritingQueryAuthorizer(`http_request_duration_seconds_bucket{team="payment"}`),
+			service: &service.Mock{FilterSeriesResp: &prometheus.FilterSeriesResp{
+				Data: []map[string]string{{"__name__": "http_request_duration_seconds_bucket", "team": "payment"}},
+			}},
+			respBody:       exemplarsResponse,
+			expectedParams: url.Values{"query": {`http_request_duration_seconds_bucket{team="payment"}`}},
+			expectedData: `[{
+				"seriesLabels": {"__name__": "http_request_duration_seconds_bucket", "team": "payment"},
+				"exemplars": [{"labels": {"trace_id": "7f3a"}, "value": "0.25", "timestamp": 1700000000.1}]
+			}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should reject an exemplars request without query",
+			method:             http.MethodGet,
+			path:               "/api/v1/query_exemplars",
+			params:             url.Values{"start": {"1700000000"}},
+			authorizer:         &datasource.MockQueryAuthorizer{},
+			service:            &service.Mock{},
+			expectedBody:       "Missing query parameter",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:   "It should reject a denied query",
+			method: http.MethodGet,
+			path:   "/api/v1/query",
+			params: url.Values{"query": {"up"}},
+			authorizer: &datasource.MockQueryAuthorizer{AuthorizedQueries: &datasource.AuthorizedQueries{
+				Message:    "no policy allows access to the datasource",
+				StatusCode: http.StatusForbidden,
+			}},
+			service:            &service.Mock{},
+			expectedBody:       "no policy allows access to the datasource",
+			expectedStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.method == http.MethodPost {
+				body, contentType := tt.params.Encode(), "application/x-www-form-urlencoded"
+				if tt.multipart {
+					body, contentType = multipartParams(t, tt.params)
+				} else if tt.contentType != "" {
+					contentType = tt.contentType
+				}
+
+				req = httptest.NewRequest(http.MethodPost, "/prometheus"+tt.path, strings.NewReader(body))
+				req.Header.Set("Content-Type", contentType)
+			} else {
+				req = httptest.NewRequest(http.MethodGet, "/prometheus"+tt.path+"?"+tt.params.Encode(), nil)
+			}
+
+			req.RemoteAddr = "192.0.2.10:41234"
+			req.Header.Set("X-Webauth-User", "jdoe")
+			req.Header.Set("X-Webauth-Groups", "payment")
+
+			rr := httptest.NewRecorder()
+
+			handler := NewDirectHandler(&DirectHandlerDeps{
+				Identities: identity.NewAuthProxy(&identity.AuthProxyDeps{
+					UserHeader:   "X-Webauth-User",
+					GroupsHeader: "X-Webauth-Groups",
+					Trusted:      []*net.IPNet{trusted},
+					GroupTeams:   map[string]int{"payment": 2},
+					Logger:       log.New("FATAL"),
+				}),
+				Authorizer:    tt.authorizer,
+				Service:       tt.service,
+				DatasourceUID: "P0dfd3df3dfd",
+				PathPrefix:    "/prometheus",
+				Logger:        log.New("FATAL"),
+			})
+
+			assert.True(t, handler.Match(req))
+
+			next := &mocks.NextHandler{RespBody: []byte(tt.respBody)}
+
+			handler.Handle(rr, req, next)
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			if tt.expectedStatusCode != http.StatusOK {
+				assert.False(t, next.Called)
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(rr.Body.String()))
+
+				return
+			}
+
+			for _, query := range tt.authorizer.Received {
+				assert.Equal(t, "P0dfd3df3dfd", datasource.QueryDatasourceUID(query))
+			}
+
+			forwarded := req.URL.Query()
+			if tt.method == http.MethodPost {
+				forwarded, err = url.ParseQuery(string(next.ReceivedBody))
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tt.expectedParams.Encode(), forwarded.Encode())
+
+			if tt.expectedData != "" {
+				var actualResponse struct {
+					Data json.RawMessage `json:"data"`
+				}
+
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&actualResponse))
+				assert.JsonEq(t, tt.expectedData, string(actualResponse.Data))
+			}
+		})
+	}
+}
+
+func TestDirectHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "it should return true for the prometheus HTTP API under the prefix",
+			url:  "/prometheus/api/v1/query_range?query=up",
+			want: true,
+		},
+		{
+			name: "it should return false for the prometheus HTTP API without the prefix",
+			url:  "/api/v1/query?query=up",
+			want: false,
+		},
+		{
+			name: "it should return true for the label values",
+			url:  "/prometheus/api/v1/label/team/values",
+			want: true,
+		},
+		{
+			name: "it should return true for the exemplars",
+			url:  "/prometheus/api/v1/query_exemplars?query=up",
+			want: true,
+		},
+		{
+			name: "it should return false for the endpoints which aren't enforced",
+			url:  "/prometheus/api/v1/status/config",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewDirectHandler(&DirectHandlerDeps{DatasourceUID: "P0dfd3df3dfd", PathPrefix: "/prometheus"})
+			if got := h.Match(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/internal/types"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+const exemplarsEndpointPattern = `^/api/datasources/uid/([a-zA-Z0-9-]+)/resources/api/v1/query_exemplars`
+
+var exemplarsEndpointRegexExp = regexp.MustCompile(exemplarsEndpointPattern)
+
+// ExemplarsHandler enforces the exemplar queries grafana sends when exemplars are toggled on. The query is rewritten
+// the same way as the grafana queries, and the series of the answered exemplars, whose labels carry the trace ids, are
+// filtered down to the series the user can see.
+type ExemplarsHandler struct {
+	identities    identity.Resolver
+	authorizer    datasource.QueryAuthorizer
+	service       prometheus.Service
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
+	logger        *log.Logger
+}
+
+type ExemplarsHandlerDeps struct {
+	Identities identity.Resolver
+	// Authorizer rewrites the exemplar query, it is the authorizer of the prometheus grafana queries.
+	Authorizer datasource.QueryAuthorizer
+	// Service filters the series of the answered exemplars.
+	Service       prometheus.Service
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the original query and the unfiltered exemplars, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
+}
+
+func NewExemplarsHandler(deps *ExemplarsHandlerDeps) handler.Handler {
+	return &ExemplarsHandler{
+		identities:    deps.Identities,
+		authorizer:    deps.Authorizer,
+		service:       deps.Service,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
+		logger:        deps.Logger,
+	}
+}
+
+func (e *ExemplarsHandler) Match(req *http.Request) bool {
+	return exemplarsEndpointRegexExp.MatchString(req.URL.Path)
+}
+
+func (e *ExemplarsHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	e.logger.Ctx(req.Context()).Debugw("instantiated a prometheus exemplars authorize")
+
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(failure.EndpointExemplars)
+	event.SetShadow(e.shadow)
+
+	matches := exemplarsEndpointRegexExp.FindStringSubmatch(req.URL.Path)
+
+	// Requests without credentials are rejected before reaching the upstream.
+	_, err := e.identities.Credentials(req)
+	if err != nil {
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	params, err := datasource.ReadAPIParams(req)
+	if err != nil {
+		http.Error(rw, "Unable to read request body", http.StatusBadRequest)
+
+		return
+	}
+
+	id, err := e.identities.Resolve(req)
+	if err != nil {
+		e.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
+
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	event.SetIdentity(id)
+
+	if e.bypass.Allows(req, id) {
+		params.Forward(rw, req, next)
+
+		return
+	}
+
+	// A query without expression is never forwarded, it would reach the upstream unenforced.
+	if params.Get("query") == "" {
+		http.Error(rw, "Missing query parameter", http.StatusBadRequest)
+
+		return
+	}
+
+	query := map[string]interface{}{
+		"refId":      "exemplars",
+		"expr":       params.Get("query"),
+		"datasource": map[string]interface{}{"uid": matches[1], "type": string(datasource.Prometheus)},
+	}
+
+	authorized, err := e.authorize(req.Context(), &datasource.AuthorizeQueriesReq{
+		User:    id.User,
+		Teams:   id.Teams,
+		OrgID:   id.OrgID,
+		Queries: []interface{}{query},
+	})
+	if err != nil {
+		e.logger.Ctx(req.Context()).Debugw("unable to authorize the exemplar query", "err", err)
+
+		event.SetDecision(audit.DecisionError)
+
+		if e.shadow {
+			params.Forward(rw, req, next)
+
+			return
+		}
+
+		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	if authorized.StatusCode != http.StatusOK {
+		event.SetDecision(audit.DecisionDeny)
+
+		if e.shadow {
+			e.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the denied exemplar query",
+				"datasource_uid", matches[1], "status", authorized.StatusCode, "message", authorized.Message)
+
+			params.Forward(rw, req, next)
+
+			return
+		}
+
+		http.Error(rw, authorized.Message, authorized.StatusCode)
+
+		return
+	}
+
+	rewritten := datasource.QueryExpr(authorized.Queries[0])
+
+	event.AddQuery(&audit.Query{
+		DatasourceUID:  matches[1],
+		DatasourceType: string(datasource.Prometheus),
+		Original:       params.Get("query"),
+		Rewritten:      rewritten,
+	})
+
+	if e.shadow && params.Get("query") != rewritten {
+		e.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the original exemplar query",
+			"datasource_uid", matches[1], "original", params.Get("query"), "rewritten", rewritten)
+	}
+
+	if !e.shadow {
+		params.Set("query", rewritten)
+	}
+
+	w := &types.ResponseWriter{
+		ResponseWriter: rw,
+		Body:           &bytes.Buffer{},
+		Status:         http.StatusOK,
+	}
+
+	params.Forward(w, req, next)
+
+	upstreamBody := w.Body.Bytes()
+
+	var grafanaResp grafana.ExemplarsReq
+
+	err = datasource.ReadResponse(w, &grafanaResp)
+	if err != nil || w.Status != http.StatusOK {
+		// Errors answered by prometheus, such as an invalid query, don't carry any exemplar.
+		event.SetDecision(audit.DecisionAllow)
+
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	resp, ok := e.filterSeries(req.Context(), &prometheus.FilterSeriesReq{
+		User:       id.User,
+		Teams:      id.Teams,
+		OrgID:      id.OrgID,
+		Series:     exemplarSeries(grafanaResp.Data),
+		Datasource: grafana.Datasource{UID: matches[1]}, // Uid exists in first index of the regx
+	})
+	if !ok {
+		event.SetDecision(audit.DecisionError)
+
+		if e.shadow {
+			rw.WriteHeader(w.Status)
+			rw.Write(upstreamBody)
+
+			return
+		}
+
+		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	exemplars := visibleExemplars(grafanaResp.Data, resp.Data)
+
+	event.SetDecision(audit.DecisionAllow)
+
+	filtered := len(grafanaResp.Data) - len(exemplars)
+	event.SetFiltered(filtered)
+
+	if e.shadow {
+		if filtered > 0 {
+			e.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the unfiltered exemplars",
+				"datasource_uid", matches[1], "filtered", filtered)
+		}
+
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	grafanaResp.Data = exemplars
+
+	datasource.WriteResponse(rw, w.Status, &grafanaResp)
+}
+
+// authorize rewrites the exemplar query with the prometheus authorizer, and applies the failure policy when Giam
+// can't be reached.
+func (e *ExemplarsHandler) authorize(
+	ctx context.Context,
+	payload *datasource.AuthorizeQueriesReq,
+) (*datasource.AuthorizedQueries, error) {
+	result, err := e.authorizer.AuthorizeQueries(ctx, payload)
+	if err == nil {
+		e.failurePolicy.Remember(string(datasource.Prometheus), failure.EndpointExemplars, payload, result)
+		audit.FromContext(ctx).SetGiamStatusCode(result.StatusCode)
+
+		return result, nil
+	}
+
+	decision, ok := e.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		failure.EndpointExemplars,
+		payload,
+		err,
+		&datasource.AuthorizedQueries{Queries: payload.Queries, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, err
+	}
+
+	return decision.(*datasource.AuthorizedQueries), nil
+}
+
+// filterSeries filters the series of the exemplars with Giam, and applies the failure policy when Giam can't be
+// reached. It returns false when the request must be denied.
+func (e *ExemplarsHandler) filterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, bool) {
+	resp, err := e.service.FilterSeries(ctx, payload)
+	if err == nil {
+		e.failurePolicy.Remember(string(datasource.Prometheus), failure.EndpointExemplars, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	e.logger.Ctx(ctx).Debugw("unable to send prometheus filter exemplar series request to Giam", "err", err)
+
+	decision, ok := e.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		failure.EndpointExemplars,
+		payload,
+		err,
+		&prometheus.FilterSeriesResp{Data: payload.Series, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*prometheus.FilterSeriesResp), true
+}
+
+// exemplarSeries returns the labels of the series the exemplars belong to.
+func exemplarSeries(exemplars []*grafana.ExemplarSeries) []map[string]string {
+	series := make([]map[string]string, len(exemplars))
+	for i, s := range exemplars {
+		series[i] = s.SeriesLabels
+	}
+
+	return series
+}
+
+// visibleExemplars returns the exemplars whose series is one of the visible ones.
+func visibleExemplars(exemplars []*grafana.ExemplarSeries, series []map[string]string) []*grafana.ExemplarSeries {
+	visible := make(map[string]bool, len(series))
+	for _, labels := range series {
+		visible[seriesKey(labels)] = true
+	}
+
+	kept := make([]*grafana.ExemplarSeries, 0, len(exemplars))
+
+	for _, s := range exemplars {
+		if visible[seriesKey(s.SeriesLabels)] {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
+
+// seriesKey identifies a series by its labels, whatever their order.
+func seriesKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"\xff"+value)
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "\xfe")
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+const exemplarsResponse = `{"status": "success", "data": [
+	{
+		"seriesLabels": {"__name__": "http_request_duration_seconds_bucket", "team": "payment"},
+		"exemplars": [{"labels": {"trace_id": "7f3a"}, "value": "0.25", "timestamp": 1700000000.1}]
+	},
+	{
+		"seriesLabels": {"__name__": "http_request_duration_seconds_bucket", "team": "menu"},
+		"exemplars": [{"labels": {"trace_id": "9c1b"}, "value": "0.5", "timestamp": 1700000000.2}]
+	}
+]}`
+
+func TestExemplarsHandler_Handle(t *testing.T) {
+	tests := []struct {
+		name               string
+		method             string
+		withoutQuery       bool
+		authorizer         *datasource.MockQueryAuthorizer
+		expectedQuery      string
+		expectedTraceIDs   []string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:   "It should rewrite the query of the url and filter the exemplar series",
+			method: http.MethodGet,
+			authorizer: &datasource.MockQueryAuthorizer{AuthorizedQueries: &datasource.AuthorizedQueries{
+				Queries:    []interface{}{map[string]interface{}{"expr": `http_request_duration_seconds_bucket{team="payment"}`}},
+				StatusCode: http.StatusOK,
+			}},
+			expectedQuery:      `http_request_duration_seconds_bucket{team="payment"}`,
+			expectedTraceIDs:   []string{"7f3a"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:   "It should rewrite the query of the form body",
+			method: http.MethodPost,
+			authorizer: &datasource.MockQueryAuthorizer{AuthorizedQueries: &datasource.AuthorizedQueries{
+				Queries:    []interface{}{map[string]interface{}{"expr": `http_request_duration_seconds_bucket{team="payment"}`}},
+				StatusCode: http.StatusOK,
+			}},
+			expectedQuery:      `http_request_duration_seconds_bucket{team="payment"}`,
+			expectedTraceIDs:   []string{"7f3a"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:   "It should reject the denied query",
+			method: http.MethodGet,
+			authorizer: &datasource.MockQueryAuthorizer{AuthorizedQueries: &datasource.AuthorizedQueries{
+				Message:    "no policy allows access to the datasource",
+				StatusCode: http.StatusForbidden,
+			}},
+			expectedBody:       "no policy allows access to the datasource",
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			name:               "It should reject the request without query",
+			method:             http.MethodGet,
+			withoutQuery:       true,
+			authorizer:         &datasource.MockQueryAuthorizer{},
+			expectedBody:       "Missing query parameter",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := url.Values{
+				"query": []string{"http_request_duration_seconds_bucket"},
+				"start": []string{"1700000000"},
+				"end":   []string{"1700003600"},
+			}
+			if tt.withoutQuery {
+				params.Del("query")
+			}
+
+			target := "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/query_exemplars"
+
+			var req *http.Request
+			if tt.method == http.MethodPost {
+				req = httptest.NewRequest(http.MethodPost, target, strings.NewReader(params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(http.MethodGet, target+"?"+params.Encode(), nil)
+			}
+
+			req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "mocked_session_value"})
+
+			rr := httptest.NewRecorder()
+
+			handler := &ExemplarsHandler{
+				logger:     log.New("FATAL"),
+				authorizer: tt.authorizer,
+				service: &service.Mock{FilterSeriesResp: &prometheus.FilterSeriesResp{
+					Data: []map[string]string{
+						{"team": "payment", "__name__": "http_request_duration_seconds_bucket"},
+					},
+				}},
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: &grafana.MockRepo{
+						User:  &grafana.User{ID: 1, Name: "user1"},
+						Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+					},
+					Logger: log.New("FATAL"),
+				}),
+			}
+
+			next := &mocks.NextHandler{RespBody: []byte(exemplarsResponse)}
+
+			handler.Handle(rr, req, next)
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			if !tt.withoutQuery {
+				assert.Equal(t, "http_request_duration_seconds_bucket", datasource.QueryExpr(tt.authorizer.Received[0]))
+			}
+
+			if tt.expectedStatusCode != http.StatusOK {
+				assert.False(t, next.Called)
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(rr.Body.String()))
+
+				return
+			}
+
+			forwarded := req.URL.Query()
+			if tt.method == http.MethodPost {
+				forwarded, _ = url.ParseQuery(string(next.ReceivedBody))
+			}
+
+			assert.Equal(t, tt.expectedQuery, forwarded.Get("query"))
+			assert.Equal(t, "1700003600", forwarded.Get("end"))
+
+			var actualResponse grafana.ExemplarsReq
+
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&actualResponse))
+
+			var traceIDs []string
+
+			for _, series := range actualResponse.Data {
+				for _, exemplar := range series.Exemplars {
+					labels := exemplar.(map[string]interface{})["labels"].(map[string]interface{})
+					traceIDs = append(traceIDs, labels["trace_id"].(string))
+				}
+			}
+
+			assert.CompareJson(t, tt.expectedTraceIDs, traceIDs)
+		})
+	}
+}
+
+func TestExemplarsHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "it should return true when the endpoint is for exemplars",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/query_exemplars?query=up",
+			want: true,
+		},
+		{
+			name: "it should return false when the endpoint is not for exemplars",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/query?query=up",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &ExemplarsHandler{}
+			if got := e.Match(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/internal/types"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+const labelNamesEndpointPattern = `^/api/datasources/uid/([a-zA-Z0-9-]+)/resources/api/v1/labels`
+
+var labelNamesEndpointRegexExp = regexp.MustCompile(labelNamesEndpointPattern)
+
+// LabelNamesHandler filters the label names answered by prometheus, such as the ones grafana autocompletes in the query
+// builder, down to the names of the series the user can see.
+type LabelNamesHandler struct {
+	service       prometheus.Service
+	identities    identity.Resolver
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
+	logger        *log.Logger
+}
+
+type LabelNamesHandlerDeps struct {
+	Service       prometheus.Service
+	Identities    identity.Resolver
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the upstream response once filtered, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
+}
+
+func NewLabelNamesHandler(deps *LabelNamesHandlerDeps) handler.Handler {
+	return &LabelNamesHandler{
+		service:       deps.Service,
+		identities:    deps.Identities,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
+		logger:        deps.Logger,
+	}
+}
+
+func (l *LabelNamesHandler) Match(req *http.Request) bool {
+	return labelNamesEndpointRegexExp.MatchString(req.URL.Path)
+}
+
+func (l *LabelNamesHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	l.logger.Ctx(req.Context()).Debugw("instantiated a prometheus label names filter")
+
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(failure.EndpointLabelNames)
+	event.SetShadow(l.shadow)
+
+	matches := labelNamesEndpointRegexExp.FindStringSubmatch(req.URL.Path)
+
+	w := &types.ResponseWriter{
+		ResponseWriter: rw,
+		Body:           &bytes.Buffer{},
+		Status:         http.StatusOK,
+	}
+
+	// Requests without credentials are rejected before reaching the upstream.
+	_, err := l.identities.Credentials(req)
+	if err != nil {
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	next.ServeHTTP(w, req)
+
+	upstreamBody := w.Body.Bytes()
+
+	var grafanaResp grafana.LabelValuesReq
+
+	err = datasource.ReadResponse(w, &grafanaResp)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	id, err := l.identities.Resolve(req)
+	if err != nil {
+		l.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
+
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	event.SetIdentity(id)
+
+	// Bypassing requests get the upstream response as is.
+	if l.bypass.Allows(req, id) {
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	resp, ok := l.filterLabelNames(req.Context(), &prometheus.FilterLabelNamesReq{
+		User:       id.User,
+		Teams:      id.Teams,
+		OrgID:      id.OrgID,
+		Names:      grafanaResp.Data,
+		Datasource: grafana.Datasource{UID: matches[1]}, // Uid exists in first index of the regx
+	})
+	if !ok {
+		event.SetDecision(audit.DecisionError)
+
+		if l.shadow {
+			rw.WriteHeader(w.Status)
+			rw.Write(upstreamBody)
+
+			return
+		}
+
+		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	event.SetDecision(audit.DecisionAllow)
+	event.AddQuery(&audit.Query{DatasourceUID: matches[1], DatasourceType: string(datasource.Prometheus)})
+
+	filtered := len(grafanaResp.Data) - len(resp.Data)
+	event.SetFiltered(filtered)
+
+	if l.shadow {
+		if filtered > 0 {
+			l.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the unfiltered label names",
+				"datasource_uid", matches[1], "filtered", filtered)
+		}
+
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	grafanaResp.Data = resp.Data
+
+	datasource.WriteResponse(rw, w.Status, &grafanaResp)
+}
+
+// filterLabelNames filters the label names with Giam, and applies the failure policy when Giam can't be reached.
+// It returns false when the request must be denied.
+func (l *LabelNamesHandler) filterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, bool) {
+	resp, err := l.service.FilterLabelNames(ctx, payload)
+	if err == nil {
+		l.failurePolicy.Remember(string(datasource.Prometheus), failure.EndpointLabelNames, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	l.logger.Ctx(ctx).Debugw("unable to send prometheus filter label names request to Giam", "err", err)
+
+	decision, ok := l.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		failure.EndpointLabelNames,
+		payload,
+		err,
+		&prometheus.FilterLabelNamesResp{Data: payload.Names, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*prometheus.FilterLabelNamesResp), true
+}
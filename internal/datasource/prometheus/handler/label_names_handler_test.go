@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestLabelNamesHandler_Handle(t *testing.T) {
+	tests := []struct {
+		name               string
+		service            prometheus.Service
+		shadow             bool
+		expectedData       []string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name: "It should return the filtered label names",
+			service: &service.Mock{
+				FilterLabelNamesResp: &prometheus.FilterLabelNamesResp{
+					Data: []string{"__name__", "customer"},
+				},
+			},
+			expectedData:       []string{"__name__", "customer"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "It should forward the unfiltered label names in shadow mode",
+			service: &service.Mock{
+				FilterLabelNamesResp: &prometheus.FilterLabelNamesResp{
+					Data: []string{"__name__", "customer"},
+				},
+			},
+			shadow:             true,
+			expectedData:       []string{"__name__", "customer", "secret"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should return an error when Giam can't be reached",
+			service:            &service.Mock{Error: errors.New("connection refused")},
+			expectedBody:       "Unable to communicate with Giam service",
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/labels", nil)
+
+			cookie := &http.Cookie{
+				Name:  "grafana_session",
+				Value: "mocked_session_value",
+			}
+			req.AddCookie(cookie)
+
+			rr := httptest.NewRecorder()
+
+			handler := &LabelNamesHandler{
+				logger:  log.New("FATAL"),
+				service: tt.service,
+				shadow:  tt.shadow,
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: &grafana.MockRepo{
+						User:  &grafana.User{ID: 1, Name: "user1"},
+						Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+					},
+					Logger: log.New("FATAL"),
+				}),
+			}
+
+			handler.Handle(rr, req, &mocks.NextHandler{
+				RespBody: []byte(`{"status": "success", "data": ["__name__", "customer", "secret"]}`),
+			})
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			if tt.expectedStatusCode == http.StatusOK {
+				var actualResponse grafana.LabelValuesReq
+
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&actualResponse))
+				assert.CompareJson(t, tt.expectedData, actualResponse.Data)
+			} else {
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(rr.Body.String()))
+			}
+		})
+	}
+}
+
+func TestLabelNamesHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "it should return true when the endpoint is for label names",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/labels?start=1700000000",
+			want: true,
+		},
+		{
+			name: "it should return false when the endpoint is for label values",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/customer/values",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &LabelNamesHandler{service: &service.Mock{}}
+			if got := l.Match(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestLabelValuesHandler_Handle(t *testing.T) {
+	tests := []struct {
+		name               string
+		mockedResponse     string
+		headers            http.Header
+		compressedResponse bool
+		service            prometheus.Service
+		expectedData       []string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name:           "It should return the filtered label values",
+			mockedResponse: `{"status": "success", "data": ["customer1", "customer2", "customer3"]}`,
+			service: &service.Mock{
+				FilterLabelValuesResp: &prometheus.FilterLabelValuesResp{
+					Data: []string{"customer1", "customer3"},
+				},
+			},
+			expectedData:       []string{"customer1", "customer3"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:           "It should handle gzip-compressed response",
+			mockedResponse: `{"status": "success", "data": ["customer1", "customer2"]}`,
+			headers:        http.Header{"Content-Encoding": []string{"gzip"}},
+			service: &service.Mock{
+				FilterLabelValuesResp: &prometheus.FilterLabelValuesResp{
+					Data: []string{"customer2"},
+				},
+			},
+			compressedResponse: true,
+			expectedData:       []string{"customer2"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should return an error for invalid JSON response",
+			mockedResponse:     `{ "invalid JSON"`,
+			service:            &service.Mock{},
+			expectedBody:       "Internal server error",
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:               "It should return an error when Giam can't be reached",
+			mockedResponse:     `{"status": "success", "data": ["customer1"]}`,
+			service:            &service.Mock{Error: errors.New("connection refused")},
+			expectedBody:       "Unable to communicate with Giam service",
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(
+				http.MethodGet,
+				"/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/customer/values",
+				nil,
+			)
+
+			cookie := &http.Cookie{
+				Name:  "grafana_session",
+				Value: "mocked_session_value",
+			}
+			req.AddCookie(cookie)
+
+			rr := httptest.NewRecorder()
+
+			mockResponseBody := []byte(tt.mockedResponse)
+
+			if tt.compressedResponse {
+				var buf bytes.Buffer
+				gz := gzip.NewWriter(&buf)
+				_, err := gz.Write(mockResponseBody)
+				require.NoError(t, err)
+				require.NoError(t, gz.Close())
+				mockResponseBody = buf.Bytes()
+			}
+
+			handler := &LabelValuesHandler{
+				logger:  log.New("FATAL"),
+				service: tt.service,
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: &grafana.MockRepo{
+						User:  &grafana.User{ID: 1, Name: "user1"},
+						Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+					},
+					Logger: log.New("FATAL"),
+				}),
+			}
+
+			handler.Handle(rr, req, &mocks.NextHandler{
+				RespBody:  mockResponseBody,
+				HeaderMap: tt.headers,
+			})
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			if tt.expectedStatusCode == http.StatusOK {
+				var actualResponse grafana.LabelValuesReq
+
+				body := rr.Body.Bytes()
+
+				require.NoError(t, json.Unmarshal(body, &actualResponse))
+
+				contentLength, err := strconv.Atoi(rr.Header().Get("Content-Length"))
+
+				require.NoError(t, err)
+
+				assert.Equal(t, len(body), contentLength)
+				assert.Equal(t, "", rr.Header().Get("Content-Encoding"))
+				assert.Equal(t, "success", actualResponse.Status)
+				assert.CompareJson(t, tt.expectedData, actualResponse.Data)
+			} else {
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(rr.Body.String()))
+			}
+		})
+	}
+}
+
+// labelValuesService records the label whose values are filtered.
+type labelValuesService struct {
+	service.Mock
+	label string
+}
+
+func (l *labelValuesService) FilterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, error) {
+	l.label = payload.Label.Name
+
+	return l.Mock.FilterLabelValues(ctx, payload)
+}
+
+func TestLabelValuesHandler_HandleEncodedPath(t *testing.T) {
+	svc := &labelValuesService{Mock: service.Mock{
+		FilterLabelValuesResp: &prometheus.FilterLabelValuesResp{Data: []string{"api"}},
+	}}
+
+	handler := &LabelValuesHandler{
+		logger:  log.New("FATAL"),
+		service: svc,
+		identities: identity.NewSession(&identity.SessionDeps{
+			GrafanaRepo: &grafana.MockRepo{
+				User:  &grafana.User{ID: 1, Name: "user1"},
+				Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+			},
+			Logger: log.New("FATAL"),
+		}),
+	}
+
+	target := "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/%6Aob/values"
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "mocked_session_value"})
+
+	assert.True(t, handler.Match(req))
+
+	rr := httptest.NewRecorder()
+	handler.Handle(rr, req, &mocks.NextHandler{RespBody: []byte(`{"status": "success", "data": ["api", "billing"]}`)})
+
+	var actualResponse grafana.LabelValuesReq
+
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &actualResponse))
+	assert.Equal(t, "job", svc.label)
+	assert.CompareJson(t, []string{"api"}, actualResponse.Data)
+}
+
+func TestLabelValuesHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "it should return true when the endpoint is for label values",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/customer/values?start=1700000000",
+			want: true,
+		},
+		{
+			name: "it should return true when the path of the label values is percent-encoded",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/%6Aob/values",
+			want: true,
+		},
+		{
+			name: "it should return false when the endpoint is for the metric names",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/__name__/values",
+			want: false,
+		},
+		{
+			name: "it should return false when the path of the metric names is percent-encoded",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/%5F%5Fname%5F%5F/values",
+			want: false,
+		},
+		{
+			name: "it should return false when the endpoint is for label names",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/labels",
+			want: false,
+		},
+		{
+			name: "it should return false when the endpoint is not for label values",
+			url:  "/api/ds/query?ds_type=prometheus",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &LabelValuesHandler{service: &service.Mock{}}
+			if got := l.Match(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
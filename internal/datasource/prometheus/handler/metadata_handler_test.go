@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/repo"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestMetadataHandler_Handle(t *testing.T) {
+	teamMatcher, err := label.NewMatcher(label.MatchEqual, "team", "payment")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		service            prometheus.Service
+		repo               prometheus.Repo
+		expectedMetrics    []string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{
+			name: "It should return the metadata of the metrics the user can see",
+			service: &service.Mock{GetPolicyResp: &prometheus.GetPolicyResp{
+				Matchers:   []*label.Matcher{teamMatcher},
+				StatusCode: http.StatusOK,
+			}},
+			repo:               &repo.Mock{Metrics: []*prometheus.Metric{{Name: "payment_orders_total"}}},
+			expectedMetrics:    []string{"payment_orders_total"},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "It should return an error when Giam can't be reached",
+			service:            &service.Mock{Error: errors.New("connection refused")},
+			repo:               &repo.Mock{},
+			expectedBody:       "Unable to communicate with Giam service",
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/metadata", nil)
+
+			cookie := &http.Cookie{
+				Name:  "grafana_session",
+				Value: "mocked_session_value",
+			}
+			req.AddCookie(cookie)
+
+			rr := httptest.NewRecorder()
+
+			handler := &MetadataHandler{
+				logger:  log.New("FATAL"),
+				metrics: &metricFilter{service: tt.service, repo: tt.repo},
+				identities: identity.NewSession(&identity.SessionDeps{
+					GrafanaRepo: &grafana.MockRepo{
+						User:  &grafana.User{ID: 1, Name: "user1"},
+						Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+					},
+					Logger: log.New("FATAL"),
+				}),
+			}
+
+			handler.Handle(rr, req, &mocks.NextHandler{
+				RespBody: []byte(`{"status": "success", "data": {
+					"http_requests_total": [{"type": "counter", "help": "Requests.", "unit": ""}],
+					"payment_orders_total": [{"type": "counter", "help": "Orders.", "unit": ""}]
+				}}`),
+			})
+
+			assert.Equal(t, tt.expectedStatusCode, rr.Code)
+
+			if tt.expectedStatusCode == http.StatusOK {
+				var actualResponse grafana.MetadataReq
+
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&actualResponse))
+				assert.CompareJson(t, tt.expectedMetrics, metricNames(actualResponse.Data))
+				assert.Equal(t, "Orders.", actualResponse.Data["payment_orders_total"][0].Help)
+			} else {
+				assert.Equal(t, tt.expectedBody, strings.TrimSpace(rr.Body.String()))
+			}
+		})
+	}
+}
+
+func TestMetadataHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "it should return true when the endpoint is for metric metadata",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/metadata?limit=1000",
+			want: true,
+		},
+		{
+			name: "it should return false when the endpoint is not for metric metadata",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/labels",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MetadataHandler{}
+			if got := m.Match(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
+
+// metricNameLabel is the label holding the name of a metric.
+const metricNameLabel = "__name__"
+
+// metricFilter filters metric names down to the metrics the user can see: the names the __name__ matchers of the
+// policy allow and, when the policy constrains other labels, the metrics with at least one series satisfying those
+// matchers, found by probing the datasource.
+type metricFilter struct {
+	service prometheus.Service
+	repo    prometheus.Repo
+}
+
+func (f *metricFilter) filter(
+	ctx context.Context,
+	creds *grafana.Credentials,
+	payload *prometheus.FilterMetricsReq,
+) (*prometheus.FilterMetricsResp, error) {
+	policy, err := f.service.GetPolicy(ctx, &prometheus.GetPolicyReq{
+		User:       payload.User,
+		Teams:      payload.Teams,
+		OrgID:      payload.OrgID,
+		Datasource: payload.Datasource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.StatusCode != http.StatusOK {
+		return &prometheus.FilterMetricsResp{Data: []string{}, StatusCode: policy.StatusCode}, nil
+	}
+
+	var nameMatchers, seriesMatchers []*label.Matcher
+
+	for _, m := range policy.Matchers {
+		if m.Name == metricNameLabel {
+			nameMatchers = append(nameMatchers, m)
+		} else {
+			seriesMatchers = append(seriesMatchers, m)
+		}
+	}
+
+	var visible map[string]bool
+
+	if len(seriesMatchers) > 0 {
+		metrics, err := f.repo.GetMetrics(ctx, creds, payload.Datasource.UID, seriesMatchers)
+		if err != nil {
+			return nil, err
+		}
+
+		visible = make(map[string]bool, len(metrics))
+		for _, metric := range metrics {
+			visible[metric.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(payload.Names))
+
+	for _, name := range payload.Names {
+		if visible != nil && !visible[name] {
+			continue
+		}
+
+		if label.MatchesLabels(nameMatchers, map[string]string{metricNameLabel: name}) {
+			names = append(names, name)
+		}
+	}
+
+	return &prometheus.FilterMetricsResp{Data: names, StatusCode: http.StatusOK}, nil
+}
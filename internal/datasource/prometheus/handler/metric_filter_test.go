@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/repo"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestMetricFilter_Filter(t *testing.T) {
+	mustMatcher := func(t label.MatchType, name, value string) *label.Matcher {
+		m, err := label.NewMatcher(t, name, value)
+		if err != nil {
+			panic(err)
+		}
+
+		return m
+	}
+
+	names := []string{"http_requests_total", "node_cpu_seconds_total", "payment_orders_total"}
+
+	tests := []struct {
+		name             string
+		policy           *prometheus.GetPolicyResp
+		repo             *repo.Mock
+		expectedData     []string
+		expectedStatus   int
+		expectedMatchers []*label.Matcher
+		expectedErr      bool
+	}{
+		{
+			name:           "it should keep every metric when the policy has no matchers",
+			policy:         &prometheus.GetPolicyResp{StatusCode: http.StatusOK},
+			repo:           &repo.Mock{Err: errors.New("unexpected probe")},
+			expectedData:   names,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "it should filter the metrics with the __name__ matchers of the policy",
+			policy: &prometheus.GetPolicyResp{
+				Matchers:   []*label.Matcher{mustMatcher(label.MatchRegexp, "__name__", "http_.*|payment_.*")},
+				StatusCode: http.StatusOK,
+			},
+			repo:           &repo.Mock{Err: errors.New("unexpected probe")},
+			expectedData:   []string{"http_requests_total", "payment_orders_total"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "it should keep the metrics with series satisfying the other matchers of the policy",
+			policy: &prometheus.GetPolicyResp{
+				Matchers: []*label.Matcher{
+					mustMatcher(label.MatchNotEqual, "__name__", "http_requests_total"),
+					mustMatcher(label.MatchEqual, "team", "payment"),
+				},
+				StatusCode: http.StatusOK,
+			},
+			repo: &repo.Mock{Metrics: []*prometheus.Metric{
+				{Name: "http_requests_total"},
+				{Name: "payment_orders_total"},
+			}},
+			expectedData:     []string{"payment_orders_total"},
+			expectedStatus:   http.StatusOK,
+			expectedMatchers: []*label.Matcher{mustMatcher(label.MatchEqual, "team", "payment")},
+		},
+		{
+			name:           "it should keep no metric when the policy denies the datasource",
+			policy:         &prometheus.GetPolicyResp{StatusCode: http.StatusForbidden},
+			repo:           &repo.Mock{},
+			expectedData:   []string{},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "it should fail when the datasource can't be probed",
+			policy: &prometheus.GetPolicyResp{
+				Matchers:   []*label.Matcher{mustMatcher(label.MatchEqual, "team", "payment")},
+				StatusCode: http.StatusOK,
+			},
+			repo:        &repo.Mock{Err: errors.New("connection refused")},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &metricFilter{service: &service.Mock{GetPolicyResp: tt.policy}, repo: tt.repo}
+
+			creds := &grafana.Credentials{Session: "mocked_session_value"}
+
+			resp, err := f.filter(context.Background(), creds, &prometheus.FilterMetricsReq{
+				Names:      names,
+				Datasource: grafana.Datasource{UID: "P0dfd3df3dfd"},
+			})
+			if tt.expectedErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			assert.CompareJson(t, tt.expectedData, resp.Data)
+			assert.CompareJson(t, tt.expectedMatchers, tt.repo.Matchers)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
+	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/internal/types"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+const metricNamesEndpointPattern = `^/api/datasources/uid/([a-zA-Z0-9-]+)/resources/api/v1/label/__name__/values`
+
+var metricNamesEndpointRegexExp = regexp.MustCompile(metricNamesEndpointPattern)
+
+// MetricNamesHandler filters the metric names grafana autocompletes in the query builder, the values of the __name__
+// label, down to the metrics the user can see.
+type MetricNamesHandler struct {
+	metrics       *metricFilter
+	identities    identity.Resolver
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
+	logger        *log.Logger
+}
+
+type MetricNamesHandlerDeps struct {
+	Service prometheus.Service
+	// Repo probes the metrics with series the user can see when the policy constrains other labels than __name__.
+	Repo          prometheus.Repo
+	Identities    identity.Resolver
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the upstream response once filtered, the decision is only logged and audited.
+	Shadow bool
+	Logger *log.Logger
+}
+
+func NewMetricNamesHandler(deps *MetricNamesHandlerDeps) handler.Handler {
+	return &MetricNamesHandler{
+		metrics:       &metricFilter{service: deps.Service, repo: deps.Repo},
+		identities:    deps.Identities,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
+		logger:        deps.Logger,
+	}
+}
+
+func (l *MetricNamesHandler) Match(req *http.Request) bool {
+	return metricNamesEndpointRegexExp.MatchString(req.URL.Path)
+}
+
+func (l *MetricNamesHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	l.logger.Ctx(req.Context()).Debugw("instantiated a prometheus metric names filter")
+
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(failure.EndpointLabelValues)
+	event.SetShadow(l.shadow)
+
+	matches := metricNamesEndpointRegexExp.FindStringSubmatch(req.URL.Path)
+
+	w := &types.ResponseWriter{
+		ResponseWriter: rw,
+		Body:           &bytes.Buffer{},
+		Status:         http.StatusOK,
+	}
+
+	// Requests without credentials are rejected before reaching the upstream.
+	creds, err := l.identities.Credentials(req)
+	if err != nil {
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	next.ServeHTTP(w, req)
+
+	upstreamBody := w.Body.Bytes()
+
+	var grafanaResp grafana.LabelValuesReq
+
+	err = datasource.ReadResponse(w, &grafanaResp)
+	if err != nil {
+		http.Error(rw, "Internal server error", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	id, err := l.identities.Resolve(req)
+	if err != nil {
+		l.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
+
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
+
+		return
+	}
+
+	event.SetIdentity(id)
+
+	// Bypassing requests get the upstream response as is.
+	if l.bypass.Allows(req, id) {
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	resp, ok := l.filterMetrics(req.Context(), creds, &prometheus.FilterMetricsReq{
+		User:       id.User,
+		Teams:      id.Teams,
+		OrgID:      id.OrgID,
+		Names:      grafanaResp.Data,
+		Datasource: grafana.Datasource{UID: matches[1]}, // Uid exists in first index of the regx
+	})
+	if !ok {
+		event.SetDecision(audit.DecisionError)
+
+		if l.shadow {
+			rw.WriteHeader(w.Status)
+			rw.Write(upstreamBody)
+
+			return
+		}
+
+		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
+
+		return
+	}
+
+	event.SetDecision(audit.DecisionAllow)
+	event.AddQuery(&audit.Query{DatasourceUID: matches[1], DatasourceType: string(datasource.Prometheus)})
+
+	filtered := len(grafanaResp.Data) - len(resp.Data)
+	event.SetFiltered(filtered)
+
+	if l.shadow {
+		if filtered > 0 {
+			l.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the unfiltered metric names",
+				"datasource_uid", matches[1], "filtered", filtered)
+		}
+
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
+	grafanaResp.Data = resp.Data
+
+	datasource.WriteResponse(rw, w.Status, &grafanaResp)
+}
+
+// filterMetrics filters the metric names, and applies the failure policy when Giam or the datasource can't be reached.
+// It returns false when the request must be denied.
+func (l *MetricNamesHandler) filterMetrics(
+	ctx context.Context,
+	creds *grafana.Credentials,
+	payload *prometheus.FilterMetricsReq,
+) (*prometheus.FilterMetricsResp, bool) {
+	resp, err := l.metrics.filter(ctx, creds, payload)
+	if err == nil {
+		l.failurePolicy.Remember(string(datasource.Prometheus), failure.EndpointLabelValues, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	l.logger.Ctx(ctx).Debugw("unable to filter the prometheus metric names", "err", err)
+
+	decision, ok := l.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		failure.EndpointLabelValues,
+		payload,
+		err,
+		&prometheus.FilterMetricsResp{Data: payload.Names, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*prometheus.FilterMetricsResp), true
+}
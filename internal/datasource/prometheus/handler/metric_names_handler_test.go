@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/repo"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/mocks"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestMetricNamesHandler_Handle(t *testing.T) {
+	nameMatcher, err := label.NewMatcher(label.MatchRegexp, "__name__", "payment_.*")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/__name__/values",
+		nil,
+	)
+	req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "mocked_session_value"})
+
+	rr := httptest.NewRecorder()
+
+	handler := &MetricNamesHandler{
+		logger: log.New("FATAL"),
+		metrics: &metricFilter{
+			service: &service.Mock{GetPolicyResp: &prometheus.GetPolicyResp{
+				Matchers:   []*label.Matcher{nameMatcher},
+				StatusCode: http.StatusOK,
+			}},
+			repo: &repo.Mock{},
+		},
+		identities: identity.NewSession(&identity.SessionDeps{
+			GrafanaRepo: &grafana.MockRepo{
+				User:  &grafana.User{ID: 1, Name: "user1"},
+				Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+			},
+			Logger: log.New("FATAL"),
+		}),
+	}
+
+	handler.Handle(rr, req, &mocks.NextHandler{
+		RespBody: []byte(`{"status": "success", "data": ["http_requests_total", "payment_orders_total"]}`),
+	})
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var actualResponse grafana.LabelValuesReq
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&actualResponse))
+	assert.CompareJson(t, []string{"payment_orders_total"}, actualResponse.Data)
+}
+
+func TestMetricNamesHandler_Match(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "it should return true when the endpoint is for the metric names",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/__name__/values",
+			want: true,
+		},
+		{
+			name: "it should return true when the path of the metric names is percent-encoded",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/%5F%5Fname%5F%5F/values",
+			want: true,
+		},
+		{
+			name: "it should return false when the endpoint is for the values of another label",
+			url:  "/api/datasources/uid/P0dfd3df3dfd/resources/api/v1/label/customer/values",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MetricNamesHandler{}
+			if got := m.Match(httptest.NewRequest(http.MethodGet, tt.url, nil)); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+)
+
+// QueryAuthorizer rewrites the prometheus queries of a grafana query request through Giam.
+type QueryAuthorizer struct {
+	logger        *log.Logger
+	prometheusSvc prometheus.Service
+	decisions     *datasource.DecisionCache
+}
+
+type QueryAuthorizerDeps struct {
+	Logger        *log.Logger
+	HashSvc       hash.Service
+	PrometheusSvc prometheus.Service
+	// DecisionTTL is how long the decision taken for identical queries is reused, zero disables it.
+	DecisionTTL time.Duration
+	// Metrics records the hits and misses of the decision cache, nil disables it.
+	Metrics *metrics.Registry
+}
+
+func NewQueryAuthorizer(deps *QueryAuthorizerDeps) datasource.QueryAuthorizer {
+	return &QueryAuthorizer{
+		logger:        deps.Logger,
+		prometheusSvc: deps.PrometheusSvc,
+		decisions: datasource.NewDecisionCache(&datasource.DecisionCacheDeps{
+			HashSvc: deps.HashSvc,
+			TTL:     deps.DecisionTTL,
+			Name:    "prometheus_decisions",
+			Metrics: deps.Metrics,
+		}),
+	}
+}
+
+func (l *QueryAuthorizer) AuthorizeQueries(
+	ctx context.Context,
+	payload *datasource.AuthorizeQueriesReq,
+) (*datasource.AuthorizedQueries, error) {
+	l.logger.Ctx(ctx).Debugw("instantiated a prometheus query authorize")
+
+	if resp, ok := l.decisions.Get(payload); ok {
+		l.logger.Ctx(ctx).Debugw("prometheus queries authorized from the decision cache")
+
+		return resp, nil
+	}
+
+	resp, err := l.prometheusSvc.AuthorizeQuery(ctx, &prometheus.AuthorizeQueryReq{
+		User:    payload.User,
+		Teams:   payload.Teams,
+		OrgID:   payload.OrgID,
+		Queries: payload.Queries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to send prometheus authorized query request to Giam: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		denied := &datasource.AuthorizedQueries{Message: resp.Message, StatusCode: resp.StatusCode}
+		l.decisions.Set(payload, denied)
+
+		return denied, nil
+	}
+
+	if len(resp.Queries) != len(payload.Queries) {
+		return nil, fmt.Errorf(
+			"giam returned %d queries for %d prometheus queries",
+			len(resp.Queries),
+			len(payload.Queries),
+		)
+	}
+
+	authorized := &datasource.AuthorizedQueries{Queries: resp.Queries, StatusCode: http.StatusOK}
+	l.decisions.Set(payload, authorized)
+
+	return authorized, nil
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+func TestQueryAuthorizer_AuthorizeQueries(t *testing.T) {
+	tests := []struct {
+		name          string
+		queries       []interface{}
+		hashSvc       hash.Service
+		prometheusSvc prometheus.Service
+		expectedResp  *datasource.AuthorizedQueries
+		expectError   bool
+	}{
+		{
+			name: "Test with multiple queries",
+			queries: []interface{}{
+				map[string]interface{}{
+					"expr": `http_status{cluster="customer1"}`,
+					"datasource": map[string]interface{}{
+						"uid": "dummyUID1",
+					},
+				},
+				map[string]interface{}{
+					"expr": `http_status{cluster="customer2", team="payment"}`,
+					"datasource": map[string]interface{}{
+						"uid": "dummyUID2",
+					},
+				},
+			},
+			hashSvc: &hash.MockService{
+				Hash: "dummyHash",
+				Err:  nil,
+			},
+			prometheusSvc: &service.Mock{
+				AuthorizedQueryResp: &prometheus.AuthorizedQueryResp{
+					Queries: []interface{}{
+						map[string]interface{}{
+							"expr": `http_status{cluster="customer1", team=~"menu"}`,
+							"datasource": map[string]interface{}{
+								"uid": "dummyUID1",
+							},
+						},
+						map[string]interface{}{
+							"expr": `http_status{cluster="customer2", team="payment"}`,
+							"datasource": map[string]interface{}{
+								"uid": "dummyUID2",
+							},
+						},
+					},
+					Message:    "random message",
+					StatusCode: http.StatusOK,
+				},
+				Error: nil,
+			},
+			expectedResp: &datasource.AuthorizedQueries{
+				Queries: []interface{}{
+					map[string]interface{}{
+						"expr": `http_status{cluster="customer1", team=~"menu"}`,
+						"datasource": map[string]interface{}{
+							"uid": "dummyUID1",
+						},
+					},
+					map[string]interface{}{
+						"expr": `http_status{cluster="customer2", team="payment"}`,
+						"datasource": map[string]interface{}{
+							"uid": "dummyUID2",
+						},
+					},
+				},
+				StatusCode: http.StatusOK,
+			},
+		},
+		{
+			name: "Test with single query when there is no team assigned for the user",
+			queries: []interface{}{
+				map[string]interface{}{
+					"expr": `http_status{cluster="customer3"}`,
+					"datasource": map[string]interface{}{
+						"uid": "dummyUID3",
+					},
+				},
+			},
+			hashSvc: &hash.MockService{
+				Hash: "dummyHash",
+				Err:  nil,
+			},
+			prometheusSvc: &service.Mock{
+				AuthorizedQueryResp: &prometheus.AuthorizedQueryResp{
+					Message:    "No Team Assigned",
+					StatusCode: http.StatusPreconditionFailed,
+				},
+				Error: nil,
+			},
+			expectedResp: &datasource.AuthorizedQueries{
+				Message:    "No Team Assigned",
+				StatusCode: http.StatusPreconditionFailed,
+			},
+		},
+		{
+			name: "Test when giam is unreachable",
+			queries: []interface{}{
+				map[string]interface{}{
+					"expr": `http_status{cluster="customer3"}`,
+				},
+			},
+			hashSvc:       &hash.MockService{},
+			prometheusSvc: &service.Mock{Error: errors.New("connection refused")},
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorizer := NewQueryAuthorizer(&QueryAuthorizerDeps{
+				Logger:        log.New("FATAL"),
+				HashSvc:       tt.hashSvc,
+				PrometheusSvc: tt.prometheusSvc,
+			})
+
+			resp, err := authorizer.AuthorizeQueries(context.Background(), &datasource.AuthorizeQueriesReq{
+				User:    &grafana.User{ID: 1, Name: "user1"},
+				Teams:   []*grafana.Team{{ID: 1, Name: "team1"}},
+				Queries: tt.queries,
+			})
+
+			if tt.expectError {
+				assert.Error(t, err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResp.StatusCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedResp.Message, resp.Message)
+			assert.CompareJson(t, tt.expectedResp.Queries, resp.Queries)
+		})
+	}
+}
+
+func TestQueryAuthorizer_DecisionCache(t *testing.T) {
+	prometheusSvc := &service.Mock{
+		AuthorizedQueryResp: &prometheus.AuthorizedQueryResp{
+			Queries: []interface{}{
+				map[string]interface{}{
+					"expr":       `up{team="payment"}`,
+					"datasource": map[string]interface{}{"uid": "prom"},
+					"intervalMs": 15000,
+				},
+			},
+			StatusCode: http.StatusOK,
+		},
+	}
+
+	authorizer := NewQueryAuthorizer(&QueryAuthorizerDeps{
+		Logger:        log.New("FATAL"),
+		HashSvc:       hash.NewService(),
+		PrometheusSvc: prometheusSvc,
+		DecisionTTL:   time.Minute,
+	})
+
+	authorize := func(user *grafana.User, teams []*grafana.Team, intervalMs int) (*datasource.AuthorizedQueries, error) {
+		return authorizer.AuthorizeQueries(context.Background(), &datasource.AuthorizeQueriesReq{
+			User:  user,
+			Teams: teams,
+			Queries: []interface{}{
+				map[string]interface{}{
+					"expr":       " up ",
+					"datasource": map[string]interface{}{"uid": "prom"},
+					"intervalMs": intervalMs,
+				},
+			},
+		})
+	}
+
+	jdoe := &grafana.User{ID: 1, Login: "jdoe"}
+
+	_, err := authorize(jdoe, []*grafana.Team{{ID: 1}, {ID: 2}}, 15000)
+	assert.NoError(t, err)
+
+	// Giam is no longer called for the same user, teams and queries, whatever the order of the teams and the
+	// fields other than the expression.
+	prometheusSvc.Error = errors.New("connection refused")
+
+	resp, err := authorize(jdoe, []*grafana.Team{{ID: 2}, {ID: 1}}, 30000)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.CompareJson(t, []interface{}{
+		map[string]interface{}{
+			"expr":       `up{team="payment"}`,
+			"datasource": map[string]interface{}{"uid": "prom"},
+			"intervalMs": 30000,
+		},
+	}, resp.Queries)
+
+	_, err = authorize(jdoe, []*grafana.Team{{ID: 1}}, 15000)
+	assert.Error(t, err)
+}
+
+func TestQueryAuthorizer_DecisionCacheIdentities(t *testing.T) {
+	prometheusSvc := &service.Mock{
+		AuthorizedQueryResp: &prometheus.AuthorizedQueryResp{
+			Queries: []interface{}{
+				map[string]interface{}{"expr": `up{team="payment"}`, "datasource": map[string]interface{}{"uid": "prom"}},
+			},
+			StatusCode: http.StatusOK,
+		},
+	}
+
+	authorizer := NewQueryAuthorizer(&QueryAuthorizerDeps{
+		Logger:        log.New("FATAL"),
+		HashSvc:       hash.NewService(),
+		PrometheusSvc: prometheusSvc,
+		DecisionTTL:   time.Minute,
+	})
+
+	authorize := func(user *grafana.User, teams []*grafana.Team) error {
+		_, err := authorizer.AuthorizeQueries(context.Background(), &datasource.AuthorizeQueriesReq{
+			User:  user,
+			Teams: teams,
+			Queries: []interface{}{
+				map[string]interface{}{"expr": "up", "datasource": map[string]interface{}{"uid": "prom"}},
+			},
+		})
+
+		return err
+	}
+
+	// The auth proxy and JWT identities have no grafana ids.
+	assert.NoError(t, authorize(&grafana.User{Login: "jdoe"}, []*grafana.Team{{Name: "payment"}}))
+
+	prometheusSvc.Error = errors.New("connection refused")
+
+	assert.NoError(t, authorize(&grafana.User{Login: "jdoe"}, []*grafana.Team{{Name: "payment"}}))
+	assert.Error(t, authorize(&grafana.User{Login: "asmith"}, []*grafana.Team{{Name: "payment"}}))
+	assert.Error(t, authorize(&grafana.User{Login: "jdoe"}, []*grafana.Team{{Name: "menu"}}))
+}
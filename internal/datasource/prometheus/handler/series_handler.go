@@ -3,13 +3,19 @@ package handler
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"regexp"
 
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
 	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
 	"github.com/usegiam/giam-traefik-plugin/internal/types"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
@@ -20,19 +26,33 @@ const seriesEndpointPattern = `^/api/datasources/uid/([a-zA-Z0-9-]+)/resources/a
 var seriesEndpointRegexExp = regexp.MustCompile(seriesEndpointPattern)
 
 type SeriesHandler struct {
-	grafanaRepo grafana.Repo
-	logger      *log.Logger
-	service     prometheus.Service
+	identities    identity.Resolver
+	logger        *log.Logger
+	service       prometheus.Service
+	failurePolicy *failure.Policy
+	bypass        *bypass.Policy
+	shadow        bool
 }
 
 type SeriesHandlerDeps struct {
-	GrafanaRepo grafana.Repo
-	Logger      *log.Logger
-	Service     prometheus.Service
+	Identities    identity.Resolver
+	Logger        *log.Logger
+	Service       prometheus.Service
+	FailurePolicy *failure.Policy
+	Bypass        *bypass.Policy
+	// Shadow forwards the upstream response once filtered, the decision is only logged and audited.
+	Shadow bool
 }
 
 func NewSeriesHandler(deps *SeriesHandlerDeps) handler.Handler {
-	return &SeriesHandler{service: deps.Service, logger: deps.Logger}
+	return &SeriesHandler{
+		identities:    deps.Identities,
+		logger:        deps.Logger,
+		service:       deps.Service,
+		failurePolicy: deps.FailurePolicy,
+		bypass:        deps.Bypass,
+		shadow:        deps.Shadow,
+	}
 }
 
 func (l *SeriesHandler) Match(req *http.Request) bool {
@@ -40,7 +60,11 @@ func (l *SeriesHandler) Match(req *http.Request) bool {
 }
 
 func (l *SeriesHandler) Handle(rw http.ResponseWriter, req *http.Request, next http.Handler) {
-	l.logger.Debug("instantiated a prometheus series filter")
+	l.logger.Ctx(req.Context()).Debugw("instantiated a prometheus series filter")
+
+	event := audit.FromContext(req.Context())
+	event.SetEndpoint(failure.EndpointSeries)
+	event.SetShadow(l.shadow)
 
 	w := &types.ResponseWriter{
 		ResponseWriter: rw,
@@ -51,15 +75,19 @@ func (l *SeriesHandler) Handle(rw http.ResponseWriter, req *http.Request, next h
 	regx := regexp.MustCompile(seriesEndpointPattern)
 	matches := regx.FindStringSubmatch(req.RequestURI)
 
-	grafanaSession, err := req.Cookie("grafana_session")
+	// Requests without credentials are rejected before reaching the upstream.
+	_, err := l.identities.Credentials(req)
 	if err != nil {
-		http.Error(rw, "Forbidden", http.StatusForbidden)
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
 
 		return
 	}
 
 	next.ServeHTTP(w, req)
 
+	upstreamBody := w.Body.Bytes()
+
 	var reader io.ReadCloser
 
 	if w.Header().Get("Content-Encoding") == "gzip" {
@@ -91,36 +119,65 @@ func (l *SeriesHandler) Handle(rw http.ResponseWriter, req *http.Request, next h
 		return
 	}
 
-	user, err := l.grafanaRepo.GetUser(grafanaSession.Value)
+	id, err := l.identities.Resolve(req)
 	if err != nil {
-		http.Error(rw, "User doesn't exits", http.StatusBadRequest)
+		l.logger.Ctx(req.Context()).Debugw("unable to resolve the identity of the request", "err", err)
+
+		message, code := identity.Status(err)
+		http.Error(rw, message, code)
 
 		return
 	}
 
-	teams, err := l.grafanaRepo.GetUserTeams(grafanaSession.Value, user.ID)
-	if err != nil {
-		l.logger.Debugf("user doesn't have any team, err: %v", err)
+	event.SetIdentity(id)
 
-		http.Error(rw, "User not assigned to any team", http.StatusBadRequest)
+	// Bypassing requests get the upstream response as is.
+	if l.bypass.Allows(req, id) {
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
 
 		return
 	}
 
-	resp, err := l.service.FilterSeries(&prometheus.FilterSeriesReq{
-		User:       user,
-		Teams:      teams,
+	resp, ok := l.filterSeries(req.Context(), &prometheus.FilterSeriesReq{
+		User:       id.User,
+		Teams:      id.Teams,
+		OrgID:      id.OrgID,
 		Series:     grafanaResp.Series,
 		Datasource: grafana.Datasource{UID: matches[1]}, // Uid exists in first index of the regx
 	})
-	if err != nil {
-		l.logger.Debugf("unable to send prometheus filter series request to Giam, err: %v", err)
+	if !ok {
+		event.SetDecision(audit.DecisionError)
+
+		if l.shadow {
+			rw.WriteHeader(w.Status)
+			rw.Write(upstreamBody)
+
+			return
+		}
 
 		http.Error(rw, "Unable to communicate with Giam service", http.StatusPreconditionFailed)
 
 		return
 	}
 
+	event.SetDecision(audit.DecisionAllow)
+	event.AddQuery(&audit.Query{DatasourceUID: matches[1], DatasourceType: string(datasource.Prometheus)})
+	filtered := len(grafanaResp.Series) - len(resp.Data)
+	event.SetFiltered(filtered)
+
+	if l.shadow {
+		if filtered > 0 {
+			l.logger.Ctx(req.Context()).Infow("shadow mode, forwarding the unfiltered series",
+				"datasource_uid", matches[1], "filtered", filtered)
+		}
+
+		rw.WriteHeader(w.Status)
+		rw.Write(upstreamBody)
+
+		return
+	}
+
 	grafanaResp.Series = resp.Data
 
 	rw.Header().Set("Content-Encoding", "gzip")
@@ -136,3 +193,34 @@ func (l *SeriesHandler) Handle(rw http.ResponseWriter, req *http.Request, next h
 		return
 	}
 }
+
+// filterSeries filters the series with Giam, and applies the failure policy when Giam can't be reached. It returns
+// false when the request must be denied.
+func (l *SeriesHandler) filterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, bool) {
+	resp, err := l.service.FilterSeries(ctx, payload)
+	if err == nil {
+		l.failurePolicy.Remember(string(datasource.Prometheus), failure.EndpointSeries, payload, resp)
+		audit.FromContext(ctx).SetGiamStatusCode(resp.StatusCode)
+
+		return resp, true
+	}
+
+	l.logger.Ctx(ctx).Debugw("unable to send prometheus filter series request to Giam", "err", err)
+
+	decision, ok := l.failurePolicy.Fallback(
+		ctx,
+		string(datasource.Prometheus),
+		failure.EndpointSeries,
+		payload,
+		err,
+		&prometheus.FilterSeriesResp{Data: payload.Series, StatusCode: http.StatusOK},
+	)
+	if !ok {
+		return nil, false
+	}
+
+	return decision.(*prometheus.FilterSeriesResp), true
+}
@@ -0,0 +1,232 @@
+package promql
+
+import (
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+)
+
+// Expr is a node of a parsed PromQL expression. String prints it back as PromQL.
+type Expr interface {
+	String() string
+}
+
+type NumberLiteral struct {
+	// Raw is the number as written in the query, it may be a grafana variable such as $__range_s.
+	Raw string
+}
+
+type StringLiteral struct {
+	// Raw is the quoted string as written in the query.
+	Raw string
+}
+
+// VectorSelector selects series by metric name and label matchers, e.g. http_requests_total{job="api"}.
+type VectorSelector struct {
+	Name     string
+	Matchers []*label.Matcher
+	// Modifiers are the offset and @ modifiers as written in the query, e.g. "offset 5m".
+	Modifiers []string
+}
+
+// MatrixSelector selects a range of samples of a vector selector, e.g. http_requests_total[5m].
+type MatrixSelector struct {
+	VectorSelector *VectorSelector
+	Range          string
+	Modifiers      []string
+}
+
+// SubqueryExpr evaluates an expression over a range, e.g. rate(http_requests_total[5m])[30m:1m].
+type SubqueryExpr struct {
+	Expr      Expr
+	Range     string
+	Step      string
+	Modifiers []string
+}
+
+type ParenExpr struct {
+	Expr Expr
+}
+
+type UnaryExpr struct {
+	Op   string
+	Expr Expr
+}
+
+// VectorMatching holds the on/ignoring and group_left/group_right modifiers of a binary expression.
+type VectorMatching struct {
+	On       bool
+	Labels   []string
+	Group    string
+	Include  []string
+	HasGroup bool
+}
+
+type BinaryExpr struct {
+	Op         string
+	LHS        Expr
+	RHS        Expr
+	ReturnBool bool
+	Matching   *VectorMatching
+}
+
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+// AggregateExpr is an aggregation such as sum by (job) (rate(http_requests_total[5m])).
+type AggregateExpr struct {
+	Op          string
+	Param       Expr
+	Expr        Expr
+	Grouping    []string
+	Without     bool
+	HasGrouping bool
+}
+
+func (n *NumberLiteral) String() string {
+	return n.Raw
+}
+
+func (s *StringLiteral) String() string {
+	return s.Raw
+}
+
+func (v *VectorSelector) String() string {
+	var b strings.Builder
+
+	b.WriteString(v.Name)
+
+	if len(v.Matchers) > 0 || v.Name == "" {
+		matchers := make([]string, len(v.Matchers))
+		for i, m := range v.Matchers {
+			matchers[i] = m.String()
+		}
+
+		b.WriteString("{" + strings.Join(matchers, ", ") + "}")
+	}
+
+	return b.String() + modifiersString(v.Modifiers)
+}
+
+func (m *MatrixSelector) String() string {
+	return m.VectorSelector.String() + "[" + m.Range + "]" + modifiersString(m.Modifiers)
+}
+
+func (s *SubqueryExpr) String() string {
+	return s.Expr.String() + "[" + s.Range + ":" + s.Step + "]" + modifiersString(s.Modifiers)
+}
+
+func (p *ParenExpr) String() string {
+	return "(" + p.Expr.String() + ")"
+}
+
+func (u *UnaryExpr) String() string {
+	return u.Op + u.Expr.String()
+}
+
+func (b *BinaryExpr) String() string {
+	var s strings.Builder
+
+	s.WriteString(b.LHS.String() + " " + b.Op)
+
+	if b.ReturnBool {
+		s.WriteString(" bool")
+	}
+
+	if b.Matching != nil {
+		if b.Matching.On {
+			s.WriteString(" on")
+		} else {
+			s.WriteString(" ignoring")
+		}
+
+		s.WriteString(labelsString(b.Matching.Labels))
+
+		if b.Matching.HasGroup {
+			s.WriteString(" " + b.Matching.Group + labelsString(b.Matching.Include))
+		}
+	}
+
+	s.WriteString(" " + b.RHS.String())
+
+	return s.String()
+}
+
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+
+	return c.Func + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (a *AggregateExpr) String() string {
+	var s strings.Builder
+
+	s.WriteString(a.Op)
+
+	if a.HasGrouping {
+		if a.Without {
+			s.WriteString(" without ")
+		} else {
+			s.WriteString(" by ")
+		}
+
+		s.WriteString(labelsString(a.Grouping) + " ")
+	}
+
+	s.WriteString("(")
+
+	if a.Param != nil {
+		s.WriteString(a.Param.String() + ", ")
+	}
+
+	s.WriteString(a.Expr.String() + ")")
+
+	return s.String()
+}
+
+func labelsString(labels []string) string {
+	return "(" + strings.Join(labels, ", ") + ")"
+}
+
+func modifiersString(modifiers []string) string {
+	if len(modifiers) == 0 {
+		return ""
+	}
+
+	return " " + strings.Join(modifiers, " ")
+}
+
+// Walk calls fn for every node of the expression tree, parents first.
+func Walk(expr Expr, fn func(Expr)) {
+	if expr == nil {
+		return
+	}
+
+	fn(expr)
+
+	switch e := expr.(type) {
+	case *MatrixSelector:
+		Walk(e.VectorSelector, fn)
+	case *SubqueryExpr:
+		Walk(e.Expr, fn)
+	case *ParenExpr:
+		Walk(e.Expr, fn)
+	case *UnaryExpr:
+		Walk(e.Expr, fn)
+	case *BinaryExpr:
+		Walk(e.LHS, fn)
+		Walk(e.RHS, fn)
+	case *Call:
+		for _, arg := range e.Args {
+			Walk(arg, fn)
+		}
+	case *AggregateExpr:
+		Walk(e.Param, fn)
+		Walk(e.Expr, fn)
+	}
+}
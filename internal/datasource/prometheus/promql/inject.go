@@ -0,0 +1,27 @@
+package promql
+
+import (
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+)
+
+// InjectMatchers appends the given matchers to every vector and matrix selector of the query, including the ones
+// nested in subqueries, aggregations, function calls and binary operations. Matchers already present with the very
+// same operator and value are not duplicated.
+func InjectMatchers(query string, matchers []*label.Matcher) (string, error) {
+	expr, err := Parse(query)
+	if err != nil {
+		return "", err
+	}
+
+	if len(matchers) == 0 {
+		return query, nil
+	}
+
+	Walk(expr, func(e Expr) {
+		if selector, ok := e.(*VectorSelector); ok {
+			selector.Matchers = label.AppendMissing(selector.Matchers, matchers)
+		}
+	})
+
+	return expr.String(), nil
+}
@@ -0,0 +1,138 @@
+package promql
+
+import (
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestInjectMatchers(t *testing.T) {
+	team, err := label.NewMatcher(label.MatchEqual, "team", "payment")
+	require.NoError(t, err)
+
+	env, err := label.NewMatcher(label.MatchRegexp, "env", "prod|staging")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		query    string
+		matchers []*label.Matcher
+		expected string
+	}{
+		{
+			name:     "it should add matchers to a bare metric",
+			query:    `up`,
+			matchers: []*label.Matcher{team},
+			expected: `up{team="payment"}`,
+		},
+		{
+			name:     "it should keep the existing matchers",
+			query:    `http_requests_total{job="api", code=~"5.."}`,
+			matchers: []*label.Matcher{team, env},
+			expected: `http_requests_total{job="api", code=~"5..", team="payment", env=~"prod|staging"}`,
+		},
+		{
+			name:     "it should not duplicate a matcher already present",
+			query:    `up{team="payment"}`,
+			matchers: []*label.Matcher{team},
+			expected: `up{team="payment"}`,
+		},
+		{
+			name:     "it should add matchers to a selector without metric name",
+			query:    `{__name__=~"up|scrape_duration_seconds"}`,
+			matchers: []*label.Matcher{team},
+			expected: `{__name__=~"up|scrape_duration_seconds", team="payment"}`,
+		},
+		{
+			name:     "it should add matchers inside aggregations and range vectors",
+			query:    `sum by (job) (rate(http_requests_total{job="api"}[$__rate_interval]))`,
+			matchers: []*label.Matcher{team},
+			expected: `sum by (job) (rate(http_requests_total{job="api", team="payment"}[$__rate_interval]))`,
+		},
+		{
+			name:     "it should add matchers to aggregations with parameters and trailing grouping",
+			query:    `topk(5, sum(rate(x[5m])) without (instance))`,
+			matchers: []*label.Matcher{team},
+			expected: `topk(5, sum without (instance) (rate(x{team="payment"}[5m])))`,
+		},
+		{
+			name:     "it should add matchers inside subqueries",
+			query:    `max_over_time(rate(x[1m])[30m:1m] offset 1h)`,
+			matchers: []*label.Matcher{team},
+			expected: `max_over_time(rate(x{team="payment"}[1m])[30m:1m] offset 1h)`,
+		},
+		{
+			name:     "it should add matchers to both sides of binary operations",
+			query:    `a / on(instance) group_left(node) b > bool 5`,
+			matchers: []*label.Matcher{team},
+			expected: `a{team="payment"} / on(instance) group_left(node) b{team="payment"} > bool 5`,
+		},
+		{
+			name:     "it should add matchers to set operations",
+			query:    `x unless ignoring(a) y or vector(0)`,
+			matchers: []*label.Matcher{team},
+			expected: `x{team="payment"} unless ignoring(a) y{team="payment"} or vector(0)`,
+		},
+		{
+			name:     "it should keep offset and @ modifiers",
+			query:    `x offset -5m @ start()`,
+			matchers: []*label.Matcher{team},
+			expected: `x{team="payment"} offset -5m @ start()`,
+		},
+		{
+			name:     "it should keep function string arguments",
+			query:    `label_replace(up, "dst", "$1", "src", "(.*)")`,
+			matchers: []*label.Matcher{team},
+			expected: `label_replace(up{team="payment"}, "dst", "$1", "src", "(.*)")`,
+		},
+		{
+			name:     "it should normalize quoted label values",
+			query:    `x{a='b\'c'}`,
+			matchers: []*label.Matcher{team},
+			expected: `x{a="b'c", team="payment"}`,
+		},
+		{
+			name:     "it should leave scalar expressions untouched",
+			query:    `-1 + 2 * 3 ^ 2`,
+			matchers: []*label.Matcher{team},
+			expected: `-1 + 2 * 3 ^ 2`,
+		},
+		{
+			name:     "it should return the query untouched without matchers",
+			query:    `sum(rate(x[5m]))`,
+			expected: `sum(rate(x[5m]))`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := InjectMatchers(tt.query, tt.matchers)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestInjectMatchers_InvalidQuery(t *testing.T) {
+	queries := []string{
+		`foo{`,
+		`sum(`,
+		`rate((x)[5m])`,
+		`$metric`,
+		`x{a="b"} offset`,
+		`up{a=1}`,
+		`{}`,
+	}
+
+	team, err := label.NewMatcher(label.MatchEqual, "team", "payment")
+	require.NoError(t, err)
+
+	for _, query := range queries {
+		_, err := InjectMatchers(query, []*label.Matcher{team})
+
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,287 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenDuration
+	// tokenVariable is a grafana variable such as $__rate_interval which grafana interpolates after the plugin.
+	tokenVariable
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenLeftBracket
+	tokenRightBracket
+	tokenComma
+	tokenColon
+	tokenAt
+	tokenOperator
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func (t token) String() string {
+	if t.typ == tokenEOF {
+		return "end of input"
+	}
+
+	return fmt.Sprintf("%q", t.val)
+}
+
+// operators are sorted so the longest operators are tried first.
+var operators = []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", "^", ">", "<", "="}
+
+var durationUnits = "smhdwy"
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func lex(input string) ([]token, error) {
+	l := &lexer{input: input}
+
+	var tokens []token
+
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, tok)
+
+		if tok.typ == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpacesAndComments()
+
+	if l.pos >= len(l.input) {
+		return token{typ: tokenEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.input[l.pos]
+
+	switch {
+	case c == '(':
+		return l.single(tokenLeftParen), nil
+	case c == ')':
+		return l.single(tokenRightParen), nil
+	case c == '{':
+		return l.single(tokenLeftBrace), nil
+	case c == '}':
+		return l.single(tokenRightBrace), nil
+	case c == '[':
+		return l.single(tokenLeftBracket), nil
+	case c == ']':
+		return l.single(tokenRightBracket), nil
+	case c == ',':
+		return l.single(tokenComma), nil
+	case c == ':':
+		return l.single(tokenColon), nil
+	case c == '@':
+		return l.single(tokenAt), nil
+	case c == '"' || c == '\'' || c == '`':
+		return l.lexString(c)
+	case c == '$':
+		return l.lexVariable()
+	case isDigit(c) || (c == '.' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1])):
+		return l.lexNumber(), nil
+	case isIdentifierStart(c):
+		for l.pos < len(l.input) && isIdentifierChar(l.input[l.pos]) {
+			l.pos++
+		}
+
+		return token{typ: tokenIdentifier, val: l.input[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+
+			return token{typ: tokenOperator, val: op, pos: start}, nil
+		}
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos:])
+
+	return token{}, fmt.Errorf("unexpected character %q at position %d", r, l.pos)
+}
+
+func (l *lexer) single(typ tokenType) token {
+	l.pos++
+
+	return token{typ: typ, val: l.input[l.pos-1 : l.pos], pos: l.pos - 1}
+}
+
+func (l *lexer) skipSpacesAndComments() {
+	for l.pos < len(l.input) {
+		r, size := utf8.DecodeRuneInString(l.input[l.pos:])
+
+		switch {
+		case unicode.IsSpace(r):
+			l.pos += size
+		case r == '#':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) lexString(quote byte) (token, error) {
+	start := l.pos
+	l.pos++
+
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+
+		switch {
+		case c == '\\' && quote != '`':
+			l.pos += 2
+		case c == quote:
+			l.pos++
+
+			return token{typ: tokenString, val: l.input[start:l.pos], pos: start}, nil
+		case c == '\n' && quote != '`':
+			return token{}, fmt.Errorf("unterminated string at position %d", start)
+		default:
+			l.pos++
+		}
+	}
+
+	return token{}, fmt.Errorf("unterminated string at position %d", start)
+}
+
+// lexVariable lexes the global variables grafana interpolates on the server side, such as $__interval or
+// ${__range_s}. Other variables are interpolated by the browser and must never reach the plugin.
+func (l *lexer) lexVariable() (token, error) {
+	start := l.pos
+	l.pos++
+
+	braced := l.pos < len(l.input) && l.input[l.pos] == '{'
+	if braced {
+		l.pos++
+	}
+
+	nameStart := l.pos
+
+	for l.pos < len(l.input) && isIdentifierChar(l.input[l.pos]) && l.input[l.pos] != ':' {
+		l.pos++
+	}
+
+	if !strings.HasPrefix(l.input[nameStart:l.pos], "__") || l.pos == nameStart+2 {
+		return token{}, fmt.Errorf("unsupported variable at position %d", start)
+	}
+
+	if braced {
+		if l.pos >= len(l.input) || l.input[l.pos] != '}' {
+			return token{}, fmt.Errorf("unterminated variable at position %d", start)
+		}
+
+		l.pos++
+	}
+
+	return token{typ: tokenVariable, val: l.input[start:l.pos], pos: start}, nil
+}
+
+// lexNumber lexes numbers such as 1, 1.5, 1e-3 or 0x1f and durations such as 5m or 1h30m.
+func (l *lexer) lexNumber() token {
+	start := l.pos
+
+	if strings.HasPrefix(l.input[l.pos:], "0x") || strings.HasPrefix(l.input[l.pos:], "0X") {
+		l.pos += 2
+		for l.pos < len(l.input) && isHexDigit(l.input[l.pos]) {
+			l.pos++
+		}
+
+		return token{typ: tokenNumber, val: l.input[start:l.pos], pos: start}
+	}
+
+	l.digits()
+
+	if l.pos < len(l.input) && strings.IndexByte(durationUnits, l.input[l.pos]) >= 0 {
+		return l.lexDuration(start)
+	}
+
+	if l.pos < len(l.input) && l.input[l.pos] == '.' {
+		l.pos++
+		l.digits()
+	}
+
+	if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.input) && (l.input[l.pos] == '+' || l.input[l.pos] == '-') {
+			l.pos++
+		}
+
+		l.digits()
+	}
+
+	return token{typ: tokenNumber, val: l.input[start:l.pos], pos: start}
+}
+
+func (l *lexer) lexDuration(start int) token {
+	for l.pos < len(l.input) {
+		switch {
+		case strings.HasPrefix(l.input[l.pos:], "ms"):
+			l.pos += 2
+		case strings.IndexByte(durationUnits, l.input[l.pos]) >= 0:
+			l.pos++
+		default:
+			return token{typ: tokenDuration, val: l.input[start:l.pos], pos: start}
+		}
+
+		if l.pos >= len(l.input) || !isDigit(l.input[l.pos]) {
+			break
+		}
+
+		l.digits()
+	}
+
+	return token{typ: tokenDuration, val: l.input[start:l.pos], pos: start}
+}
+
+func (l *lexer) digits() {
+	for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+		l.pos++
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || isDigit(c)
+}
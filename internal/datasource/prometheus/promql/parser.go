@@ -0,0 +1,618 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+)
+
+const (
+	precedenceOr = iota + 1
+	precedenceAndUnless
+	precedenceComparison
+	precedenceAdditive
+	precedenceMultiplicative
+	precedencePower
+)
+
+var binaryPrecedence = map[string]int{
+	"or":     precedenceOr,
+	"and":    precedenceAndUnless,
+	"unless": precedenceAndUnless,
+	"==":     precedenceComparison,
+	"!=":     precedenceComparison,
+	">":      precedenceComparison,
+	"<":      precedenceComparison,
+	">=":     precedenceComparison,
+	"<=":     precedenceComparison,
+	"+":      precedenceAdditive,
+	"-":      precedenceAdditive,
+	"*":      precedenceMultiplicative,
+	"/":      precedenceMultiplicative,
+	"%":      precedenceMultiplicative,
+	"atan2":  precedenceMultiplicative,
+	"^":      precedencePower,
+}
+
+var comparisonOperators = map[string]bool{"==": true, "!=": true, ">": true, "<": true, ">=": true, "<=": true}
+
+var setOperators = map[string]bool{"and": true, "or": true, "unless": true}
+
+// aggregators maps the aggregation operators to whether they take a parameter.
+var aggregators = map[string]bool{
+	"sum":          false,
+	"avg":          false,
+	"count":        false,
+	"min":          false,
+	"max":          false,
+	"group":        false,
+	"stddev":       false,
+	"stdvar":       false,
+	"topk":         true,
+	"bottomk":      true,
+	"count_values": true,
+	"quantile":     true,
+	"limitk":       true,
+	"limit_ratio":  true,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a PromQL expression. Grafana variables interpolated by grafana itself, such as $__rate_interval, are
+// accepted wherever a duration or a number is expected.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.typ != tokenEOF {
+		return nil, p.unexpected(tok)
+	}
+
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekN(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tokenEOF {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *parser) expect(typ tokenType, what string) (token, error) {
+	tok := p.next()
+	if tok.typ != typ {
+		return tok, fmt.Errorf("expected %s but got %s at position %d", what, tok, tok.pos)
+	}
+
+	return tok, nil
+}
+
+func (p *parser) unexpected(tok token) error {
+	return fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+// binaryOperator returns the binary operator of the next token if any.
+func (p *parser) binaryOperator() (string, bool) {
+	tok := p.peek()
+
+	switch tok.typ {
+	case tokenOperator:
+		if _, ok := binaryPrecedence[tok.val]; ok {
+			return tok.val, true
+		}
+	case tokenIdentifier:
+		op := strings.ToLower(tok.val)
+		if _, ok := binaryPrecedence[op]; ok && (setOperators[op] || op == "atan2") {
+			return op, true
+		}
+	}
+
+	return "", false
+}
+
+func (p *parser) parseExpr(minPrecedence int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.binaryOperator()
+		if !ok || binaryPrecedence[op] < minPrecedence {
+			return lhs, nil
+		}
+
+		p.next()
+
+		binary := &BinaryExpr{Op: op, LHS: lhs}
+
+		if err := p.parseBinaryModifiers(binary); err != nil {
+			return nil, err
+		}
+
+		nextPrecedence := binaryPrecedence[op] + 1
+		if op == "^" {
+			// The power operator is right associative.
+			nextPrecedence = binaryPrecedence[op]
+		}
+
+		binary.RHS, err = p.parseExpr(nextPrecedence)
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = binary
+	}
+}
+
+func (p *parser) parseBinaryModifiers(binary *BinaryExpr) error {
+	if comparisonOperators[binary.Op] && p.isKeyword("bool") {
+		p.next()
+
+		binary.ReturnBool = true
+	}
+
+	if !p.isKeyword("on") && !p.isKeyword("ignoring") {
+		return nil
+	}
+
+	matching := &VectorMatching{On: strings.ToLower(p.next().val) == "on"}
+
+	labels, err := p.parseLabelList()
+	if err != nil {
+		return err
+	}
+
+	matching.Labels = labels
+
+	if p.isKeyword("group_left") || p.isKeyword("group_right") {
+		matching.HasGroup = true
+		matching.Group = strings.ToLower(p.next().val)
+
+		if p.peek().typ == tokenLeftParen {
+			if matching.Include, err = p.parseLabelList(); err != nil {
+				return err
+			}
+		}
+	}
+
+	binary.Matching = matching
+
+	return nil
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	tok := p.peek()
+
+	return tok.typ == tokenIdentifier && strings.EqualFold(tok.val, keyword)
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if tok := p.peek(); tok.typ == tokenOperator && (tok.val == "-" || tok.val == "+") {
+		p.next()
+
+		// Unary operators bind tighter than every binary operator but the power one.
+		expr, err := p.parseExpr(precedencePower)
+		if err != nil {
+			return nil, err
+		}
+
+		return &UnaryExpr{Op: tok.val, Expr: expr}, nil
+	}
+
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parsePostfix(expr)
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+
+	switch tok.typ {
+	case tokenNumber, tokenVariable:
+		p.next()
+
+		return &NumberLiteral{Raw: tok.val}, nil
+	case tokenString:
+		p.next()
+
+		if _, err := label.Unquote(tok.val); err != nil {
+			return nil, fmt.Errorf("invalid string at position %d: %w", tok.pos, err)
+		}
+
+		return &StringLiteral{Raw: tok.val}, nil
+	case tokenLeftParen:
+		p.next()
+
+		expr, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokenRightParen, `")"`); err != nil {
+			return nil, err
+		}
+
+		return &ParenExpr{Expr: expr}, nil
+	case tokenLeftBrace:
+		return p.parseVectorSelector("")
+	case tokenIdentifier:
+		return p.parseIdentifier()
+	default:
+		return nil, p.unexpected(tok)
+	}
+}
+
+func (p *parser) parseIdentifier() (Expr, error) {
+	tok := p.next()
+	lower := strings.ToLower(tok.val)
+
+	if lower == "inf" || lower == "nan" {
+		return &NumberLiteral{Raw: tok.val}, nil
+	}
+
+	if _, ok := aggregators[lower]; ok {
+		next := p.peek()
+		if next.typ == tokenLeftParen || p.isKeyword("by") || p.isKeyword("without") {
+			return p.parseAggregate(lower)
+		}
+	}
+
+	if p.peek().typ == tokenLeftParen {
+		return p.parseCall(tok.val)
+	}
+
+	if _, ok := binaryPrecedence[lower]; ok || lower == "bool" || lower == "offset" {
+		return nil, p.unexpected(tok)
+	}
+
+	return p.parseVectorSelector(tok.val)
+}
+
+func (p *parser) parseCall(name string) (Expr, error) {
+	p.next()
+
+	call := &Call{Func: name}
+
+	for p.peek().typ != tokenRightParen {
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+
+		call.Args = append(call.Args, arg)
+
+		if p.peek().typ != tokenComma {
+			break
+		}
+
+		p.next()
+	}
+
+	if _, err := p.expect(tokenRightParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	return call, nil
+}
+
+func (p *parser) parseAggregate(op string) (Expr, error) {
+	agg := &AggregateExpr{Op: op}
+
+	if err := p.parseGrouping(agg); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect(tokenLeftParen, `"("`); err != nil {
+		return nil, err
+	}
+
+	if aggregators[op] {
+		param, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokenComma, `","`); err != nil {
+			return nil, err
+		}
+
+		agg.Param = param
+	}
+
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	agg.Expr = expr
+
+	if p.peek().typ == tokenComma {
+		p.next()
+	}
+
+	if _, err := p.expect(tokenRightParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	if !agg.HasGrouping {
+		if err := p.parseGrouping(agg); err != nil {
+			return nil, err
+		}
+	}
+
+	return agg, nil
+}
+
+func (p *parser) parseGrouping(agg *AggregateExpr) error {
+	if !p.isKeyword("by") && !p.isKeyword("without") {
+		return nil
+	}
+
+	agg.HasGrouping = true
+	agg.Without = strings.EqualFold(p.next().val, "without")
+
+	labels, err := p.parseLabelList()
+	if err != nil {
+		return err
+	}
+
+	agg.Grouping = labels
+
+	return nil
+}
+
+func (p *parser) parseLabelList() ([]string, error) {
+	if _, err := p.expect(tokenLeftParen, `"("`); err != nil {
+		return nil, err
+	}
+
+	labels := []string{}
+
+	for p.peek().typ != tokenRightParen {
+		tok, err := p.expect(tokenIdentifier, "label name")
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(tok.val, ":") {
+			return nil, fmt.Errorf("invalid label name %q at position %d", tok.val, tok.pos)
+		}
+
+		labels = append(labels, tok.val)
+
+		if p.peek().typ != tokenComma {
+			break
+		}
+
+		p.next()
+	}
+
+	if _, err := p.expect(tokenRightParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+func (p *parser) parseVectorSelector(name string) (Expr, error) {
+	selector := &VectorSelector{Name: name}
+
+	if p.peek().typ != tokenLeftBrace {
+		return selector, nil
+	}
+
+	p.next()
+
+	for p.peek().typ != tokenRightBrace {
+		matcher, err := p.parseMatcher()
+		if err != nil {
+			return nil, err
+		}
+
+		selector.Matchers = append(selector.Matchers, matcher)
+
+		if p.peek().typ != tokenComma {
+			break
+		}
+
+		p.next()
+	}
+
+	if _, err := p.expect(tokenRightBrace, `"}"`); err != nil {
+		return nil, err
+	}
+
+	if name == "" && len(selector.Matchers) == 0 {
+		return nil, fmt.Errorf("vector selector must contain at least one matcher")
+	}
+
+	return selector, nil
+}
+
+func (p *parser) parseMatcher() (*label.Matcher, error) {
+	name, err := p.expect(tokenIdentifier, "label name")
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	if op.typ != tokenOperator {
+		return nil, p.unexpected(op)
+	}
+
+	matchType := label.MatchType(op.val)
+	if matchType != label.MatchEqual && matchType != label.MatchNotEqual &&
+		matchType != label.MatchRegexp && matchType != label.MatchNotRegexp {
+		return nil, p.unexpected(op)
+	}
+
+	valueTok, err := p.expect(tokenString, "label value")
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := label.Unquote(valueTok.val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label value at position %d: %w", valueTok.pos, err)
+	}
+
+	return label.NewMatcher(matchType, name.val, value)
+}
+
+// parsePostfix parses the range, subquery, offset and @ modifiers following an expression.
+func (p *parser) parsePostfix(expr Expr) (Expr, error) {
+	for {
+		tok := p.peek()
+
+		switch {
+		case tok.typ == tokenLeftBracket:
+			var err error
+			if expr, err = p.parseRange(expr); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("offset"):
+			p.next()
+
+			modifier, err := p.parseOffset()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := addModifier(expr, "offset "+modifier); err != nil {
+				return nil, err
+			}
+		case tok.typ == tokenAt:
+			p.next()
+
+			modifier, err := p.parseAt()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := addModifier(expr, "@ "+modifier); err != nil {
+				return nil, err
+			}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (p *parser) parseRange(expr Expr) (Expr, error) {
+	p.next()
+
+	rangeTok := p.next()
+	if rangeTok.typ != tokenDuration && rangeTok.typ != tokenVariable && rangeTok.typ != tokenNumber {
+		return nil, p.unexpected(rangeTok)
+	}
+
+	if p.peek().typ == tokenColon {
+		p.next()
+
+		subquery := &SubqueryExpr{Expr: expr, Range: rangeTok.val}
+
+		if step := p.peek(); step.typ == tokenDuration || step.typ == tokenVariable || step.typ == tokenNumber {
+			subquery.Step = p.next().val
+		}
+
+		if _, err := p.expect(tokenRightBracket, `"]"`); err != nil {
+			return nil, err
+		}
+
+		return subquery, nil
+	}
+
+	if _, err := p.expect(tokenRightBracket, `"]"`); err != nil {
+		return nil, err
+	}
+
+	selector, ok := expr.(*VectorSelector)
+	if !ok {
+		return nil, fmt.Errorf("ranges are only allowed for vector selectors, at position %d", rangeTok.pos)
+	}
+
+	return &MatrixSelector{VectorSelector: selector, Range: rangeTok.val}, nil
+}
+
+func (p *parser) parseOffset() (string, error) {
+	sign := ""
+	if tok := p.peek(); tok.typ == tokenOperator && (tok.val == "-" || tok.val == "+") {
+		sign = p.next().val
+	}
+
+	tok := p.next()
+	if tok.typ != tokenDuration && tok.typ != tokenVariable && tok.typ != tokenNumber {
+		return "", p.unexpected(tok)
+	}
+
+	return sign + tok.val, nil
+}
+
+func (p *parser) parseAt() (string, error) {
+	if (p.isKeyword("start") || p.isKeyword("end")) && p.peekN(1).typ == tokenLeftParen &&
+		p.peekN(2).typ == tokenRightParen {
+		name := p.next().val
+		p.next()
+		p.next()
+
+		return name + "()", nil
+	}
+
+	sign := ""
+	if tok := p.peek(); tok.typ == tokenOperator && (tok.val == "-" || tok.val == "+") {
+		sign = p.next().val
+	}
+
+	tok := p.next()
+	if tok.typ != tokenNumber && tok.typ != tokenVariable {
+		return "", p.unexpected(tok)
+	}
+
+	return sign + tok.val, nil
+}
+
+func addModifier(expr Expr, modifier string) error {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		e.Modifiers = append(e.Modifiers, modifier)
+	case *MatrixSelector:
+		e.Modifiers = append(e.Modifiers, modifier)
+	case *SubqueryExpr:
+		e.Modifiers = append(e.Modifiers, modifier)
+	default:
+		return fmt.Errorf("%s modifier must be preceded by a selector or a subquery", strings.Fields(modifier)[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// repo queries prometheus through the datasource proxy of grafana, with the credentials of the request, so grafana
+// keeps checking the access of the user to the datasource. The proxy route isn't intercepted by the plugin handlers.
+type repo struct {
+	grafanaUrl string
+	logger     *log.Logger
+}
+
+func NewRepo(grafanaUrl string, logger *log.Logger) prometheus.Repo {
+	return &repo{grafanaUrl: grafanaUrl, logger: logger}
+}
+
+// GetMetrics returns the metrics with at least one series satisfying every matcher, every metric without matchers.
+func (r *repo) GetMetrics(
+	ctx context.Context,
+	creds *grafana.Credentials,
+	uid string,
+	matchers []*label.Matcher,
+) ([]*prometheus.Metric, error) {
+	endpoint := r.grafanaUrl + "/api/datasources/proxy/uid/" + url.PathEscape(uid) + "/api/v1/label/__name__/values"
+
+	if len(matchers) > 0 {
+		endpoint += "?" + url.Values{"match[]": []string{selector(matchers)}}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.Apply(req)
+
+	client := &http.Client{}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send metric names request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	r.logger.Ctx(ctx).Debugw("prometheus get metric names resp", "datasource_uid", uid, "status", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("prometheus metric names request failed with status %d", resp.StatusCode)
+	}
+
+	var values grafana.LabelValuesReq
+
+	err = json.NewDecoder(resp.Body).Decode(&values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the metric names with payload: %w", err)
+	}
+
+	metrics := make([]*prometheus.Metric, len(values.Data))
+	for i, name := range values.Data {
+		metrics[i] = &prometheus.Metric{Name: name}
+	}
+
+	return metrics, nil
+}
+
+// selector formats the matchers as a series selector, e.g. {team=~"payment|menu",env="production"}.
+func selector(matchers []*label.Matcher) string {
+	parts := make([]string, len(matchers))
+	for i, m := range matchers {
+		parts[i] = m.String()
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
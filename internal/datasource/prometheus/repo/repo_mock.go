@@ -0,0 +1,27 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
+
+type Mock struct {
+	Metrics []*prometheus.Metric
+	Err     error
+	// Matchers are the matchers of the last probe.
+	Matchers []*label.Matcher
+}
+
+func (m *Mock) GetMetrics(
+	ctx context.Context,
+	creds *grafana.Credentials,
+	uid string,
+	matchers []*label.Matcher,
+) ([]*prometheus.Metric, error) {
+	m.Matchers = matchers
+
+	return m.Metrics, m.Err
+}
@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestRepo_GetMetrics(t *testing.T) {
+	var gotPath, gotMatch, gotSession string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotPath = req.URL.Path
+		gotMatch = req.URL.Query().Get("match[]")
+
+		if cookie, err := req.Cookie("grafana_session"); err == nil {
+			gotSession = cookie.Value
+		}
+
+		rw.Write([]byte(`{"status": "success", "data": ["payment_orders_total", "up"]}`))
+	}))
+	defer server.Close()
+
+	team, err := label.NewMatcher(label.MatchRegexp, "team", "payment|menu")
+	require.NoError(t, err)
+
+	env, err := label.NewMatcher(label.MatchEqual, "env", "production")
+	require.NoError(t, err)
+
+	r := NewRepo(server.URL, log.New("FATAL"))
+
+	creds := &grafana.Credentials{Session: "mocked_session_value"}
+
+	metrics, err := r.GetMetrics(context.Background(), creds, "P0dfd3df3dfd", []*label.Matcher{team, env})
+
+	require.NoError(t, err)
+	assert.Equal(t, "/api/datasources/proxy/uid/P0dfd3df3dfd/api/v1/label/__name__/values", gotPath)
+	assert.Equal(t, `{team=~"payment|menu",env="production"}`, gotMatch)
+	assert.Equal(t, "mocked_session_value", gotSession)
+	assert.Equal(t, 2, len(metrics))
+	assert.Equal(t, "payment_orders_total", metrics[0].Name)
+}
+
+func TestRepo_GetMetricsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, `{"status": "error", "error": "bad_data"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	r := NewRepo(server.URL, log.New("FATAL"))
+
+	_, err := r.GetMetrics(context.Background(), &grafana.Credentials{}, "P0dfd3df3dfd", nil)
+
+	assert.Error(t, err)
+}
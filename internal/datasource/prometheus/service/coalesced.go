@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/pkg/singleflight"
+)
+
+// coalescedService shares a single call to Giam between the concurrent identical calls, such as the ones of the
+// panels of a dashboard being loaded.
+type coalescedService struct {
+	service prometheus.Service
+	calls   singleflight.Group
+}
+
+func NewCoalesced(service prometheus.Service) prometheus.Service {
+	return &coalescedService{service: service}
+}
+
+func (s *coalescedService) AuthorizeQuery(
+	ctx context.Context,
+	payload *prometheus.AuthorizeQueryReq,
+) (*prometheus.AuthorizedQueryResp, error) {
+	resp, err := s.do(ctx, "authorize_query", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.AuthorizeQuery(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*prometheus.AuthorizedQueryResp), nil
+}
+
+func (s *coalescedService) FilterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, error) {
+	resp, err := s.do(ctx, "filter_series", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.FilterSeries(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*prometheus.FilterSeriesResp), nil
+}
+
+func (s *coalescedService) FilterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, error) {
+	resp, err := s.do(ctx, "filter_label_values", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.FilterLabelValues(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*prometheus.FilterLabelValuesResp), nil
+}
+
+func (s *coalescedService) FilterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, error) {
+	resp, err := s.do(ctx, "filter_label_names", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.FilterLabelNames(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*prometheus.FilterLabelNamesResp), nil
+}
+
+func (s *coalescedService) GetPolicy(
+	ctx context.Context,
+	payload *prometheus.GetPolicyReq,
+) (*prometheus.GetPolicyResp, error) {
+	resp, err := s.do(ctx, "get_policy", payload, func(ctx context.Context) (interface{}, error) {
+		return s.service.GetPolicy(ctx, payload)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.(*prometheus.GetPolicyResp), nil
+}
+
+// do coalesces the calls of the method whose payloads are identical.
+func (s *coalescedService) do(
+	ctx context.Context,
+	method string,
+	payload interface{},
+	fn func(ctx context.Context) (interface{}, error),
+) (interface{}, error) {
+	key, err := json.Marshal(payload)
+	if err != nil {
+		return fn(ctx)
+	}
+
+	return s.calls.DoContext(ctx, method+":"+string(key), fn)
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/promql"
+	"github.com/usegiam/giam-traefik-plugin/pkg/cache"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// localPolicyMaxEntries bounds the number of cached policies.
+const localPolicyMaxEntries = 1000
+
+// localService enforces the policies fetched from Giam in the plugin instead of sending every query to Giam.
+type localService struct {
+	service  prometheus.Service
+	policies *cache.Cache // nil disables the policy cache
+	logger   *log.Logger
+}
+
+type LocalDeps struct {
+	// Service is used to fetch the policies.
+	Service prometheus.Service
+	// PolicyTTL is how long a fetched policy is kept.
+	PolicyTTL time.Duration
+	Logger    *log.Logger
+}
+
+func NewLocal(deps *LocalDeps) prometheus.Service {
+	return &localService{
+		service:  deps.Service,
+		policies: cache.New(localPolicyMaxEntries, deps.PolicyTTL),
+		logger:   deps.Logger,
+	}
+}
+
+func (s *localService) AuthorizeQuery(
+	ctx context.Context,
+	payload *prometheus.AuthorizeQueryReq,
+) (*prometheus.AuthorizedQueryResp, error) {
+	queries := make([]interface{}, len(payload.Queries))
+
+	for i, query := range payload.Queries {
+		policy, err := s.GetPolicy(ctx, &prometheus.GetPolicyReq{
+			User:       payload.User,
+			Teams:      payload.Teams,
+			OrgID:      payload.OrgID,
+			Datasource: grafana.Datasource{UID: datasource.QueryDatasourceUID(query)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if policy.StatusCode != http.StatusOK {
+			return &prometheus.AuthorizedQueryResp{Message: policy.Message, StatusCode: policy.StatusCode}, nil
+		}
+
+		rewritten, err := datasource.RewriteQueryExpr(query, func(expr string) (string, error) {
+			return promql.InjectMatchers(expr, policy.Matchers)
+		})
+		if err != nil {
+			return &prometheus.AuthorizedQueryResp{
+				Message:    fmt.Sprintf("invalid PromQL query: %v", err),
+				StatusCode: http.StatusBadRequest,
+			}, nil
+		}
+
+		queries[i] = rewritten
+	}
+
+	s.logger.Ctx(ctx).Debugw("locally rewritten prometheus queries", "queries", queries)
+
+	return &prometheus.AuthorizedQueryResp{Queries: queries, StatusCode: http.StatusOK}, nil
+}
+
+func (s *localService) FilterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, error) {
+	policy, err := s.GetPolicy(ctx, &prometheus.GetPolicyReq{
+		User:       payload.User,
+		Teams:      payload.Teams,
+		OrgID:      payload.OrgID,
+		Datasource: payload.Datasource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.StatusCode != http.StatusOK {
+		return &prometheus.FilterSeriesResp{StatusCode: policy.StatusCode}, nil
+	}
+
+	series := make([]map[string]string, 0, len(payload.Series))
+
+	for _, labels := range payload.Series {
+		if label.MatchesLabels(policy.Matchers, labels) {
+			series = append(series, labels)
+		}
+	}
+
+	return &prometheus.FilterSeriesResp{Data: series, StatusCode: http.StatusOK}, nil
+}
+
+// FilterLabelValues filters the values of the labels constrained by the policy locally. The series a value of any
+// other label belongs to are unknown to the plugin, so those values are still filtered by Giam.
+func (s *localService) FilterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, error) {
+	policy, err := s.GetPolicy(ctx, &prometheus.GetPolicyReq{
+		User:       payload.User,
+		Teams:      payload.Teams,
+		OrgID:      payload.OrgID,
+		Datasource: payload.Datasource,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.StatusCode != http.StatusOK {
+		return &prometheus.FilterLabelValuesResp{StatusCode: policy.StatusCode}, nil
+	}
+
+	var matchers []*label.Matcher
+
+	for _, m := range policy.Matchers {
+		if m.Name == payload.Label.Name {
+			matchers = append(matchers, m)
+		}
+	}
+
+	if len(matchers) == 0 {
+		return s.service.FilterLabelValues(ctx, payload)
+	}
+
+	values := make([]string, 0, len(payload.Label.Values))
+
+	for _, value := range payload.Label.Values {
+		if label.MatchesLabels(matchers, map[string]string{payload.Label.Name: value}) {
+			values = append(values, value)
+		}
+	}
+
+	return &prometheus.FilterLabelValuesResp{Data: values, StatusCode: http.StatusOK}, nil
+}
+
+// FilterLabelNames is left to Giam, the plugin doesn't know which labels the series of the user carry.
+func (s *localService) FilterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, error) {
+	return s.service.FilterLabelNames(ctx, payload)
+}
+
+// GetPolicy returns the policy of the user from the cache, or fetches it from Giam.
+func (s *localService) GetPolicy(
+	ctx context.Context,
+	payload *prometheus.GetPolicyReq,
+) (*prometheus.GetPolicyResp, error) {
+	if s.policies == nil {
+		return s.service.GetPolicy(ctx, payload)
+	}
+
+	key, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy, ok := s.policies.Get(string(key)); ok {
+		return policy.(*prometheus.GetPolicyResp), nil
+	}
+
+	policy, err := s.service.GetPolicy(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.StatusCode == http.StatusOK {
+		s.policies.Set(string(key), policy)
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func newLocal(policy *prometheus.GetPolicyResp) prometheus.Service {
+	return NewLocal(&LocalDeps{
+		Service:   &Mock{GetPolicyResp: policy},
+		PolicyTTL: time.Minute,
+		Logger:    log.New("FATAL"),
+	})
+}
+
+func TestLocalService_AuthorizeQuery(t *testing.T) {
+	team, err := label.NewMatcher(label.MatchEqual, "team", "payment")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		policy       *prometheus.GetPolicyResp
+		queries      []interface{}
+		expectedResp *prometheus.AuthorizedQueryResp
+	}{
+		{
+			name:   "it should inject the policy matchers into every query",
+			policy: &prometheus.GetPolicyResp{Matchers: []*label.Matcher{team}, StatusCode: http.StatusOK},
+			queries: []interface{}{
+				map[string]interface{}{
+					"refId": "A",
+					"expr":  `sum(rate(http_requests_total[5m]))`,
+					"datasource": map[string]interface{}{
+						"uid": "dummyUID1",
+					},
+				},
+				map[string]interface{}{
+					"refId": "B",
+					"expr":  `up / on(instance) node_boot_time_seconds`,
+					"datasource": map[string]interface{}{
+						"uid": "dummyUID1",
+					},
+				},
+			},
+			expectedResp: &prometheus.AuthorizedQueryResp{
+				Queries: []interface{}{
+					map[string]interface{}{
+						"refId": "A",
+						"expr":  `sum(rate(http_requests_total{team="payment"}[5m]))`,
+						"datasource": map[string]interface{}{
+							"uid": "dummyUID1",
+						},
+					},
+					map[string]interface{}{
+						"refId": "B",
+						"expr":  `up{team="payment"} / on(instance) node_boot_time_seconds{team="payment"}`,
+						"datasource": map[string]interface{}{
+							"uid": "dummyUID1",
+						},
+					},
+				},
+				StatusCode: http.StatusOK,
+			},
+		},
+		{
+			name:   "it should return the policy rejection",
+			policy: &prometheus.GetPolicyResp{Message: "No Team Assigned", StatusCode: http.StatusPreconditionFailed},
+			queries: []interface{}{
+				map[string]interface{}{"expr": `up`},
+			},
+			expectedResp: &prometheus.AuthorizedQueryResp{
+				Message:    "No Team Assigned",
+				StatusCode: http.StatusPreconditionFailed,
+			},
+		},
+		{
+			name:   "it should reject queries it cannot parse",
+			policy: &prometheus.GetPolicyResp{Matchers: []*label.Matcher{team}, StatusCode: http.StatusOK},
+			queries: []interface{}{
+				map[string]interface{}{"expr": `sum(up`},
+			},
+			expectedResp: &prometheus.AuthorizedQueryResp{
+				Message:    `invalid PromQL query: expected ")" but got end of input at position 6`,
+				StatusCode: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newLocal(tt.policy).AuthorizeQuery(context.Background(), &prometheus.AuthorizeQueryReq{
+				User:    &grafana.User{ID: 1, Name: "user1"},
+				Teams:   []*grafana.Team{{ID: 1, Name: "team1"}},
+				Queries: tt.queries,
+			})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedResp.StatusCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedResp.Message, resp.Message)
+			assert.CompareJson(t, tt.expectedResp.Queries, resp.Queries)
+		})
+	}
+}
+
+func TestLocalService_FilterSeries(t *testing.T) {
+	team, err := label.NewMatcher(label.MatchRegexp, "team", "payment|menu")
+	require.NoError(t, err)
+
+	svc := newLocal(&prometheus.GetPolicyResp{Matchers: []*label.Matcher{team}, StatusCode: http.StatusOK})
+
+	resp, err := svc.FilterSeries(context.Background(), &prometheus.FilterSeriesReq{
+		User:  &grafana.User{ID: 1, Name: "user1"},
+		Teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+		Series: []map[string]string{
+			{"__name__": "up", "team": "payment"},
+			{"__name__": "up", "team": "search"},
+			{"__name__": "up"},
+			{"__name__": "up", "team": "menu"},
+		},
+		Datasource: grafana.Datasource{UID: "dummyUID1"},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"__name__": "up", "team": "payment"},
+		{"__name__": "up", "team": "menu"},
+	}, resp.Data)
+}
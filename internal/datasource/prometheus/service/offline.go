@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/internal/policy"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// offlineService enforces the last policy snapshot synced from Giam in the plugin when the Giam API is unreachable.
+type offlineService struct {
+	service prometheus.Service
+	local   prometheus.Service
+	logger  *log.Logger
+}
+
+type OfflineDeps struct {
+	Service  prometheus.Service
+	Policies *policy.Store
+	Logger   *log.Logger
+}
+
+func NewOffline(deps *OfflineDeps) prometheus.Service {
+	return &offlineService{
+		service: deps.Service,
+		local:   &localService{service: &snapshotService{policies: deps.Policies}, logger: deps.Logger},
+		logger:  deps.Logger,
+	}
+}
+
+func (s *offlineService) AuthorizeQuery(
+	ctx context.Context,
+	payload *prometheus.AuthorizeQueryReq,
+) (*prometheus.AuthorizedQueryResp, error) {
+	resp, err := s.service.AuthorizeQuery(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam prometheus authorize query failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.AuthorizeQuery(ctx, payload)
+}
+
+func (s *offlineService) FilterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, error) {
+	resp, err := s.service.FilterSeries(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam prometheus filter series failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.FilterSeries(ctx, payload)
+}
+
+func (s *offlineService) FilterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, error) {
+	resp, err := s.service.FilterLabelValues(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam prometheus filter label values failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.FilterLabelValues(ctx, payload)
+}
+
+func (s *offlineService) FilterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, error) {
+	resp, err := s.service.FilterLabelNames(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam prometheus filter label names failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.FilterLabelNames(ctx, payload)
+}
+
+func (s *offlineService) GetPolicy(
+	ctx context.Context,
+	payload *prometheus.GetPolicyReq,
+) (*prometheus.GetPolicyResp, error) {
+	resp, err := s.service.GetPolicy(ctx, payload)
+	if err == nil {
+		return resp, nil
+	}
+
+	s.logger.Ctx(ctx).Errorw("giam prometheus policy failed, enforcing the policy snapshot", "err", err)
+
+	return s.local.GetPolicy(ctx, payload)
+}
+
+// snapshotService serves the policies of the snapshot, everything else needs the Giam API.
+type snapshotService struct {
+	policies *policy.Store
+}
+
+func (s *snapshotService) AuthorizeQuery(
+	ctx context.Context,
+	payload *prometheus.AuthorizeQueryReq,
+) (*prometheus.AuthorizedQueryResp, error) {
+	return nil, errors.ErrUnsupportedOffline
+}
+
+func (s *snapshotService) FilterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, error) {
+	return nil, errors.ErrUnsupportedOffline
+}
+
+func (s *snapshotService) FilterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, error) {
+	return nil, errors.ErrUnsupportedOffline
+}
+
+func (s *snapshotService) FilterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, error) {
+	return nil, errors.ErrUnsupportedOffline
+}
+
+func (s *snapshotService) GetPolicy(
+	ctx context.Context,
+	payload *prometheus.GetPolicyReq,
+) (*prometheus.GetPolicyResp, error) {
+	matchers, err := s.policies.Matchers(payload.Teams, payload.Datasource.UID)
+	if err == errors.ErrNoPolicy {
+		return &prometheus.GetPolicyResp{
+			Message:    "No policy allows access to this datasource",
+			StatusCode: http.StatusForbidden,
+		}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &prometheus.GetPolicyResp{Matchers: matchers, StatusCode: http.StatusOK}, nil
+}
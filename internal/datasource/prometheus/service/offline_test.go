@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+	"github.com/usegiam/giam-traefik-plugin/internal/policy"
+	policyservice "github.com/usegiam/giam-traefik-plugin/internal/policy/service"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestOfflineService_AuthorizeQuery(t *testing.T) {
+	policies := policy.NewStore(&policy.StoreDeps{
+		Service: &policyservice.Mock{Snapshot: &policy.Snapshot{
+			Teams: []*policy.TeamPolicy{
+				{TeamID: 1, Datasources: []*policy.DatasourcePolicy{
+					{UID: "dummyUID1", Matchers: []*label.Matcher{{Name: "team", Type: label.MatchEqual, Value: "payment"}}},
+				}},
+			},
+		}},
+		Logger: log.New("FATAL"),
+	})
+	require.NoError(t, policies.Sync(context.Background()))
+
+	tests := []struct {
+		name         string
+		service      *Mock
+		teams        []*grafana.Team
+		expectedResp *prometheus.AuthorizedQueryResp
+	}{
+		{
+			name: "it should use giam when it is reachable",
+			service: &Mock{AuthorizedQueryResp: &prometheus.AuthorizedQueryResp{
+				Queries:    []interface{}{map[string]interface{}{"expr": `up{team="menu"}`}},
+				StatusCode: http.StatusOK,
+			}},
+			teams: []*grafana.Team{{ID: 1, Name: "team1"}},
+			expectedResp: &prometheus.AuthorizedQueryResp{
+				Queries:    []interface{}{map[string]interface{}{"expr": `up{team="menu"}`}},
+				StatusCode: http.StatusOK,
+			},
+		},
+		{
+			name:    "it should enforce the policy snapshot when giam is unreachable",
+			service: &Mock{Error: errors.New("connection refused")},
+			teams:   []*grafana.Team{{ID: 1, Name: "team1"}},
+			expectedResp: &prometheus.AuthorizedQueryResp{
+				Queries: []interface{}{map[string]interface{}{
+					"expr":       `up{team="payment"}`,
+					"datasource": map[string]interface{}{"uid": "dummyUID1"},
+				}},
+				StatusCode: http.StatusOK,
+			},
+		},
+		{
+			name:    "it should reject the teams without policy when giam is unreachable",
+			service: &Mock{Error: errors.New("connection refused")},
+			teams:   []*grafana.Team{{ID: 2, Name: "team2"}},
+			expectedResp: &prometheus.AuthorizedQueryResp{
+				Message:    "No policy allows access to this datasource",
+				StatusCode: http.StatusForbidden,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewOffline(&OfflineDeps{Service: tt.service, Policies: policies, Logger: log.New("FATAL")})
+
+			resp, err := svc.AuthorizeQuery(context.Background(), &prometheus.AuthorizeQueryReq{
+				User:  &grafana.User{ID: 1, Name: "user1"},
+				Teams: tt.teams,
+				Queries: []interface{}{map[string]interface{}{
+					"expr":       `up`,
+					"datasource": map[string]interface{}{"uid": "dummyUID1"},
+				}},
+			})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedResp.StatusCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedResp.Message, resp.Message)
+			assert.CompareJson(t, tt.expectedResp.Queries, resp.Queries)
+		})
+	}
+}
@@ -1,120 +1,170 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
-	"io"
 	"net/http"
 
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/pkg/giam"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
 )
 
 type service struct {
-	apiUrl string
-	apiKey string
+	client *giam.Client
 	logger *log.Logger
 }
 
 type Deps struct {
-	APIUrl string
-	APIKey string
+	Client *giam.Client
 	Logger *log.Logger
 }
 
 func New(deps *Deps) prometheus.Service {
-	return &service{apiUrl: deps.APIUrl, apiKey: deps.APIKey, logger: deps.Logger}
+	return &service{client: deps.Client, logger: deps.Logger}
 }
 
-func (s *service) AuthorizeQuery(payload *prometheus.AuthorizeQueryReq) (*prometheus.AuthorizedQueryResp, error) {
-	payloadBytes, err := json.Marshal(payload)
+func (s *service) AuthorizeQuery(
+	ctx context.Context,
+	payload *prometheus.AuthorizeQueryReq,
+) (*prometheus.AuthorizedQueryResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/prometheus/query/authorize",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, s.apiUrl+"/prometheus/query/authorize", bytes.NewBuffer(payloadBytes))
+	s.logger.Ctx(ctx).Debugw("giam prometheus authorize query resp", "status", resp.StatusCode, "body", string(resp.Body))
+
+	var queryResp prometheus.AuthorizedQueryResp
+
+	err = json.Unmarshal(resp.Body, &queryResp)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode giam prometheus authorize query resp: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", s.apiKey)
+	queryResp.StatusCode = resp.StatusCode
 
-	client := &http.Client{}
+	return &queryResp, nil
+}
 
-	resp, err := client.Do(req)
+func (s *service) FilterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/prometheus/series/filter",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	s.logger.Ctx(ctx).Debugw("giam prometheus filter series resp", "status", resp.StatusCode, "body", string(resp.Body))
 
-	respBody, err := io.ReadAll(resp.Body)
+	var filterSeriesResp prometheus.FilterSeriesResp
+
+	err = json.Unmarshal(resp.Body, &filterSeriesResp)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode giam prometheus filter series resp: %w", err)
 	}
 
-	s.logger.Debugf(
-		"giam prometheus authorize query resp status code: %v, resp body: %s",
-		resp.StatusCode,
-		string(respBody),
-	)
+	filterSeriesResp.StatusCode = resp.StatusCode
 
-	var queryResp prometheus.AuthorizedQueryResp
+	return &filterSeriesResp, nil
+}
 
-	err = json.Unmarshal(respBody, &queryResp)
+func (s *service) FilterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/prometheus/label/filter",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode giam prometheus authorize query resp: %w", err)
+		return nil, err
 	}
 
-	queryResp.StatusCode = resp.StatusCode
+	s.logger.Ctx(ctx).Debugw("giam prometheus filter label values resp",
+		"status", resp.StatusCode, "body", string(resp.Body))
 
-	return &queryResp, nil
-}
+	var filterLabelValuesResp prometheus.FilterLabelValuesResp
 
-func (s *service) FilterSeries(payload *prometheus.FilterSeriesReq) (*prometheus.FilterSeriesResp, error) {
-	payloadBytes, err := json.Marshal(payload)
+	err = json.Unmarshal(resp.Body, &filterLabelValuesResp)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode giam prometheus filter label values resp: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, s.apiUrl+"/prometheus/series/filter", bytes.NewBuffer(payloadBytes))
+	filterLabelValuesResp.StatusCode = resp.StatusCode
+
+	return &filterLabelValuesResp, nil
+}
+
+func (s *service) FilterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/prometheus/labels/filter",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", s.apiKey)
+	s.logger.Ctx(ctx).Debugw("giam prometheus filter label names resp",
+		"status", resp.StatusCode, "body", string(resp.Body))
 
-	client := &http.Client{}
+	var filterLabelNamesResp prometheus.FilterLabelNamesResp
 
-	resp, err := client.Do(req)
+	err = json.Unmarshal(resp.Body, &filterLabelNamesResp)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to decode giam prometheus filter label names resp: %w", err)
 	}
 
-	defer resp.Body.Close()
+	filterLabelNamesResp.StatusCode = resp.StatusCode
 
-	respBody, err := io.ReadAll(resp.Body)
+	return &filterLabelNamesResp, nil
+}
+
+func (s *service) GetPolicy(ctx context.Context, payload *prometheus.GetPolicyReq) (*prometheus.GetPolicyResp, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{
+		Method:     http.MethodPost,
+		Path:       "/prometheus/policy",
+		Payload:    payload,
+		Idempotent: true,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	s.logger.Debugf(
-		"giam prometheus filter series resp status code: %v, resp body: %s",
-		resp.StatusCode,
-		string(respBody),
-	)
+	s.logger.Ctx(ctx).Debugw("giam prometheus policy resp", "status", resp.StatusCode, "body", string(resp.Body))
 
-	var filterSeriesResp prometheus.FilterSeriesResp
+	var policyResp prometheus.GetPolicyResp
 
-	err = json.Unmarshal(respBody, &filterSeriesResp)
+	err = json.Unmarshal(resp.Body, &policyResp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode giam prometheus filter series resp: %w", err)
+		return nil, fmt.Errorf("failed to decode giam prometheus policy resp: %w", err)
 	}
 
-	filterSeriesResp.StatusCode = resp.StatusCode
+	if err := label.Compile(policyResp.Matchers); err != nil {
+		return nil, fmt.Errorf("invalid giam prometheus policy: %w", err)
+	}
 
-	return &filterSeriesResp, nil
+	policyResp.StatusCode = resp.StatusCode
+
+	return &policyResp, nil
 }
@@ -1,17 +1,48 @@
 package service
 
-import "github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
+)
 
 type Mock struct {
-	Error               error
-	AuthorizedQueryResp *prometheus.AuthorizedQueryResp
-	FilterSeriesResp    *prometheus.FilterSeriesResp
+	Error                 error
+	AuthorizedQueryResp   *prometheus.AuthorizedQueryResp
+	FilterSeriesResp      *prometheus.FilterSeriesResp
+	FilterLabelValuesResp *prometheus.FilterLabelValuesResp
+	FilterLabelNamesResp  *prometheus.FilterLabelNamesResp
+	GetPolicyResp         *prometheus.GetPolicyResp
 }
 
-func (m *Mock) AuthorizeQuery(payload *prometheus.AuthorizeQueryReq) (*prometheus.AuthorizedQueryResp, error) {
+func (m *Mock) AuthorizeQuery(
+	ctx context.Context,
+	payload *prometheus.AuthorizeQueryReq,
+) (*prometheus.AuthorizedQueryResp, error) {
 	return m.AuthorizedQueryResp, m.Error
 }
 
-func (m *Mock) FilterSeries(payload *prometheus.FilterSeriesReq) (*prometheus.FilterSeriesResp, error) {
+func (m *Mock) FilterSeries(
+	ctx context.Context,
+	payload *prometheus.FilterSeriesReq,
+) (*prometheus.FilterSeriesResp, error) {
 	return m.FilterSeriesResp, m.Error
 }
+
+func (m *Mock) FilterLabelValues(
+	ctx context.Context,
+	payload *prometheus.FilterLabelValuesReq,
+) (*prometheus.FilterLabelValuesResp, error) {
+	return m.FilterLabelValuesResp, m.Error
+}
+
+func (m *Mock) FilterLabelNames(
+	ctx context.Context,
+	payload *prometheus.FilterLabelNamesReq,
+) (*prometheus.FilterLabelNamesResp, error) {
+	return m.FilterLabelNamesResp, m.Error
+}
+
+func (m *Mock) GetPolicy(ctx context.Context, payload *prometheus.GetPolicyReq) (*prometheus.GetPolicyResp, error) {
+	return m.GetPolicyResp, m.Error
+}
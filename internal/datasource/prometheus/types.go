@@ -1,16 +1,31 @@
 package prometheus
 
 import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 )
 
+// Modes of the prometheus service.
+const (
+	// ModeRemote rewrites the queries through the Giam API.
+	ModeRemote = "remote"
+	// ModeLocal fetches the policy from the Giam API and rewrites the queries in the plugin.
+	ModeLocal = "local"
+)
+
 type Service interface {
-	AuthorizeQuery(payload *AuthorizeQueryReq) (*AuthorizedQueryResp, error)
-	FilterSeries(payload *FilterSeriesReq) (*FilterSeriesResp, error)
+	AuthorizeQuery(ctx context.Context, payload *AuthorizeQueryReq) (*AuthorizedQueryResp, error)
+	FilterSeries(ctx context.Context, payload *FilterSeriesReq) (*FilterSeriesResp, error)
+	FilterLabelValues(ctx context.Context, payload *FilterLabelValuesReq) (*FilterLabelValuesResp, error)
+	FilterLabelNames(ctx context.Context, payload *FilterLabelNamesReq) (*FilterLabelNamesResp, error)
+	GetPolicy(ctx context.Context, payload *GetPolicyReq) (*GetPolicyResp, error)
 }
 
 type Repo interface {
-	GetMetrics(session string, uid string) ([]*Metric, error)
+	// GetMetrics returns the metrics of the datasource with at least one series satisfying every matcher.
+	GetMetrics(ctx context.Context, creds *grafana.Credentials, uid string, matchers []*label.Matcher) ([]*Metric, error)
 }
 
 type Metric struct {
@@ -26,12 +41,14 @@ type AuthorizedQueryResp struct {
 type AuthorizeQueryReq struct {
 	User    interface{}     `json:"user"`
 	Teams   []*grafana.Team `json:"teams"`
+	OrgID   int             `json:"orgId"`
 	Queries []interface{}   `json:"queries"`
 }
 
 type FilterSeriesReq struct {
 	User       interface{}         `json:"user"`
 	Teams      []*grafana.Team     `json:"teams"`
+	OrgID      int                 `json:"orgId"`
 	Series     []map[string]string `json:"series"`
 	Datasource grafana.Datasource  `json:"datasource"`
 }
@@ -40,3 +57,63 @@ type FilterSeriesResp struct {
 	Data       []map[string]string `json:"data"`
 	StatusCode int                 `json:"status_code"`
 }
+
+type Label struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type FilterLabelValuesReq struct {
+	User       interface{}        `json:"user"`
+	Teams      []*grafana.Team    `json:"teams"`
+	OrgID      int                `json:"orgId"`
+	Label      *Label             `json:"label"`
+	Datasource grafana.Datasource `json:"datasource"`
+}
+
+type FilterLabelValuesResp struct {
+	Data       []string `json:"data"`
+	StatusCode int      `json:"status_code"`
+}
+
+type FilterLabelNamesReq struct {
+	User       interface{}        `json:"user"`
+	Teams      []*grafana.Team    `json:"teams"`
+	OrgID      int                `json:"orgId"`
+	Names      []string           `json:"names"`
+	Datasource grafana.Datasource `json:"datasource"`
+}
+
+type FilterLabelNamesResp struct {
+	Data       []string `json:"data"`
+	StatusCode int      `json:"status_code"`
+}
+
+// FilterMetricsReq holds the names of the metrics answered by prometheus, to be filtered down to the metrics the user
+// can see.
+type FilterMetricsReq struct {
+	User       interface{}        `json:"user"`
+	Teams      []*grafana.Team    `json:"teams"`
+	OrgID      int                `json:"orgId"`
+	Names      []string           `json:"names"`
+	Datasource grafana.Datasource `json:"datasource"`
+}
+
+type FilterMetricsResp struct {
+	Data       []string `json:"data"`
+	StatusCode int      `json:"status_code"`
+}
+
+type GetPolicyReq struct {
+	User       interface{}        `json:"user"`
+	Teams      []*grafana.Team    `json:"teams"`
+	OrgID      int                `json:"orgId"`
+	Datasource grafana.Datasource `json:"datasource"`
+}
+
+// GetPolicyResp holds the label matchers every selector of the user queries must be constrained with.
+type GetPolicyResp struct {
+	Matchers   []*label.Matcher `json:"matchers"`
+	Message    string           `json:"message"`
+	StatusCode int              `json:"status_code"`
+}
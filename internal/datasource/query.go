@@ -0,0 +1,57 @@
+package datasource
+
+import "fmt"
+
+// QueryDatasourceUID returns the uid of the datasource a grafana query targets, or an empty string when the query
+// doesn't reference any.
+func QueryDatasourceUID(query interface{}) string {
+	q, ok := query.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	ds, ok := q["datasource"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	uid, _ := ds["uid"].(string)
+
+	return uid
+}
+
+// QueryExpr returns the expression of a grafana query, or an empty string when the query doesn't have any.
+func QueryExpr(query interface{}) string {
+	q, _ := query.(map[string]interface{})
+	expr, _ := q["expr"].(string)
+
+	return expr
+}
+
+// RewriteQueryExpr returns a copy of the grafana query whose "expr" is replaced by the result of rewrite. Queries
+// without expression are returned as is.
+func RewriteQueryExpr(query interface{}, rewrite func(expr string) (string, error)) (interface{}, error) {
+	q, ok := query.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected query %v", query)
+	}
+
+	rewritten := make(map[string]interface{}, len(q))
+	for k, v := range q {
+		rewritten[k] = v
+	}
+
+	expr, _ := q["expr"].(string)
+	if expr == "" {
+		return rewritten, nil
+	}
+
+	rewrittenExpr, err := rewrite(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten["expr"] = rewrittenExpr
+
+	return rewritten, nil
+}
@@ -0,0 +1,35 @@
+package datasource
+
+import (
+	"context"
+	"net/http"
+)
+
+type MockQueryAuthorizer struct {
+	AuthorizedQueries *AuthorizedQueries
+	Error             error
+	// Received holds the queries of the last call.
+	Received []interface{}
+}
+
+func (m *MockQueryAuthorizer) AuthorizeQueries(
+	_ context.Context,
+	payload *AuthorizeQueriesReq,
+) (*AuthorizedQueries, error) {
+	m.Received = payload.Queries
+
+	return m.AuthorizedQueries, m.Error
+}
+
+// NewRewritingQueryAuthorizer returns a mock authorizing the queries, rewriting their expressions into exprs, in order.
+func NewRewritingQueryAuthorizer(exprs ...string) *MockQueryAuthorizer {
+	queries := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		queries[i] = map[string]interface{}{"expr": expr}
+	}
+
+	return &MockQueryAuthorizer{AuthorizedQueries: &AuthorizedQueries{
+		Queries:    queries,
+		StatusCode: http.StatusOK,
+	}}
+}
@@ -0,0 +1,130 @@
+package datasource
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/cache"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+)
+
+// resolverMaxEntries bounds the number of cached datasource types.
+const resolverMaxEntries = 1000
+
+// Resolver resolves the real type of the datasources referenced by grafana queries. The type sent by the client is
+// never trusted, it is always looked up in grafana by the datasource uid.
+type Resolver struct {
+	grafanaRepo grafana.Repo
+	types       *cache.Cache
+	metrics     *metrics.Cache
+	logger      *log.Logger
+}
+
+type ResolverDeps struct {
+	GrafanaRepo grafana.Repo
+	Logger      *log.Logger
+	// CacheTTL is how long a resolved datasource type is kept.
+	CacheTTL time.Duration
+	// Metrics records the hits and misses of the cache, nil disables it.
+	Metrics *metrics.Registry
+}
+
+func NewResolver(deps *ResolverDeps) *Resolver {
+	return &Resolver{
+		grafanaRepo: deps.GrafanaRepo,
+		types:       cache.New(resolverMaxEntries, deps.CacheTTL),
+		metrics:     metrics.NewCache(deps.Metrics, "datasource"),
+		logger:      deps.Logger,
+	}
+}
+
+// Resolve returns the type of the datasource with the given uid, in the organization selected by creds. Datasource
+// uids are only unique within an organization, so the type is only cached when the organization is known: the
+// datasources of the organizations must never share an entry.
+func (r *Resolver) Resolve(ctx context.Context, creds *grafana.Credentials, uid string) (Datasource, error) {
+	if IsBuiltin(uid) {
+		return Datasource(uid), nil
+	}
+
+	key := strconv.Itoa(creds.OrgID) + ":" + uid
+
+	if creds.OrgID != 0 {
+		value, ok := r.types.Get(key)
+		r.metrics.Record(ok)
+
+		if ok {
+			return value.(Datasource), nil
+		}
+	}
+
+	ds, err := r.grafanaRepo.GetDatasource(ctx, creds, uid)
+	if err != nil {
+		return "", err
+	}
+
+	r.logger.Ctx(ctx).Debugw("resolved datasource", "uid", uid, "type", ds.Type, "org_id", creds.OrgID)
+
+	if creds.OrgID != 0 {
+		r.types.Set(key, Datasource(ds.Type))
+	}
+
+	return Datasource(ds.Type), nil
+}
+
+// ResolveQueries returns the datasource type of every grafana query, in the same order as the queries.
+func (r *Resolver) ResolveQueries(
+	ctx context.Context,
+	creds *grafana.Credentials,
+	queries []interface{},
+) ([]Datasource, error) {
+	types := make([]Datasource, len(queries))
+
+	for i, query := range queries {
+		uid := QueryDatasourceUID(query)
+		if uid == "" {
+			return nil, errors.ErrMissingDatasource
+		}
+
+		dsType, err := r.Resolve(ctx, creds, uid)
+		if err != nil {
+			return nil, err
+		}
+
+		types[i] = dsType
+	}
+
+	return types, nil
+}
+
+// builtinDatasources are the uids of the grafana builtin datasources. They are matched exactly, a provisioned
+// datasource can use any other uid, e.g. one starting with "__".
+var builtinDatasources = map[string]bool{
+	"-- Grafana --":   true,
+	"-- Mixed --":     true,
+	"-- Dashboard --": true,
+	"__expr__":        true,
+}
+
+// IsBuiltin reports whether uid is a grafana builtin datasource such as server side expressions (__expr__) or
+// "-- Mixed --". These datasources are not stored in grafana, they never reach a loki or prometheus instance by
+// themselves.
+func IsBuiltin(uid string) bool {
+	return builtinDatasources[uid]
+}
+
+// Select returns the indexes of the queries whose type is ds.
+func Select(types []Datasource, ds Datasource) []int {
+	var indexes []int
+
+	for i, t := range types {
+		if t == ds {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes
+}
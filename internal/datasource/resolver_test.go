@@ -0,0 +1,104 @@
+package datasource
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestIsBuiltin(t *testing.T) {
+	tests := []struct {
+		name string
+		uid  string
+		want bool
+	}{
+		{
+			name: "It should return true for the server side expressions",
+			uid:  "__expr__",
+			want: true,
+		},
+		{
+			name: "It should return true for the mixed datasource",
+			uid:  "-- Mixed --",
+			want: true,
+		},
+		{
+			name: "It should return false for a provisioned datasource whose uid starts with __",
+			uid:  "__loki_payment",
+			want: false,
+		},
+		{
+			name: "It should return false for a provisioned datasource whose uid starts with --",
+			uid:  "-- prometheus",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsBuiltin(tt.uid))
+		})
+	}
+}
+
+// orgRepo holds the datasources of every organization, by organization id.
+type orgRepo struct {
+	grafana.MockRepo
+	orgs  map[int]map[string]*grafana.Datasource
+	calls int
+}
+
+func (o *orgRepo) GetDatasource(
+	_ context.Context,
+	creds *grafana.Credentials,
+	uid string,
+) (*grafana.Datasource, error) {
+	o.calls++
+
+	ds, ok := o.orgs[creds.OrgID][uid]
+	if !ok {
+		return nil, errors.ErrDatasourceNotFound
+	}
+
+	return ds, nil
+}
+
+func TestResolver_Resolve_Orgs(t *testing.T) {
+	repo := &orgRepo{orgs: map[int]map[string]*grafana.Datasource{
+		0: {"shared": {UID: "shared", Type: "tempo"}},
+		1: {"shared": {UID: "shared", Type: "prometheus"}},
+		2: {"shared": {UID: "shared", Type: "tempo"}},
+	}}
+
+	resolver := NewResolver(&ResolverDeps{GrafanaRepo: repo, CacheTTL: time.Minute, Logger: log.New("FATAL")})
+
+	ctx := context.Background()
+
+	// The datasource of another organization sharing the uid is resolved first, it must not be served to the first one.
+	ds, err := resolver.Resolve(ctx, &grafana.Credentials{Session: "admin", OrgID: 2}, "shared")
+	require.NoError(t, err)
+	assert.Equal(t, Datasource("tempo"), ds)
+
+	ds, err = resolver.Resolve(ctx, &grafana.Credentials{Session: "viewer", OrgID: 1}, "shared")
+	require.NoError(t, err)
+	assert.Equal(t, Prometheus, ds)
+
+	ds, err = resolver.Resolve(ctx, &grafana.Credentials{Session: "viewer", OrgID: 1}, "shared")
+	require.NoError(t, err)
+	assert.Equal(t, Prometheus, ds)
+	assert.Equal(t, 2, repo.calls)
+
+	// The type resolved in an unknown organization is never cached.
+	for i := 0; i < 2; i++ {
+		_, err = resolver.Resolve(ctx, &grafana.Credentials{Session: "viewer"}, "shared")
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 4, repo.calls)
+}
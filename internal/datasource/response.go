@@ -0,0 +1,45 @@
+package datasource
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/types"
+)
+
+// ReadResponse decodes the JSON response answered by the datasource into v, whether it was gzip compressed or not.
+func ReadResponse(w *types.ResponseWriter, v interface{}) error {
+	var reader io.Reader = w.Body
+
+	if w.Header().Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			return err
+		}
+
+		defer gz.Close()
+
+		reader = gz
+	}
+
+	return json.NewDecoder(reader).Decode(v)
+}
+
+// WriteResponse writes the filtered response, uncompressed, with the status code of the upstream.
+func WriteResponse(rw http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(rw, "Error marshaling response", http.StatusInternalServerError)
+
+		return
+	}
+
+	rw.Header().Del("Content-Encoding")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
@@ -1,8 +1,35 @@
 package datasource
 
+import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
+
 type Datasource string
 
 var (
 	Loki       Datasource = "loki"
 	Prometheus Datasource = "prometheus"
 )
+
+// QueryAuthorizer authorizes and rewrites the grafana queries targeting a single datasource type.
+type QueryAuthorizer interface {
+	AuthorizeQueries(ctx context.Context, payload *AuthorizeQueriesReq) (*AuthorizedQueries, error)
+}
+
+type AuthorizeQueriesReq struct {
+	User  *grafana.User
+	Teams []*grafana.Team
+	// OrgID is the grafana organization the queries are made in.
+	OrgID   int
+	Queries []interface{}
+}
+
+// AuthorizedQueries holds the rewritten queries, in the same order as they were given, when StatusCode is
+// http.StatusOK. Otherwise Message explains why the queries were rejected.
+type AuthorizedQueries struct {
+	Queries    []interface{}
+	Message    string
+	StatusCode int
+}
@@ -7,4 +7,13 @@ var (
 	ErrUnsupportedDatasource          = errors.New("unsupported datasource")
 	ErrUserDoesntHaveAnyTeamAssigned  = errors.New("failed to fetch user teams, user might have not a team")
 	ErrFailedtoCommunicateWithGrafana = errors.New("failed to communicate with grafana")
+	ErrDatasourceNotFound             = errors.New("datasource not found")
+	ErrMissingDatasource              = errors.New("query doesn't reference a datasource uid")
+	ErrPolicySnapshotUnavailable      = errors.New("no policy snapshot synced from giam yet")
+	ErrNoPolicy                       = errors.New("no policy allows access to the datasource")
+	ErrUnsupportedOffline             = errors.New("operation is not supported with the policy snapshot")
+	ErrCircuitOpen                    = errors.New("circuit breaker is open")
+	ErrGiamServer                     = errors.New("giam answered with a server error")
+	ErrMissingCredentials             = errors.New("request doesn't carry any credentials")
+	ErrInvalidToken                   = errors.New("invalid token")
 )
@@ -0,0 +1,228 @@
+package failure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/pkg/cache"
+	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// Mode is what a handler does with a request it can't enforce because Giam can't be reached.
+type Mode string
+
+const (
+	// ModeDeny rejects the request with 412 Precondition Failed.
+	ModeDeny Mode = "deny"
+	// ModeAllowUnmodified forwards the request, or the upstream response, without enforcing any policy.
+	ModeAllowUnmodified Mode = "allow-unmodified"
+	// ModeAllowReadOnlyMetadata behaves as ModeAllowUnmodified on the metadata endpoints, such as series and label
+	// values, and as ModeDeny on the query endpoints.
+	ModeAllowReadOnlyMetadata Mode = "allow-read-only-metadata"
+	// ModeServeFromLastDecision reuses the last decision Giam took for the very same request, and denies the request
+	// when there is none.
+	ModeServeFromLastDecision Mode = "serve-from-last-decision"
+)
+
+// Endpoints the failure mode can be configured for.
+const (
+	EndpointDatasource  = "datasource"
+	EndpointQuery       = "query"
+	EndpointSeries      = "series"
+	EndpointLabelValues = "label_values"
+	EndpointLabelNames  = "label_names"
+	EndpointMetadata    = "metadata"
+	EndpointExemplars   = "exemplars"
+)
+
+// metadataEndpoints only expose series, label and metric metadata, never samples or log lines.
+var metadataEndpoints = map[string]bool{
+	EndpointSeries:      true,
+	EndpointLabelValues: true,
+	EndpointLabelNames:  true,
+	EndpointMetadata:    true,
+}
+
+// Outcome is the action a handler takes for a request it failed to enforce.
+type Outcome int
+
+const (
+	OutcomeDeny Outcome = iota
+	OutcomeAllowUnmodified
+	OutcomeLastDecision
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeAllowUnmodified:
+		return "allow unmodified"
+	case OutcomeLastDecision:
+		return "serve last decision"
+	default:
+		return "deny"
+	}
+}
+
+// decisionsMaxEntries bounds the number of decisions kept for ModeServeFromLastDecision.
+const decisionsMaxEntries = 10000
+
+// Policy resolves the failure mode of a request from its endpoint and datasource type, and keeps the last decisions
+// taken by Giam. A nil Policy denies every failed request.
+type Policy struct {
+	defaultMode Mode
+	datasources map[string]Mode
+	endpoints   map[string]Mode
+	decisions   *cache.Cache
+	hashSvc     hash.Service
+	logger      *log.Logger
+}
+
+type PolicyDeps struct {
+	Default Mode
+	// Datasources overrides the default mode by datasource type, e.g. "loki".
+	Datasources map[string]Mode
+	// Endpoints overrides the datasource and default modes by endpoint, e.g. "series".
+	Endpoints map[string]Mode
+	// DecisionTTL is how long a decision can be served after it was taken.
+	DecisionTTL time.Duration
+	HashSvc     hash.Service
+	Logger      *log.Logger
+}
+
+func NewPolicy(deps *PolicyDeps) (*Policy, error) {
+	if err := validate(deps.Default); err != nil {
+		return nil, err
+	}
+
+	for _, modes := range []map[string]Mode{deps.Datasources, deps.Endpoints} {
+		for _, mode := range modes {
+			if err := validate(mode); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Policy{
+		defaultMode: deps.Default,
+		datasources: deps.Datasources,
+		endpoints:   deps.Endpoints,
+		decisions:   cache.New(decisionsMaxEntries, deps.DecisionTTL),
+		hashSvc:     deps.HashSvc,
+		logger:      deps.Logger,
+	}, nil
+}
+
+func validate(mode Mode) error {
+	switch mode {
+	case ModeDeny, ModeAllowUnmodified, ModeAllowReadOnlyMetadata, ModeServeFromLastDecision:
+		return nil
+	default:
+		return fmt.Errorf("invalid failure mode %q", mode)
+	}
+}
+
+// Mode returns the failure mode of the endpoint for the datasource type.
+func (p *Policy) Mode(datasource string, endpoint string) Mode {
+	if mode, ok := p.endpoints[endpoint]; ok {
+		return mode
+	}
+
+	if mode, ok := p.datasources[datasource]; ok {
+		return mode
+	}
+
+	return p.defaultMode
+}
+
+// Remember keeps the decision Giam took for the request payload, so it can be served if Giam fails later on.
+func (p *Policy) Remember(datasource string, endpoint string, payload interface{}, decision interface{}) {
+	if p == nil || p.Mode(datasource, endpoint) != ModeServeFromLastDecision {
+		return
+	}
+
+	key, err := p.key(datasource, endpoint, payload)
+	if err != nil {
+		return
+	}
+
+	p.decisions.Set(key, decision)
+}
+
+// Resolve returns the outcome of a request which failed with err, along with the last decision when the outcome is
+// OutcomeLastDecision. The outcome is logged with the request of ctx.
+func (p *Policy) Resolve(
+	ctx context.Context,
+	datasource string,
+	endpoint string,
+	payload interface{},
+	err error,
+) (Outcome, interface{}) {
+	if p == nil {
+		return OutcomeDeny, nil
+	}
+
+	mode := p.Mode(datasource, endpoint)
+	outcome, decision := p.outcome(mode, datasource, endpoint, payload)
+
+	p.logger.Ctx(ctx).Errorw("unable to enforce the request",
+		"datasource", datasource, "endpoint", endpoint, "mode", mode, "outcome", outcome.String(), "err", err)
+
+	return outcome, decision
+}
+
+// Fallback returns the decision to apply to a request which failed with err: unmodified when the request is allowed
+// unmodified, or the last decision Giam took. It returns false when the request must be denied. The outcome is set on
+// the audit event of the context.
+func (p *Policy) Fallback(
+	ctx context.Context,
+	datasource string,
+	endpoint string,
+	payload interface{},
+	err error,
+	unmodified interface{},
+) (interface{}, bool) {
+	outcome, decision := p.Resolve(ctx, datasource, endpoint, payload, err)
+	audit.FromContext(ctx).SetFailureOutcome(outcome.String())
+
+	switch outcome {
+	case OutcomeAllowUnmodified:
+		return unmodified, true
+	case OutcomeLastDecision:
+		return decision, true
+	default:
+		return nil, false
+	}
+}
+
+func (p *Policy) outcome(mode Mode, datasource string, endpoint string, payload interface{}) (Outcome, interface{}) {
+	switch mode {
+	case ModeAllowUnmodified:
+		return OutcomeAllowUnmodified, nil
+	case ModeAllowReadOnlyMetadata:
+		if metadataEndpoints[endpoint] {
+			return OutcomeAllowUnmodified, nil
+		}
+
+		return OutcomeDeny, nil
+	case ModeServeFromLastDecision:
+		key, err := p.key(datasource, endpoint, payload)
+		if err != nil {
+			return OutcomeDeny, nil
+		}
+
+		if decision, ok := p.decisions.Get(key); ok {
+			return OutcomeLastDecision, decision
+		}
+
+		return OutcomeDeny, nil
+	default:
+		return OutcomeDeny, nil
+	}
+}
+
+func (p *Policy) key(datasource string, endpoint string, payload interface{}) (string, error) {
+	return p.hashSvc.HashSlice([]interface{}{datasource, endpoint, payload})
+}
@@ -0,0 +1,166 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestPolicy_Resolve(t *testing.T) {
+	policy, err := NewPolicy(&PolicyDeps{
+		Default:     ModeDeny,
+		Datasources: map[string]Mode{"loki": ModeAllowReadOnlyMetadata, "prometheus": ModeServeFromLastDecision},
+		Endpoints:   map[string]Mode{EndpointDatasource: ModeAllowUnmodified},
+		DecisionTTL: time.Minute,
+		HashSvc:     hash.NewService(),
+		Logger:      log.New("FATAL"),
+	})
+	require.NoError(t, err)
+
+	policy.Remember("prometheus", EndpointSeries, "known request", "last decision")
+
+	tests := []struct {
+		name             string
+		datasource       string
+		endpoint         string
+		payload          interface{}
+		expectedOutcome  Outcome
+		expectedDecision interface{}
+	}{
+		{
+			name:            "it should apply the default mode",
+			datasource:      "tempo",
+			endpoint:        EndpointQuery,
+			expectedOutcome: OutcomeDeny,
+		},
+		{
+			name:            "it should prefer the endpoint mode over the datasource mode",
+			datasource:      "loki",
+			endpoint:        EndpointDatasource,
+			expectedOutcome: OutcomeAllowUnmodified,
+		},
+		{
+			name:            "it should allow the metadata endpoints in read only metadata mode",
+			datasource:      "loki",
+			endpoint:        EndpointLabelValues,
+			expectedOutcome: OutcomeAllowUnmodified,
+		},
+		{
+			name:            "it should deny the query endpoints in read only metadata mode",
+			datasource:      "loki",
+			endpoint:        EndpointQuery,
+			expectedOutcome: OutcomeDeny,
+		},
+		{
+			name:             "it should serve the last decision of the same request",
+			datasource:       "prometheus",
+			endpoint:         EndpointSeries,
+			payload:          "known request",
+			expectedOutcome:  OutcomeLastDecision,
+			expectedDecision: "last decision",
+		},
+		{
+			name:            "it should deny a request without previous decision",
+			datasource:      "prometheus",
+			endpoint:        EndpointSeries,
+			payload:         "unknown request",
+			expectedOutcome: OutcomeDeny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := errors.New("giam is down")
+
+			outcome, decision := policy.Resolve(context.Background(), tt.datasource, tt.endpoint, tt.payload, err)
+
+			assert.Equal(t, tt.expectedOutcome, outcome)
+			assert.Equal(t, tt.expectedDecision, decision)
+		})
+	}
+}
+
+func TestPolicy_Fallback(t *testing.T) {
+	policy, err := NewPolicy(&PolicyDeps{
+		Default:     ModeDeny,
+		Datasources: map[string]Mode{"loki": ModeAllowUnmodified, "prometheus": ModeServeFromLastDecision},
+		DecisionTTL: time.Minute,
+		HashSvc:     hash.NewService(),
+		Logger:      log.New("FATAL"),
+	})
+	require.NoError(t, err)
+
+	policy.Remember("prometheus", EndpointSeries, "known request", "last decision")
+
+	tests := []struct {
+		name             string
+		datasource       string
+		payload          interface{}
+		expectedDecision interface{}
+		expectedOK       bool
+		expectedOutcome  string
+	}{
+		{
+			name:             "it should return the unmodified decision when the request is allowed unmodified",
+			datasource:       "loki",
+			expectedDecision: "unmodified",
+			expectedOK:       true,
+			expectedOutcome:  "allow unmodified",
+		},
+		{
+			name:             "it should return the last decision of the same request",
+			datasource:       "prometheus",
+			payload:          "known request",
+			expectedDecision: "last decision",
+			expectedOK:       true,
+			expectedOutcome:  "serve last decision",
+		},
+		{
+			name:            "it should return false when the request is denied",
+			datasource:      "tempo",
+			expectedOK:      false,
+			expectedOutcome: "deny",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &audit.Event{}
+			ctx := audit.WithEvent(context.Background(), event)
+
+			err := errors.New("giam is down")
+
+			decision, ok := policy.Fallback(ctx, tt.datasource, EndpointSeries, tt.payload, err, "unmodified")
+
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedDecision, decision)
+			assert.Equal(t, tt.expectedOutcome, event.FailureOutcome)
+		})
+	}
+}
+
+func TestNewPolicy_InvalidMode(t *testing.T) {
+	_, err := NewPolicy(&PolicyDeps{
+		Default:   ModeDeny,
+		Endpoints: map[string]Mode{EndpointQuery: "allow-everything"},
+		HashSvc:   hash.NewService(),
+		Logger:    log.New("FATAL"),
+	})
+
+	assert.Error(t, err)
+}
+
+func TestPolicy_NilDenies(t *testing.T) {
+	var policy *Policy
+
+	outcome, _ := policy.Resolve(context.Background(), "loki", EndpointQuery, nil, errors.New("giam is down"))
+
+	assert.Equal(t, OutcomeDeny, outcome)
+}
@@ -0,0 +1,140 @@
+package identity
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// authProxyResolver resolves the identity of the requests authenticated by an auth proxy, from the headers the proxy
+// sets, without calling grafana. The headers are only trusted when the request comes from a trusted network, as
+// anyone else could set them.
+type authProxyResolver struct {
+	userHeader   string
+	emailHeader  string
+	groupsHeader string
+	roleHeader   string
+	trusted      []*net.IPNet
+	groupTeams   map[string]int
+	logger       *log.Logger
+}
+
+type AuthProxyDeps struct {
+	// UserHeader holds the login of the user, e.g. X-WEBAUTH-USER.
+	UserHeader string
+	// EmailHeader, GroupsHeader and RoleHeader are optional, the groups are comma separated.
+	EmailHeader  string
+	GroupsHeader string
+	RoleHeader   string
+	// Trusted are the networks of the auth proxies.
+	Trusted []*net.IPNet
+	// GroupTeams maps the groups to the grafana team ids the policies are defined for, unmapped groups are passed to
+	// Giam with the team id 0.
+	GroupTeams map[string]int
+	Logger     *log.Logger
+}
+
+func NewAuthProxy(deps *AuthProxyDeps) Resolver {
+	return &authProxyResolver{
+		userHeader:   deps.UserHeader,
+		emailHeader:  deps.EmailHeader,
+		groupsHeader: deps.GroupsHeader,
+		roleHeader:   deps.RoleHeader,
+		trusted:      deps.Trusted,
+		groupTeams:   deps.GroupTeams,
+		logger:       deps.Logger,
+	}
+}
+
+// Credentials replays the user header to grafana, which must run behind the same auth proxy.
+func (r *authProxyResolver) Credentials(req *http.Request) (*grafana.Credentials, error) {
+	if !r.isTrusted(req.RemoteAddr) {
+		r.Sanitize(req)
+
+		return nil, errors.ErrMissingCredentials
+	}
+
+	login := req.Header.Get(r.userHeader)
+	if login == "" {
+		return nil, errors.ErrMissingCredentials
+	}
+
+	return &grafana.Credentials{Header: r.userHeader, Value: login, OrgID: orgID(req)}, nil
+}
+
+func (r *authProxyResolver) Resolve(req *http.Request) (*Identity, error) {
+	creds, err := r.Credentials(req)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &grafana.User{Login: creds.Value, Name: creds.Value}
+
+	if r.emailHeader != "" {
+		user.Email = req.Header.Get(r.emailHeader)
+	}
+
+	var teams []*grafana.Team
+
+	if r.groupsHeader != "" {
+		for _, group := range strings.Split(req.Header.Get(r.groupsHeader), ",") {
+			group = strings.TrimSpace(group)
+			if group == "" {
+				continue
+			}
+
+			teams = append(teams, &grafana.Team{ID: r.groupTeams[group], Name: group})
+		}
+	}
+
+	id := &Identity{User: user, Teams: teams, OrgID: creds.OrgID}
+
+	if r.roleHeader != "" {
+		id.Role = req.Header.Get(r.roleHeader)
+	}
+
+	return id, nil
+}
+
+// Sanitize removes the auth proxy headers sent from an untrusted address. Grafana trusts them from the plugin, whatever
+// the identity the request is resolved with.
+func (r *authProxyResolver) Sanitize(req *http.Request) {
+	if r.isTrusted(req.RemoteAddr) {
+		return
+	}
+
+	if req.Header.Get(r.userHeader) != "" {
+		r.logger.Ctx(req.Context()).Debugw("removing auth proxy header sent from an untrusted address",
+			"header", r.userHeader, "remote_addr", req.RemoteAddr)
+	}
+
+	for _, header := range []string{r.userHeader, r.emailHeader, r.groupsHeader, r.roleHeader} {
+		if header != "" {
+			req.Header.Del(header)
+		}
+	}
+}
+
+func (r *authProxyResolver) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
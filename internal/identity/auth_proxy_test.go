@@ -0,0 +1,140 @@
+package identity
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestAuthProxy_Resolve(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	resolver := NewAuthProxy(&AuthProxyDeps{
+		UserHeader:   "X-WEBAUTH-USER",
+		EmailHeader:  "X-WEBAUTH-EMAIL",
+		GroupsHeader: "X-WEBAUTH-GROUPS",
+		RoleHeader:   "X-WEBAUTH-ROLE",
+		Trusted:      []*net.IPNet{trusted},
+		GroupTeams:   map[string]int{"payment": 3},
+		Logger:       log.New("FATAL"),
+	})
+
+	tests := []struct {
+		name             string
+		remoteAddr       string
+		headers          map[string]string
+		expectedIdentity *Identity
+		expectedError    error
+	}{
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.1.2.3:51234",
+			headers: map[string]string{
+				"X-WEBAUTH-USER":   "jane",
+				"X-WEBAUTH-EMAIL":  "jane@example.com",
+				"X-WEBAUTH-GROUPS": "payment, oncall",
+				"X-WEBAUTH-ROLE":   "Editor",
+			},
+			expectedIdentity: &Identity{
+				User:  &grafana.User{Login: "jane", Name: "jane", Email: "jane@example.com"},
+				Teams: []*grafana.Team{{ID: 3, Name: "payment"}, {ID: 0, Name: "oncall"}},
+				Role:  "Editor",
+			},
+		},
+		{
+			name:          "untrusted address",
+			remoteAddr:    "192.168.1.10:51234",
+			headers:       map[string]string{"X-WEBAUTH-USER": "jane"},
+			expectedError: errors.ErrMissingCredentials,
+		},
+		{
+			name:          "no user header",
+			remoteAddr:    "10.1.2.3:51234",
+			expectedError: errors.ErrMissingCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+			require.NoError(t, err)
+
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			id, err := resolver.Resolve(req)
+			if tt.expectedError != nil {
+				assert.Equal(t, tt.expectedError, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedIdentity, id)
+		})
+	}
+}
+
+func TestAuthProxy_Sanitize(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	// The session resolver resolves the identity first, the auth proxy headers must not reach grafana anyway.
+	resolver := NewChain(
+		NewSession(&SessionDeps{Logger: log.New("FATAL")}),
+		NewAuthProxy(&AuthProxyDeps{
+			UserHeader:   "X-WEBAUTH-USER",
+			EmailHeader:  "X-WEBAUTH-EMAIL",
+			GroupsHeader: "X-WEBAUTH-GROUPS",
+			RoleHeader:   "X-WEBAUTH-ROLE",
+			Trusted:      []*net.IPNet{trusted},
+			Logger:       log.New("FATAL"),
+		}),
+	)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		expected   string
+	}{
+		{
+			name:       "It should remove the headers sent from an untrusted address",
+			remoteAddr: "192.168.1.10:51234",
+			expected:   "",
+		},
+		{
+			name:       "It should keep the headers sent from a trusted proxy",
+			remoteAddr: "10.1.2.3:51234",
+			expected:   "admin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/api/dashboards/uid/abc", nil)
+			require.NoError(t, err)
+
+			req.RemoteAddr = tt.remoteAddr
+			req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "session"})
+
+			for _, header := range []string{"X-WEBAUTH-USER", "X-WEBAUTH-EMAIL", "X-WEBAUTH-GROUPS", "X-WEBAUTH-ROLE"} {
+				req.Header.Set(header, "admin")
+			}
+
+			resolver.(Sanitizer).Sanitize(req)
+
+			for _, header := range []string{"X-WEBAUTH-USER", "X-WEBAUTH-EMAIL", "X-WEBAUTH-GROUPS", "X-WEBAUTH-ROLE"} {
+				assert.Equal(t, tt.expected, req.Header.Get(header))
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package identity
+
+import (
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
+
+// chain resolves the identity of a request with the first resolver it carries the credentials of.
+type chain struct {
+	resolvers []Resolver
+}
+
+func NewChain(resolvers ...Resolver) Resolver {
+	return &chain{resolvers: resolvers}
+}
+
+func (c *chain) Credentials(req *http.Request) (*grafana.Credentials, error) {
+	_, creds, err := c.resolver(req)
+
+	return creds, err
+}
+
+func (c *chain) Resolve(req *http.Request) (*Identity, error) {
+	resolver, _, err := c.resolver(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolver.Resolve(req)
+}
+
+// Sanitize sanitizes the request with every resolver, whichever resolves its identity.
+func (c *chain) Sanitize(req *http.Request) {
+	for _, resolver := range c.resolvers {
+		if sanitizer, ok := resolver.(Sanitizer); ok {
+			sanitizer.Sanitize(req)
+		}
+	}
+}
+
+func (c *chain) resolver(req *http.Request) (Resolver, *grafana.Credentials, error) {
+	for _, resolver := range c.resolvers {
+		creds, err := resolver.Credentials(req)
+		if err == errors.ErrMissingCredentials {
+			continue
+		}
+
+		return resolver, creds, err
+	}
+
+	return nil, nil, errors.ErrMissingCredentials
+}
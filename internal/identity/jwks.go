@@ -0,0 +1,212 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// jwksFetchTimeout bounds the download of the keys from the JWKS url.
+const jwksFetchTimeout = 10 * time.Second
+
+// KeySet holds the public keys the JWTs are verified with, loaded from a JWKS file or url.
+type KeySet struct {
+	path       string
+	url        string
+	interval   time.Duration
+	httpClient *http.Client
+	logger     *log.Logger
+
+	mu   sync.RWMutex
+	keys []*publicKey
+}
+
+type KeySetDeps struct {
+	// Path is the JWKS file, used when URL is empty.
+	Path string
+	URL  string
+	// RefreshInterval is how often the keys are downloaded again from URL, so rotated keys are picked up.
+	RefreshInterval time.Duration
+	Logger          *log.Logger
+}
+
+type publicKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+func NewKeySet(deps *KeySetDeps) *KeySet {
+	return &KeySet{
+		path:       deps.Path,
+		url:        deps.URL,
+		interval:   deps.RefreshInterval,
+		httpClient: &http.Client{Timeout: jwksFetchTimeout},
+		logger:     deps.Logger,
+	}
+}
+
+// Load loads the keys from the JWKS file or url, the previous keys are kept when it fails.
+func (k *KeySet) Load(ctx context.Context) error {
+	data, err := k.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	k.logger.Debugw("loaded JWKS keys", "keys", len(keys))
+
+	return nil
+}
+
+// Run downloads the keys from the JWKS url every refresh interval until ctx is done.
+func (k *KeySet) Run(ctx context.Context) {
+	if k.url == "" || k.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Load(ctx); err != nil {
+				k.logger.Ctx(ctx).Errorw("failed to refresh JWKS keys", "url", k.url, "err", err)
+			}
+		}
+	}
+}
+
+// Keys returns the keys with the given kid, or every key when kid is empty.
+func (k *KeySet) Keys(kid string) []crypto.PublicKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var keys []crypto.PublicKey
+
+	for _, key := range k.keys {
+		if kid == "" || key.kid == kid {
+			keys = append(keys, key.key)
+		}
+	}
+
+	return keys
+}
+
+func (k *KeySet) read(ctx context.Context) ([]byte, error) {
+	if k.url == "" {
+		return os.ReadFile(k.path)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS, status: %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// parseJWKS returns the RSA and P-256 signing keys of the JWKS, other keys are ignored.
+func parseJWKS(data []byte) ([]*publicKey, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	var keys []*publicKey
+
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		switch {
+		case key.Kty == "RSA":
+			n, err := decodeBigInt(key.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RSA key %s: %w", key.Kid, err)
+			}
+
+			e, err := decodeBigInt(key.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid RSA key %s exponent", key.Kid)
+			}
+
+			keys = append(keys, &publicKey{kid: key.Kid, key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case key.Kty == "EC" && key.Crv == "P-256":
+			x, err := decodeBigInt(key.X)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EC key %s: %w", key.Kid, err)
+			}
+
+			y, err := decodeBigInt(key.Y)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EC key %s: %w", key.Kid, err)
+			}
+
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("invalid EC key %s, the point is not on the curve", key.Kid)
+			}
+
+			keys = append(keys, &publicKey{kid: key.Kid, key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}})
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,304 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// clockSkew is the leeway allowed when checking the exp and nbf claims.
+const clockSkew = 30 * time.Second
+
+// jwtResolver resolves the identity of the requests authenticated with a RS256 or ES256 JWT from its claims, without
+// calling grafana.
+type jwtResolver struct {
+	keys       *KeySet
+	header     string
+	issuer     string
+	audience   string
+	claims     Claims
+	orgID      int
+	groupTeams map[string]int
+	logger     *log.Logger
+	now        func() time.Time
+}
+
+// Claims are the names of the claims the identity is read from.
+type Claims struct {
+	// UserID is the claim holding the grafana user id, a non numeric value maps to the user id 0.
+	UserID string
+	// Login is the claim holding the grafana login of the user, the sub claim is used when it is missing.
+	Login string
+	Name  string
+	Email string
+	// Groups is the claim holding the groups of the user, as a list or a single string.
+	Groups string
+	// Role is the claim holding the role of the user in the organization, e.g. "Admin".
+	Role string
+	// Org is the claim holding the id of the grafana organization of the user.
+	Org string
+}
+
+type JWTDeps struct {
+	Keys *KeySet
+	// Header is the header the token is read from, the "Bearer " prefix is stripped from the Authorization header.
+	Header string
+	// Issuer and Audience are checked against the iss and aud claims when they are not empty.
+	Issuer   string
+	Audience string
+	Claims   Claims
+	// OrgID is the organization of the tokens without the Org claim. The X-Grafana-Org-Id header is never trusted,
+	// it isn't signed.
+	OrgID int
+	// GroupTeams maps the groups to the grafana team ids the policies are defined for, unmapped groups are passed to
+	// Giam with the team id 0.
+	GroupTeams map[string]int
+	Logger     *log.Logger
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func NewJWT(deps *JWTDeps) Resolver {
+	return &jwtResolver{
+		keys:       deps.Keys,
+		header:     deps.Header,
+		issuer:     deps.Issuer,
+		audience:   deps.Audience,
+		claims:     deps.Claims,
+		orgID:      deps.OrgID,
+		groupTeams: deps.GroupTeams,
+		logger:     deps.Logger,
+		now:        time.Now,
+	}
+}
+
+// Credentials replays the token to grafana, which must be configured to authenticate the same JWTs, in the
+// organization of its claims.
+func (r *jwtResolver) Credentials(req *http.Request) (*grafana.Credentials, error) {
+	token := r.token(req)
+	if token == "" {
+		return nil, errors.ErrMissingCredentials
+	}
+
+	claims, err := r.verify(token)
+	if err != nil {
+		r.logger.Ctx(req.Context()).Debugw("invalid JWT", "err", err)
+
+		return nil, errors.ErrInvalidToken
+	}
+
+	return &grafana.Credentials{Header: r.header, Value: req.Header.Get(r.header), OrgID: r.org(claims)}, nil
+}
+
+func (r *jwtResolver) Resolve(req *http.Request) (*Identity, error) {
+	token := r.token(req)
+	if token == "" {
+		return nil, errors.ErrMissingCredentials
+	}
+
+	claims, err := r.verify(token)
+	if err != nil {
+		r.logger.Ctx(req.Context()).Debugw("invalid JWT", "err", err)
+
+		return nil, errors.ErrInvalidToken
+	}
+
+	user := &grafana.User{
+		ID:    claimInt(claims[r.claims.UserID]),
+		Login: claimString(claims[r.claims.Login]),
+		Name:  claimString(claims[r.claims.Name]),
+		Email: claimString(claims[r.claims.Email]),
+	}
+
+	if user.Login == "" {
+		user.Login = claimString(claims["sub"])
+	}
+
+	if user.Name == "" {
+		user.Name = claimString(claims["sub"])
+	}
+
+	groups := claimStrings(claims[r.claims.Groups])
+	teams := make([]*grafana.Team, len(groups))
+
+	for i, group := range groups {
+		teams[i] = &grafana.Team{ID: r.groupTeams[group], Name: group}
+	}
+
+	return &Identity{User: user, Teams: teams, OrgID: r.org(claims), Role: claimString(claims[r.claims.Role])}, nil
+}
+
+// org returns the organization of the Org claim, or the configured one when the token doesn't carry it.
+func (r *jwtResolver) org(claims map[string]interface{}) int {
+	if r.claims.Org != "" {
+		if id := claimInt(claims[r.claims.Org]); id > 0 {
+			return id
+		}
+	}
+
+	return r.orgID
+}
+
+// token returns the JWT of the request, or an empty string when the request doesn't carry one.
+func (r *jwtResolver) token(req *http.Request) string {
+	token := req.Header.Get(r.header)
+
+	if strings.EqualFold(r.header, "Authorization") {
+		if len(token) < len("Bearer ") || !strings.EqualFold(token[:len("Bearer ")], "Bearer ") {
+			return ""
+		}
+
+		token = token[len("Bearer "):]
+	}
+
+	// Opaque tokens, such as grafana service account tokens, are left to the other resolvers.
+	if strings.Count(token, ".") != 2 {
+		return ""
+	}
+
+	return token
+}
+
+// verify checks the signature and the registered claims of the token, and returns its claims.
+func (r *jwtResolver) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if !r.verifySignature(header, digest[:], signature) {
+		return nil, fmt.Errorf("signature doesn't match any %s key with kid %q", header.Alg, header.Kid)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+
+	now := r.now()
+
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, fmt.Errorf("token is expired or has no exp claim")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+
+	if r.issuer != "" && claimString(claims["iss"]) != r.issuer {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+
+	if r.audience != "" && !contains(claimStrings(claims["aud"]), r.audience) {
+		return nil, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+
+	return claims, nil
+}
+
+func (r *jwtResolver) verifySignature(header jwtHeader, digest []byte, signature []byte) bool {
+	for _, key := range r.keys.Keys(header.Kid) {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if header.Alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, signature) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if header.Alg != "ES256" || len(signature) != 64 {
+				continue
+			}
+
+			rr := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+
+			if ecdsa.Verify(k, digest, rr, s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func claimString(claim interface{}) string {
+	s, _ := claim.(string)
+
+	return s
+}
+
+func claimInt(claim interface{}) int {
+	switch c := claim.(type) {
+	case float64:
+		return int(c)
+	case string:
+		id, _ := strconv.Atoi(c)
+
+		return id
+	default:
+		return 0
+	}
+}
+
+// claimStrings returns the strings of a claim which is either a string or a list of strings, such as aud.
+func claimStrings(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		values := make([]string, 0, len(c))
+
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	default:
+		return nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
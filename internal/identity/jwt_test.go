@@ -0,0 +1,268 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	input := encodeSegment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	input := encodeSegment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestJWT_Resolve(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"n":   encodeBigInt(rsaKey.N),
+				"e":   encodeBigInt(big.NewInt(int64(rsaKey.E))),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec",
+				"crv": "P-256",
+				"x":   encodeBigInt(ecKey.X),
+				"y":   encodeBigInt(ecKey.Y),
+			},
+		},
+	}
+	data, err := json.Marshal(jwks)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(jwksPath, data, 0o600))
+
+	keys := NewKeySet(&KeySetDeps{Path: jwksPath, Logger: log.New("FATAL")})
+	require.NoError(t, keys.Load(context.Background()))
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub":                "42",
+		"preferred_username": "jane",
+		"name":               "Jane",
+		"email":              "jane@example.com",
+		"groups":             []string{"payment", "oncall"},
+		"role":               "Admin",
+		"iss":                "https://idp.example.com",
+		"aud":                []string{"grafana"},
+		"exp":                now.Add(time.Hour).Unix(),
+	}
+
+	withClaim := func(name string, value interface{}) map[string]interface{} {
+		c := make(map[string]interface{}, len(claims))
+		for k, v := range claims {
+			c[k] = v
+		}
+
+		c[name] = value
+
+		return c
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		orgHeader     string
+		expectedOrgID int
+		expectedError error
+	}{
+		{
+			name:          "valid RS256 token",
+			authorization: "Bearer " + signRS256(t, rsaKey, "rsa", claims),
+			expectedOrgID: 1,
+		},
+		{
+			name:          "valid ES256 token",
+			authorization: "Bearer " + signES256(t, ecKey, "ec", claims),
+			expectedOrgID: 1,
+		},
+		{
+			name:          "token with an org claim",
+			authorization: "Bearer " + signRS256(t, rsaKey, "rsa", withClaim("org_id", 5)),
+			expectedOrgID: 5,
+		},
+		{
+			name:          "org header of a token without org claim",
+			authorization: "Bearer " + signRS256(t, rsaKey, "rsa", claims),
+			orgHeader:     "7",
+			expectedOrgID: 1,
+		},
+		{
+			name:          "org header of a token with an org claim",
+			authorization: "Bearer " + signRS256(t, rsaKey, "rsa", withClaim("org_id", 5)),
+			orgHeader:     "7",
+			expectedOrgID: 5,
+		},
+		{
+			name:          "token signed by an unknown key",
+			authorization: "Bearer " + signRS256(t, otherKey, "rsa", claims),
+			expectedError: errors.ErrInvalidToken,
+		},
+		{
+			name:          "expired token",
+			authorization: "Bearer " + signRS256(t, rsaKey, "rsa", withClaim("exp", now.Add(-time.Hour).Unix())),
+			expectedError: errors.ErrInvalidToken,
+		},
+		{
+			name:          "unexpected issuer",
+			authorization: "Bearer " + signRS256(t, rsaKey, "rsa", withClaim("iss", "https://evil.example.com")),
+			expectedError: errors.ErrInvalidToken,
+		},
+		{
+			name:          "unexpected audience",
+			authorization: "Bearer " + signES256(t, ecKey, "ec", withClaim("aud", "loki")),
+			expectedError: errors.ErrInvalidToken,
+		},
+		{
+			name:          "opaque token",
+			authorization: "Bearer glsa_token",
+			expectedError: errors.ErrMissingCredentials,
+		},
+		{
+			name:          "no token",
+			expectedError: errors.ErrMissingCredentials,
+		},
+	}
+
+	resolver := NewJWT(&JWTDeps{
+		Keys:     keys,
+		Header:   "Authorization",
+		Issuer:   "https://idp.example.com",
+		Audience: "grafana",
+		Claims: Claims{
+			UserID: "sub",
+			Login:  "preferred_username",
+			Name:   "name",
+			Email:  "email",
+			Groups: "groups",
+			Role:   "role",
+			Org:    "org_id",
+		},
+		OrgID:      1,
+		GroupTeams: map[string]int{"payment": 3},
+		Logger:     log.New("FATAL"),
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+			require.NoError(t, err)
+
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			if tt.orgHeader != "" {
+				req.Header.Set(grafana.OrgIDHeader, tt.orgHeader)
+			}
+
+			id, err := resolver.Resolve(req)
+			if tt.expectedError != nil {
+				assert.Equal(t, tt.expectedError, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, &grafana.User{ID: 42, Login: "jane", Name: "Jane", Email: "jane@example.com"}, id.User)
+			assert.Equal(t, []*grafana.Team{{ID: 3, Name: "payment"}, {ID: 0, Name: "oncall"}}, id.Teams)
+			assert.Equal(t, "Admin", id.Role)
+			assert.Equal(t, tt.expectedOrgID, id.OrgID)
+
+			creds, err := resolver.Credentials(req)
+			require.NoError(t, err)
+
+			expectedCreds := &grafana.Credentials{Header: "Authorization", Value: tt.authorization, OrgID: tt.expectedOrgID}
+			assert.Equal(t, expectedCreds, creds)
+		})
+	}
+}
+
+func TestChain_Resolve(t *testing.T) {
+	resolver := NewChain(
+		NewJWT(&JWTDeps{
+			Keys:   NewKeySet(&KeySetDeps{Logger: log.New("FATAL")}),
+			Header: "Authorization",
+			Logger: log.New("FATAL"),
+		}),
+		NewSession(&SessionDeps{
+			GrafanaRepo: &grafana.MockRepo{User: &grafana.User{ID: 1}, Teams: []*grafana.Team{{ID: 2}}},
+			Logger:      log.New("FATAL"),
+		}),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+	require.NoError(t, err)
+
+	_, err = resolver.Resolve(req)
+	assert.Equal(t, errors.ErrMissingCredentials, err)
+
+	req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "session"})
+
+	id, err := resolver.Resolve(req)
+	require.NoError(t, err)
+	assert.Equal(t, 1, id.User.ID)
+
+	creds, err := resolver.Credentials(req)
+	require.NoError(t, err)
+	assert.Equal(t, "session", creds.Session)
+}
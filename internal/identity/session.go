@@ -0,0 +1,103 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// sessionResolver resolves the grafana user of the grafana_session cookie, and their teams, from grafana.
+type sessionResolver struct {
+	grafanaRepo grafana.Repo
+	withRole    bool
+	logger      *log.Logger
+}
+
+type SessionDeps struct {
+	GrafanaRepo grafana.Repo
+	// WithRole also looks the role of the user in the organization up, at the cost of another call to grafana.
+	WithRole bool
+	Logger   *log.Logger
+}
+
+func NewSession(deps *SessionDeps) Resolver {
+	return &sessionResolver{grafanaRepo: deps.GrafanaRepo, withRole: deps.WithRole, logger: deps.Logger}
+}
+
+func (r *sessionResolver) Credentials(req *http.Request) (*grafana.Credentials, error) {
+	session, err := req.Cookie("grafana_session")
+	if err != nil {
+		return nil, errors.ErrMissingCredentials
+	}
+
+	return &grafana.Credentials{Session: session.Value, OrgID: orgID(req)}, nil
+}
+
+func (r *sessionResolver) Resolve(req *http.Request) (*Identity, error) {
+	creds, err := r.Credentials(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveFromGrafana(req.Context(), r.grafanaRepo, creds, r.withRole, r.logger)
+}
+
+// resolveFromGrafana looks the user authenticated by creds, and their teams, up in grafana. The teams are searched in
+// the organization selected by creds, or in the current organization of the user.
+func resolveFromGrafana(
+	ctx context.Context,
+	grafanaRepo grafana.Repo,
+	creds *grafana.Credentials,
+	withRole bool,
+	logger *log.Logger,
+) (*Identity, error) {
+	user, err := grafanaRepo.GetUser(ctx, creds)
+	if err != nil {
+		logger.Ctx(ctx).Debugw("user doesn't exist", "err", err)
+
+		return nil, err
+	}
+
+	if creds.OrgID == 0 && user.OrgID != 0 {
+		orgCreds := *creds
+		orgCreds.OrgID = user.OrgID
+		creds = &orgCreds
+	}
+
+	teams, err := grafanaRepo.GetUserTeams(ctx, creds, user.ID)
+	if err != nil {
+		logger.Ctx(ctx).Debugw("user doesn't have any team", "user_id", user.ID, "err", err)
+
+		return nil, errors.ErrUserDoesntHaveAnyTeamAssigned
+	}
+
+	id := &Identity{User: user, Teams: teams, OrgID: creds.OrgID}
+
+	if withRole {
+		id.Role = resolveRole(ctx, grafanaRepo, creds, logger)
+	}
+
+	return id, nil
+}
+
+// resolveRole returns the role of the user in the organization of creds. The role is only used to grant more access,
+// so a failed lookup leaves it empty rather than failing the request.
+func resolveRole(ctx context.Context, grafanaRepo grafana.Repo, creds *grafana.Credentials, logger *log.Logger) string {
+	orgs, err := grafanaRepo.GetUserOrgs(ctx, creds)
+	if err != nil {
+		logger.Ctx(ctx).Debugw("unable to fetch the user orgs", "err", err)
+
+		return ""
+	}
+
+	for _, org := range orgs {
+		if org.OrgID == creds.OrgID {
+			return org.Role
+		}
+	}
+
+	return ""
+}
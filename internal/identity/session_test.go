@@ -0,0 +1,78 @@
+package identity
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestSession_ResolveOrg(t *testing.T) {
+	tests := []struct {
+		name          string
+		orgHeader     string
+		expectedOrgID int
+	}{
+		{
+			name:          "organization selected by the request",
+			orgHeader:     "3",
+			expectedOrgID: 3,
+		},
+		{
+			name:          "current organization of the user",
+			expectedOrgID: 2,
+		},
+		{
+			name:          "invalid organization header",
+			orgHeader:     "payment",
+			expectedOrgID: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &credentialsRepo{MockRepo: grafana.MockRepo{
+				User:  &grafana.User{ID: 1, OrgID: 2},
+				Teams: []*grafana.Team{{ID: 5, OrgID: tt.expectedOrgID}},
+			}}
+
+			req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+			require.NoError(t, err)
+
+			req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "session"})
+
+			if tt.orgHeader != "" {
+				req.Header.Set(grafana.OrgIDHeader, tt.orgHeader)
+			}
+
+			id, err := NewSession(&SessionDeps{GrafanaRepo: repo, Logger: log.New("FATAL")}).Resolve(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOrgID, id.OrgID)
+			assert.Equal(t, tt.expectedOrgID, repo.teamsCreds.OrgID)
+		})
+	}
+}
+
+func TestSession_ResolveRole(t *testing.T) {
+	repo := &grafana.MockRepo{
+		User:  &grafana.User{ID: 1, OrgID: 2},
+		Teams: []*grafana.Team{{ID: 5}},
+		Orgs:  []*grafana.Org{{OrgID: 1, Role: "Viewer"}, {OrgID: 2, Role: "Admin"}},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+	require.NoError(t, err)
+
+	req.AddCookie(&http.Cookie{Name: "grafana_session", Value: "session"})
+
+	id, err := NewSession(&SessionDeps{GrafanaRepo: repo, Logger: log.New("FATAL")}).Resolve(req)
+	require.NoError(t, err)
+	assert.Equal(t, "", id.Role)
+
+	id, err = NewSession(&SessionDeps{GrafanaRepo: repo, WithRole: true, Logger: log.New("FATAL")}).Resolve(req)
+	require.NoError(t, err)
+	assert.Equal(t, "Admin", id.Role)
+}
@@ -0,0 +1,48 @@
+package identity
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// tokenResolver resolves the grafana user of a service account token (glsa_...) or of a legacy API key sent as a
+// bearer token, such as the ones of provisioning, reporting or alerting automations, and their teams, from grafana.
+type tokenResolver struct {
+	grafanaRepo grafana.Repo
+	withRole    bool
+	logger      *log.Logger
+}
+
+type TokenDeps struct {
+	GrafanaRepo grafana.Repo
+	// WithRole also looks the role of the user in the organization up, at the cost of another call to grafana.
+	WithRole bool
+	Logger   *log.Logger
+}
+
+func NewToken(deps *TokenDeps) Resolver {
+	return &tokenResolver{grafanaRepo: deps.GrafanaRepo, withRole: deps.WithRole, logger: deps.Logger}
+}
+
+func (r *tokenResolver) Credentials(req *http.Request) (*grafana.Credentials, error) {
+	authorization := req.Header.Get("Authorization")
+
+	if len(authorization) <= len("Bearer ") || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return nil, errors.ErrMissingCredentials
+	}
+
+	return &grafana.Credentials{Header: "Authorization", Value: authorization, OrgID: orgID(req)}, nil
+}
+
+func (r *tokenResolver) Resolve(req *http.Request) (*Identity, error) {
+	creds, err := r.Credentials(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveFromGrafana(req.Context(), r.grafanaRepo, creds, r.withRole, r.logger)
+}
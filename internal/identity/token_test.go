@@ -0,0 +1,88 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+// credentialsRepo records the credentials grafana is called with.
+type credentialsRepo struct {
+	grafana.MockRepo
+	creds      *grafana.Credentials
+	teamsCreds *grafana.Credentials
+}
+
+func (r *credentialsRepo) GetUser(ctx context.Context, creds *grafana.Credentials) (*grafana.User, error) {
+	r.creds = creds
+
+	return r.MockRepo.GetUser(ctx, creds)
+}
+
+func (r *credentialsRepo) GetUserTeams(
+	ctx context.Context,
+	creds *grafana.Credentials,
+	userID int,
+) ([]*grafana.Team, error) {
+	r.teamsCreds = creds
+
+	return r.MockRepo.GetUserTeams(ctx, creds, userID)
+}
+
+func TestToken_Resolve(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		expectedCreds *grafana.Credentials
+		expectedError error
+	}{
+		{
+			name:          "service account token",
+			authorization: "Bearer glsa_token",
+			expectedCreds: &grafana.Credentials{Header: "Authorization", Value: "Bearer glsa_token"},
+		},
+		{
+			name:          "basic auth",
+			authorization: "Basic dXNlcjpwYXNz",
+			expectedError: errors.ErrMissingCredentials,
+		},
+		{
+			name:          "no token",
+			expectedError: errors.ErrMissingCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &credentialsRepo{MockRepo: grafana.MockRepo{
+				User:  &grafana.User{ID: 7, Name: "sa-reporting"},
+				Teams: []*grafana.Team{{ID: 2, Name: "reporting"}},
+			}}
+
+			req, err := http.NewRequest(http.MethodPost, "/api/ds/query", nil)
+			require.NoError(t, err)
+
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			id, err := NewToken(&TokenDeps{GrafanaRepo: repo, Logger: log.New("FATAL")}).Resolve(req)
+			if tt.expectedError != nil {
+				assert.Equal(t, tt.expectedError, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedCreds, repo.creds)
+			assert.Equal(t, 7, id.User.ID)
+			assert.Equal(t, []*grafana.Team{{ID: 2, Name: "reporting"}}, id.Teams)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package identity
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
+
+// Providers the identity of a request can be resolved with.
+const (
+	ProviderGrafanaSession = "grafana_session"
+	ProviderGrafanaToken   = "grafana_token"
+	ProviderJWT            = "jwt"
+	ProviderAuthProxy      = "auth_proxy"
+)
+
+// Identity is the user a request is made on behalf of, along with the teams the policies are evaluated for.
+type Identity struct {
+	User  *grafana.User
+	Teams []*grafana.Team
+	// OrgID is the grafana organization the request is made in, 0 when it is unknown.
+	OrgID int
+	// Role is the role of the user in the organization, e.g. "Admin", empty when it is unknown.
+	Role string
+}
+
+// Resolver resolves the identity of the requests authenticated the way it supports.
+type Resolver interface {
+	// Credentials returns the credentials the calls made to grafana on behalf of the request are authenticated with,
+	// or errors.ErrMissingCredentials when the request isn't authenticated the way the resolver supports.
+	Credentials(req *http.Request) (*grafana.Credentials, error)
+	// Resolve returns the identity of the request.
+	Resolve(req *http.Request) (*Identity, error)
+}
+
+// Sanitizer is implemented by the resolvers trusting request headers from some sources only. Sanitize removes these
+// headers from the requests of the other sources, so they never reach grafana.
+type Sanitizer interface {
+	Sanitize(req *http.Request)
+}
+
+// orgID returns the organization the request selects with the X-Grafana-Org-Id header, 0 when it doesn't select any.
+func orgID(req *http.Request) int {
+	id, err := strconv.Atoi(req.Header.Get(grafana.OrgIDHeader))
+	if err != nil || id < 0 {
+		return 0
+	}
+
+	return id
+}
+
+// Status returns the message and status code of the response to a request whose identity failed to resolve with err.
+func Status(err error) (string, int) {
+	switch err {
+	case errors.ErrMissingCredentials:
+		return "Forbidden", http.StatusForbidden
+	case errors.ErrInvalidToken:
+		return "Invalid token", http.StatusUnauthorized
+	case errors.ErrUserDoesntHaveAnyTeamAssigned:
+		return "User not assigned to any team", http.StatusBadRequest
+	default:
+		return "User doesn't exits", http.StatusBadRequest
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/policy"
+	"github.com/usegiam/giam-traefik-plugin/pkg/giam"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+type service struct {
+	client *giam.Client
+	logger *log.Logger
+}
+
+type Deps struct {
+	Client *giam.Client
+	Logger *log.Logger
+}
+
+func New(deps *Deps) policy.Service {
+	return &service{client: deps.Client, logger: deps.Logger}
+}
+
+func (s *service) GetSnapshot(ctx context.Context) (*policy.Snapshot, error) {
+	resp, err := s.client.Do(ctx, &giam.Request{Method: http.MethodGet, Path: "/policies"})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Ctx(ctx).Debugw("giam policies resp", "status", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("giam policies resp status code: %v, resp body: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	var snapshot policy.Snapshot
+
+	err = json.Unmarshal(resp.Body, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode giam policies resp: %w", err)
+	}
+
+	return &snapshot, nil
+}
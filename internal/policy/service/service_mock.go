@@ -0,0 +1,16 @@
+package service
+
+import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/policy"
+)
+
+type Mock struct {
+	Error    error
+	Snapshot *policy.Snapshot
+}
+
+func (m *Mock) GetSnapshot(ctx context.Context) (*policy.Snapshot, error) {
+	return m.Snapshot, m.Error
+}
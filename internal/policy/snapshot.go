@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+)
+
+// compile validates the matchers of the snapshot and compiles their regular expressions.
+func (s *Snapshot) compile() error {
+	for _, team := range s.Teams {
+		for _, ds := range team.Datasources {
+			if err := label.Compile(ds.Matchers); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Matchers returns the label matchers the given teams are allowed to query the datasource with, and false when none
+// of the teams has a policy for the datasource.
+//
+// The policies of several teams are combined into a single regular expression when they all constrain the same label
+// with a single positive matcher, which selects exactly the union of the streams the teams can access. Any other
+// combination can't be expressed with selector matchers, so the policies are intersected instead: the user never
+// gets more than what each of its teams is allowed to.
+func (s *Snapshot) Matchers(teams []*grafana.Team, uid string) ([]*label.Matcher, bool) {
+	teamIDs := make(map[int]bool, len(teams))
+	for _, team := range teams {
+		teamIDs[team.ID] = true
+	}
+
+	var policies [][]*label.Matcher
+
+	for _, team := range s.Teams {
+		if !teamIDs[team.TeamID] {
+			continue
+		}
+
+		for _, ds := range team.Datasources {
+			if ds.UID != uid {
+				continue
+			}
+
+			// A team with unrestricted access to the datasource makes the union unrestricted.
+			if len(ds.Matchers) == 0 {
+				return nil, true
+			}
+
+			policies = append(policies, ds.Matchers)
+		}
+	}
+
+	switch len(policies) {
+	case 0:
+		return nil, false
+	case 1:
+		return policies[0], true
+	}
+
+	if union, ok := unionMatchers(policies); ok {
+		return []*label.Matcher{union}, true
+	}
+
+	var intersection []*label.Matcher
+	for _, matchers := range policies {
+		intersection = label.AppendMissing(intersection, matchers)
+	}
+
+	return intersection, true
+}
+
+func unionMatchers(policies [][]*label.Matcher) (*label.Matcher, bool) {
+	name := policies[0][0].Name
+	alternatives := make([]string, 0, len(policies))
+
+	for _, matchers := range policies {
+		if len(matchers) != 1 || matchers[0].Name != name {
+			return nil, false
+		}
+
+		switch m := matchers[0]; m.Type {
+		case label.MatchEqual:
+			alternatives = append(alternatives, regexp.QuoteMeta(m.Value))
+		case label.MatchRegexp:
+			alternatives = append(alternatives, "(?:"+m.Value+")")
+		default:
+			return nil, false
+		}
+	}
+
+	union, err := label.NewMatcher(label.MatchRegexp, name, strings.Join(alternatives, "|"))
+	if err != nil {
+		return nil, false
+	}
+
+	return union, true
+}
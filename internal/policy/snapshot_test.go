@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func mustMatcher(t *testing.T, matchType label.MatchType, name, value string) *label.Matcher {
+	t.Helper()
+
+	m, err := label.NewMatcher(matchType, name, value)
+	require.NoError(t, err)
+
+	return m
+}
+
+func TestSnapshot_Matchers(t *testing.T) {
+	snapshot := &Snapshot{
+		Teams: []*TeamPolicy{
+			{TeamID: 1, Datasources: []*DatasourcePolicy{
+				{UID: "prom", Matchers: []*label.Matcher{mustMatcher(t, label.MatchEqual, "team", "payment")}},
+				{UID: "loki", Matchers: []*label.Matcher{mustMatcher(t, label.MatchEqual, "team", "payment")}},
+			}},
+			{TeamID: 2, Datasources: []*DatasourcePolicy{
+				{UID: "prom", Matchers: []*label.Matcher{mustMatcher(t, label.MatchRegexp, "team", "menu|search")}},
+				{UID: "loki", Matchers: []*label.Matcher{mustMatcher(t, label.MatchNotEqual, "env", "prod")}},
+			}},
+			{TeamID: 3, Datasources: []*DatasourcePolicy{
+				{UID: "prom"},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		teams    []*grafana.Team
+		uid      string
+		expected []string
+		ok       bool
+	}{
+		{
+			name:     "it should return the matchers of a single team",
+			teams:    []*grafana.Team{{ID: 1}},
+			uid:      "prom",
+			expected: []string{`team="payment"`},
+			ok:       true,
+		},
+		{
+			name:     "it should merge the policies of several teams on the same label",
+			teams:    []*grafana.Team{{ID: 1}, {ID: 2}},
+			uid:      "prom",
+			expected: []string{`team=~"payment|(?:menu|search)"`},
+			ok:       true,
+		},
+		{
+			name:     "it should intersect the policies it cannot merge",
+			teams:    []*grafana.Team{{ID: 1}, {ID: 2}},
+			uid:      "loki",
+			expected: []string{`team="payment"`, `env!="prod"`},
+			ok:       true,
+		},
+		{
+			name:     "it should not restrict a team with unrestricted access",
+			teams:    []*grafana.Team{{ID: 1}, {ID: 3}},
+			uid:      "prom",
+			expected: []string{},
+			ok:       true,
+		},
+		{
+			name:     "it should not allow a datasource without policy",
+			teams:    []*grafana.Team{{ID: 3}, {ID: 4}},
+			uid:      "loki",
+			expected: []string{},
+			ok:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, ok := snapshot.Matchers(tt.teams, tt.uid)
+
+			actual := make([]string, len(matchers))
+			for i, m := range matchers {
+				actual[i] = m.String()
+			}
+
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// Store keeps the last good policy snapshot synced from Giam, so the policies can still be enforced while the Giam
+// API is unreachable.
+type Store struct {
+	mu       sync.RWMutex
+	snapshot *Snapshot
+	service  Service
+	path     string
+	interval time.Duration
+	logger   *log.Logger
+	now      func() time.Time
+}
+
+type StoreDeps struct {
+	Service Service
+	// Path is the file the last good snapshot is persisted to and loaded from on startup. An empty path keeps the
+	// snapshot in memory only.
+	Path string
+	// Interval is how often the snapshot is synced from Giam.
+	Interval time.Duration
+	Logger   *log.Logger
+}
+
+func NewStore(deps *StoreDeps) *Store {
+	return &Store{
+		service:  deps.Service,
+		path:     deps.Path,
+		interval: deps.Interval,
+		logger:   deps.Logger,
+		now:      time.Now,
+	}
+}
+
+// Load loads the snapshot persisted by a previous sync. A missing file is not an error.
+func (s *Store) Load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return fmt.Errorf("failed to decode policy snapshot %s: %w", s.path, err)
+	}
+
+	if err := snapshot.compile(); err != nil {
+		return fmt.Errorf("invalid policy snapshot %s: %w", s.path, err)
+	}
+
+	s.set(&snapshot)
+
+	s.logger.Debugw("loaded policy snapshot", "version", snapshot.Version, "synced_at", snapshot.SyncedAt)
+
+	return nil
+}
+
+// Sync fetches the snapshot from Giam and persists it. The current snapshot is kept when the new one is invalid.
+func (s *Store) Sync(ctx context.Context) error {
+	snapshot, err := s.service.GetSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := snapshot.compile(); err != nil {
+		return fmt.Errorf("invalid giam policy snapshot: %w", err)
+	}
+
+	snapshot.SyncedAt = s.now()
+
+	s.set(snapshot)
+
+	s.logger.Ctx(ctx).Debugw("synced policy snapshot", "version", snapshot.Version)
+
+	return s.persist(snapshot)
+}
+
+// Run syncs the snapshot every interval until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(ctx); err != nil {
+			s.logger.Ctx(ctx).Errorw("failed to sync policy snapshot", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Matchers returns the label matchers the teams are allowed to query the datasource with, according to the last
+// synced snapshot.
+func (s *Store) Matchers(teams []*grafana.Team, uid string) ([]*label.Matcher, error) {
+	s.mu.RLock()
+	snapshot := s.snapshot
+	s.mu.RUnlock()
+
+	if snapshot == nil {
+		return nil, errors.ErrPolicySnapshotUnavailable
+	}
+
+	matchers, ok := snapshot.Matchers(teams, uid)
+	if !ok {
+		return nil, errors.ErrNoPolicy
+	}
+
+	return matchers, nil
+}
+
+func (s *Store) set(snapshot *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot = snapshot
+}
+
+// persist writes the snapshot to a temporary file first, so a crash never leaves a truncated snapshot behind.
+func (s *Store) persist(snapshot *Snapshot) error {
+	if s.path == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to persist policy snapshot: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to persist policy snapshot: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+	giamerrors "github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+type stubService struct {
+	snapshot *Snapshot
+	err      error
+}
+
+func (s *stubService) GetSnapshot(ctx context.Context) (*Snapshot, error) {
+	return s.snapshot, s.err
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	svc := &stubService{snapshot: &Snapshot{
+		Version: "v1",
+		Teams: []*TeamPolicy{
+			{TeamID: 1, Datasources: []*DatasourcePolicy{
+				{UID: "prom", Matchers: []*label.Matcher{{Name: "team", Type: label.MatchRegexp, Value: "payment|menu"}}},
+			}},
+		},
+	}}
+	teams := []*grafana.Team{{ID: 1, Name: "team1"}}
+
+	store := NewStore(&StoreDeps{Service: svc, Path: path, Logger: log.New("FATAL")})
+
+	_, err := store.Matchers(teams, "prom")
+	assert.Equal(t, giamerrors.ErrPolicySnapshotUnavailable, err)
+
+	require.NoError(t, store.Sync(context.Background()))
+
+	matchers, err := store.Matchers(teams, "prom")
+	require.NoError(t, err)
+	assert.True(t, matchers[0].Matches("menu"))
+
+	_, err = store.Matchers(teams, "loki")
+	assert.Equal(t, giamerrors.ErrNoPolicy, err)
+
+	// a failed sync keeps the last good snapshot
+	svc.err = errors.New("giam is down")
+	assert.Error(t, store.Sync(context.Background()))
+
+	_, err = store.Matchers(teams, "prom")
+	require.NoError(t, err)
+
+	// a restarted plugin enforces the persisted snapshot before its first sync
+	restarted := NewStore(&StoreDeps{Service: svc, Path: path, Logger: log.New("FATAL")})
+	require.NoError(t, restarted.Load())
+
+	matchers, err = restarted.Matchers(teams, "prom")
+	require.NoError(t, err)
+	assert.True(t, matchers[0].Matches("payment"))
+	assert.False(t, matchers[0].Matches("search"))
+}
+
+func TestStore_LoadMissingFile(t *testing.T) {
+	store := NewStore(&StoreDeps{
+		Service: &stubService{},
+		Path:    filepath.Join(t.TempDir(), "policies.json"),
+		Logger:  log.New("FATAL"),
+	})
+
+	assert.NoError(t, store.Load())
+}
@@ -0,0 +1,31 @@
+package policy
+
+import (
+	"context"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/label"
+)
+
+type Service interface {
+	GetSnapshot(ctx context.Context) (*Snapshot, error)
+}
+
+// Snapshot is the full LBAC policy set of the organization: the label rules of every team, per datasource.
+type Snapshot struct {
+	Version  string        `json:"version"`
+	Teams    []*TeamPolicy `json:"teams"`
+	SyncedAt time.Time     `json:"synced_at"`
+}
+
+type TeamPolicy struct {
+	TeamID      int                 `json:"team_id"`
+	Datasources []*DatasourcePolicy `json:"datasources"`
+}
+
+// DatasourcePolicy holds the label matchers every selector of the team queries to the datasource is constrained with.
+// A policy without matchers grants access to the whole datasource.
+type DatasourcePolicy struct {
+	UID      string           `json:"uid"`
+	Matchers []*label.Matcher `json:"matchers"`
+}
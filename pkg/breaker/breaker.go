@@ -0,0 +1,197 @@
+package breaker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// StateClosed lets every call through while counting the failed ones.
+	StateClosed State = iota
+	// StateOpen rejects every call until the cool-down elapsed.
+	StateOpen
+	// StateHalfOpen lets a single trial call through, which closes the breaker when it succeeds and opens it again
+	// otherwise.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker stops calling an upstream whose error rate crossed a threshold, so a degraded upstream isn't flooded with
+// calls and callers fail immediately instead of waiting for their timeouts. A nil Breaker lets every call through.
+type Breaker struct {
+	name        string
+	threshold   float64
+	minRequests int
+	window      time.Duration
+	coolDown    time.Duration
+	logger      *log.Logger
+	now         func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+type Options struct {
+	// Name identifies the upstream in the logs, e.g. "giam".
+	Name string
+	// FailureRate is the ratio of failed calls, between 0 and 1, which opens the breaker.
+	FailureRate float64
+	// MinRequests is the number of calls of a window below which the breaker doesn't open, whatever the failure rate.
+	MinRequests int
+	// Window is the period the calls are counted over, the counters are reset when it elapsed.
+	Window time.Duration
+	// CoolDown is how long the breaker stays open before letting a trial call through.
+	CoolDown time.Duration
+	Logger   *log.Logger
+}
+
+func New(opts *Options) *Breaker {
+	return &Breaker{
+		name:        opts.Name,
+		threshold:   opts.FailureRate,
+		minRequests: opts.MinRequests,
+		window:      opts.Window,
+		coolDown:    opts.CoolDown,
+		logger:      opts.Logger,
+		now:         time.Now,
+	}
+}
+
+// Allow returns errors.ErrCircuitOpen when the call must not be made. Every allowed call must be followed by a call to
+// Record or Ignore.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.coolDown {
+			return errors.ErrCircuitOpen
+		}
+
+		b.setState(StateHalfOpen)
+		b.probing = true
+
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			return errors.ErrCircuitOpen
+		}
+
+		b.probing = true
+
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record records the outcome of a call allowed by Allow.
+func (b *Breaker) Record(success bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateHalfOpen:
+		b.probing = false
+
+		if success {
+			b.setState(StateClosed)
+		} else {
+			b.open("the trial call failed")
+		}
+	case StateClosed:
+		now := b.now()
+		if now.Sub(b.windowStart) >= b.window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+
+		b.requests++
+
+		if success {
+			return
+		}
+
+		b.failures++
+
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.threshold {
+			b.open(fmt.Sprintf("%d of %d calls failed", b.failures, b.requests))
+		}
+	}
+}
+
+// Ignore releases a call allowed by Allow without recording its outcome, e.g. when it was cancelled by its caller.
+func (b *Breaker) Ignore() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateHalfOpen {
+		b.probing = false
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	if b == nil {
+		return StateClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) open(reason string) {
+	b.logger.Errorw("circuit breaker open", "breaker", b.name, "cool_down", b.coolDown.String(), "reason", reason)
+
+	b.openedAt = b.now()
+	b.setState(StateOpen)
+}
+
+func (b *Breaker) setState(state State) {
+	if state != StateOpen {
+		b.logger.Infow("circuit breaker state changed", "breaker", b.name, "state", state.String())
+	}
+
+	b.state = state
+	b.windowStart = b.now()
+	b.requests = 0
+	b.failures = 0
+}
@@ -0,0 +1,120 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+func newTestBreaker(now *time.Time) *Breaker {
+	b := New(&Options{
+		Name:        "test",
+		FailureRate: 0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		CoolDown:    10 * time.Second,
+		Logger:      log.New("FATAL"),
+	})
+	b.now = func() time.Time { return *now }
+
+	return b
+}
+
+func call(b *Breaker, success bool) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+
+	b.Record(success)
+
+	return nil
+}
+
+func TestBreaker_Opens(t *testing.T) {
+	tests := []struct {
+		name      string
+		outcomes  []bool
+		wantState State
+	}{
+		{
+			name:      "below min requests",
+			outcomes:  []bool{false, false, false},
+			wantState: StateClosed,
+		},
+		{
+			name:      "below failure rate",
+			outcomes:  []bool{true, true, false, true, false, true},
+			wantState: StateClosed,
+		},
+		{
+			name:      "failure rate reached",
+			outcomes:  []bool{true, false, true, false},
+			wantState: StateOpen,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			b := newTestBreaker(&now)
+
+			for _, success := range tt.outcomes {
+				assert.NoError(t, call(b, success))
+			}
+
+			assert.Equal(t, tt.wantState, b.State())
+		})
+	}
+}
+
+func TestBreaker_WindowReset(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(&now)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, call(b, false))
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	assert.NoError(t, call(b, false))
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(&now)
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, call(b, false))
+	}
+
+	assert.Equal(t, errors.ErrCircuitOpen, b.Allow())
+
+	now = now.Add(10 * time.Second)
+
+	// A single trial call is let through once the cool-down elapsed.
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.Equal(t, errors.ErrCircuitOpen, b.Allow())
+
+	b.Record(false)
+	assert.Equal(t, StateOpen, b.State())
+	assert.Equal(t, errors.ErrCircuitOpen, b.Allow())
+
+	now = now.Add(10 * time.Second)
+
+	assert.NoError(t, call(b, true))
+	assert.Equal(t, StateClosed, b.State())
+	assert.NoError(t, b.Allow())
+}
+
+func TestBreaker_Nil(t *testing.T) {
+	var b *Breaker
+
+	assert.NoError(t, call(b, false))
+	assert.Equal(t, StateClosed, b.State())
+}
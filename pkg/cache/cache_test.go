@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+func TestCache_GetSet(t *testing.T) {
+	c := New(0, time.Minute)
+
+	_, ok := c.Get("foo")
+	assert.False(t, ok)
+
+	c.Set("foo", "bar")
+
+	value, ok := c.Get("foo")
+	assert.True(t, ok)
+	assert.Equal(t, "bar", value)
+
+	c.Delete("foo")
+
+	_, ok = c.Get("foo")
+	assert.False(t, ok)
+}
+
+func TestCache_Expiration(t *testing.T) {
+	now := time.Now()
+
+	c := New(0, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("foo", "bar")
+	c.SetWithTTL("short", "lived", time.Second)
+
+	now = now.Add(2 * time.Second)
+
+	_, ok := c.Get("short")
+	assert.False(t, ok)
+
+	_, ok = c.Get("foo")
+	assert.True(t, ok)
+
+	now = now.Add(time.Minute)
+
+	_, ok = c.Get("foo")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestCache_LRUEviction(t *testing.T) {
+	c := New(2, 0)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	// Touch "a" so "b" becomes the least recently used entry.
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	c.Set("c", 3)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.Len())
+}
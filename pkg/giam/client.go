@@ -0,0 +1,223 @@
+package giam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/breaker"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+	"github.com/usegiam/giam-traefik-plugin/pkg/requestid"
+)
+
+// Client sends the requests of every Giam service through a single pooled http client, retrying the idempotent
+// requests which failed because of a connection error or a 5xx response.
+type Client struct {
+	apiUrl     string
+	apiKey     string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *breaker.Breaker
+	upstream   *metrics.Upstream
+	logger     *log.Logger
+	// sleep waits for d unless ctx is done first, it is replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+type Options struct {
+	APIUrl string
+	APIKey string
+	// DialTimeout bounds the establishment of a connection, including the TLS handshake.
+	DialTimeout time.Duration
+	// ResponseTimeout bounds every attempt, from sending the request to reading the whole response body.
+	ResponseTimeout time.Duration
+	// MaxRetries is the number of attempts made after the first one failed.
+	MaxRetries int
+	// RetryBackoff is the base of the exponential backoff between attempts, MaxRetryBackoff caps it.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Breaker fails the requests immediately while Giam keeps failing, nil disables it.
+	Breaker *breaker.Breaker
+	// Metrics records the latency and the failures of the requests by path, nil disables it.
+	Metrics *metrics.Registry
+	Logger  *log.Logger
+}
+
+// Request is a request to the Giam API. Payload is sent as JSON when it is not nil.
+type Request struct {
+	Method  string
+	Path    string
+	Payload interface{}
+	// Idempotent allows the retry of requests other than GET, such as the authorize and filter calls which don't
+	// change any state in Giam.
+	Idempotent bool
+}
+
+type Response struct {
+	StatusCode int
+	Body       []byte
+}
+
+func NewClient(opts *Options) *Client {
+	dialer := &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 30 * time.Second}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: opts.DialTimeout,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return &Client{
+		apiUrl:     opts.APIUrl,
+		apiKey:     opts.APIKey,
+		httpClient: &http.Client{Transport: transport, Timeout: opts.ResponseTimeout},
+		maxRetries: opts.MaxRetries,
+		backoff:    opts.RetryBackoff,
+		maxBackoff: opts.MaxRetryBackoff,
+		breaker:    opts.Breaker,
+		upstream:   metrics.NewUpstream(opts.Metrics, "giam"),
+		logger:     opts.Logger,
+		sleep:      sleep,
+	}
+}
+
+// Do sends the request and returns the response, whatever its status code below 500. A server error still answered once
+// the retries are exhausted fails with errors.ErrGiamServer, so the callers handle it as Giam being unreachable. The
+// request is abandoned as soon as ctx is done, including while waiting before a retry. It fails with
+// errors.ErrCircuitOpen without calling Giam while the breaker is open.
+func (c *Client) Do(ctx context.Context, r *Request) (*Response, error) {
+	var body []byte
+
+	if r.Payload != nil {
+		var err error
+		if body, err = json.Marshal(r.Payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := c.doWithRetries(ctx, r, body)
+	c.upstream.Observe(r.Path, start, shouldRetry(resp, err))
+
+	// A request cancelled by its caller says nothing about the health of Giam.
+	if ctx.Err() != nil {
+		c.breaker.Ignore()
+	} else {
+		c.breaker.Record(!shouldRetry(resp, err))
+	}
+
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: status code %d, body: %s", errors.ErrGiamServer, resp.StatusCode, string(resp.Body))
+	}
+
+	return resp, err
+}
+
+func (c *Client) doWithRetries(ctx context.Context, r *Request, body []byte) (*Response, error) {
+	retryable := r.Idempotent || r.Method == http.MethodGet
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, r, body)
+
+		if !retryable || attempt >= c.maxRetries || ctx.Err() != nil || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := c.backoffDelay(attempt)
+
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+
+		c.logger.Ctx(ctx).Debugw("giam request failed, retrying",
+			"method", r.Method, "path", r.Path, "status", statusCode, "delay", delay.String(), "err", err)
+
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, r *Request, body []byte) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, c.apiUrl+r.Path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	req.Header.Set("X-API-Key", c.apiKey)
+
+	if id := requestid.FromContext(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Body: respBody}, nil
+}
+
+// shouldRetry reports whether the attempt failed because of a connection error or a server error.
+func shouldRetry(resp *Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// backoffDelay returns an exponential backoff with full jitter, so the plugin instances don't retry in lockstep.
+func (c *Client) backoffDelay(attempt int) time.Duration {
+	delay := c.backoff << uint(attempt)
+	if delay <= 0 || (c.maxBackoff > 0 && delay > c.maxBackoff) {
+		delay = c.maxBackoff
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package giam
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	giamerrors "github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func newTestClient(url string) *Client {
+	c := NewClient(&Options{
+		APIUrl:          url,
+		APIKey:          "key",
+		DialTimeout:     time.Second,
+		ResponseTimeout: time.Second,
+		MaxRetries:      2,
+		RetryBackoff:    time.Millisecond,
+		MaxRetryBackoff: time.Millisecond,
+		Logger:          log.New("ERROR"),
+	})
+	c.sleep = func(ctx context.Context, _ time.Duration) error { return ctx.Err() }
+
+	return c
+}
+
+func TestClient_Do(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        *Request
+		statusCodes    []int
+		wantStatusCode int
+		wantErr        bool
+		wantAttempts   int
+	}{
+		{
+			name:           "success",
+			request:        &Request{Method: http.MethodPost, Path: "/authorize", Payload: "{}", Idempotent: true},
+			statusCodes:    []int{http.StatusOK},
+			wantStatusCode: http.StatusOK,
+			wantAttempts:   1,
+		},
+		{
+			name:           "idempotent request retried on server error",
+			request:        &Request{Method: http.MethodPost, Path: "/authorize", Payload: "{}", Idempotent: true},
+			statusCodes:    []int{http.StatusBadGateway, http.StatusOK},
+			wantStatusCode: http.StatusOK,
+			wantAttempts:   2,
+		},
+		{
+			name:         "get request retried until max retries",
+			request:      &Request{Method: http.MethodGet, Path: "/policies"},
+			statusCodes:  []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			wantErr:      true,
+			wantAttempts: 3,
+		},
+		{
+			name:         "non idempotent request not retried",
+			request:      &Request{Method: http.MethodPost, Path: "/authorize", Payload: "{}"},
+			statusCodes:  []int{http.StatusBadGateway, http.StatusOK},
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:           "client error not retried",
+			request:        &Request{Method: http.MethodGet, Path: "/policies"},
+			statusCodes:    []int{http.StatusForbidden, http.StatusOK},
+			wantStatusCode: http.StatusForbidden,
+			wantAttempts:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "key", r.Header.Get("X-API-Key"))
+				assert.Equal(t, tt.request.Path, r.URL.Path)
+
+				w.WriteHeader(tt.statusCodes[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			resp, err := newTestClient(server.URL).Do(context.Background(), tt.request)
+			assert.Equal(t, tt.wantAttempts, attempts)
+
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, giamerrors.ErrGiamServer))
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatusCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestClient_DoCancelled(t *testing.T) {
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := newTestClient(server.URL)
+	// The incoming request is cancelled while the client waits before retrying.
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+
+		return sleep(ctx, d)
+	}
+
+	_, err := c.Do(ctx, &Request{Method: http.MethodGet, Path: "/policies"})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+}
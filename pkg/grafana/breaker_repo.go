@@ -0,0 +1,82 @@
+package grafana
+
+import (
+	"context"
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/breaker"
+)
+
+type breakerRepo struct {
+	repo    Repo
+	breaker *breaker.Breaker
+}
+
+// NewBreakerRepo wraps the given repo so its lookups fail immediately with errors.ErrCircuitOpen while grafana keeps
+// failing.
+func NewBreakerRepo(repo Repo, b *breaker.Breaker) Repo {
+	return &breakerRepo{repo: repo, breaker: b}
+}
+
+func (r *breakerRepo) GetUser(ctx context.Context, creds *Credentials) (*User, error) {
+	if err := r.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	user, err := r.repo.GetUser(ctx, creds)
+	r.record(ctx, err)
+
+	return user, err
+}
+
+func (r *breakerRepo) GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error) {
+	if err := r.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	teams, err := r.repo.GetUserTeams(ctx, creds, userID)
+	r.record(ctx, err)
+
+	return teams, err
+}
+
+func (r *breakerRepo) GetUserOrgs(ctx context.Context, creds *Credentials) ([]*Org, error) {
+	if err := r.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	orgs, err := r.repo.GetUserOrgs(ctx, creds)
+	r.record(ctx, err)
+
+	return orgs, err
+}
+
+func (r *breakerRepo) GetDatasource(ctx context.Context, creds *Credentials, uid string) (*Datasource, error) {
+	if err := r.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	datasource, err := r.repo.GetDatasource(ctx, creds, uid)
+	r.record(ctx, err)
+
+	return datasource, err
+}
+
+// record records the outcome of a lookup. A lookup cancelled by its caller says nothing about the health of grafana.
+func (r *breakerRepo) record(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		r.breaker.Ignore()
+
+		return
+	}
+
+	r.breaker.Record(isAnswer(err))
+}
+
+// isAnswer reports whether grafana answered the lookup. A rejected session or an unknown datasource is a valid answer,
+// while transport errors and 5xx responses are failures of grafana.
+func isAnswer(err error) bool {
+	return err == nil ||
+		err == errors.ErrFailedtoCommunicateWithGrafana ||
+		err == errors.ErrUserDoesntHaveAnyTeamAssigned ||
+		err == errors.ErrDatasourceNotFound
+}
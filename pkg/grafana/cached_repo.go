@@ -0,0 +1,147 @@
+package grafana
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/cache"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+)
+
+// CacheOptions configures the caching decorator of the Repo.
+type CacheOptions struct {
+	// TTL is how long a successful lookup is kept.
+	TTL time.Duration
+	// NegativeTTL is how long a session rejected by grafana is kept, zero disables negative caching.
+	NegativeTTL time.Duration
+	// MaxEntries bounds the number of cached lookups, the least recently used ones are evicted first.
+	MaxEntries int
+	// Metrics records the hits and misses of the cache, nil disables it.
+	Metrics *metrics.Registry
+}
+
+type cachedRepo struct {
+	repo        Repo
+	entries     *cache.Cache
+	negativeTTL time.Duration
+	metrics     *metrics.Cache
+	logger      *log.Logger
+}
+
+type cachedResult struct {
+	value interface{}
+	err   error
+}
+
+// NewCachedRepo wraps the given repo so user and team lookups are cached per credentials.
+func NewCachedRepo(repo Repo, opts *CacheOptions, logger *log.Logger) Repo {
+	return &cachedRepo{
+		repo:        repo,
+		entries:     cache.New(opts.MaxEntries, opts.TTL),
+		negativeTTL: opts.NegativeTTL,
+		metrics:     metrics.NewCache(opts.Metrics, "grafana"),
+		logger:      logger,
+	}
+}
+
+func (r *cachedRepo) GetUser(ctx context.Context, creds *Credentials) (*User, error) {
+	key := "user:" + creds.key()
+
+	if result, ok := r.lookup(ctx, "user", key); ok {
+		if result.err != nil {
+			return nil, result.err
+		}
+
+		return result.value.(*User), nil
+	}
+
+	user, err := r.repo.GetUser(ctx, creds)
+	if err != nil {
+		r.storeError(key, err, errors.ErrFailedtoCommunicateWithGrafana)
+
+		return nil, err
+	}
+
+	r.entries.Set(key, &cachedResult{value: user})
+
+	return user, nil
+}
+
+func (r *cachedRepo) GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error) {
+	key := "teams:" + strconv.Itoa(userID) + ":" + creds.key()
+
+	if result, ok := r.lookup(ctx, "teams", key); ok {
+		if result.err != nil {
+			return nil, result.err
+		}
+
+		return result.value.([]*Team), nil
+	}
+
+	teams, err := r.repo.GetUserTeams(ctx, creds, userID)
+	if err != nil {
+		r.storeError(key, err, errors.ErrUserDoesntHaveAnyTeamAssigned)
+
+		return nil, err
+	}
+
+	r.entries.Set(key, &cachedResult{value: teams})
+
+	return teams, nil
+}
+
+func (r *cachedRepo) GetUserOrgs(ctx context.Context, creds *Credentials) ([]*Org, error) {
+	key := "orgs:" + creds.key()
+
+	if result, ok := r.lookup(ctx, "orgs", key); ok {
+		if result.err != nil {
+			return nil, result.err
+		}
+
+		return result.value.([]*Org), nil
+	}
+
+	orgs, err := r.repo.GetUserOrgs(ctx, creds)
+	if err != nil {
+		r.storeError(key, err, errors.ErrFailedtoCommunicateWithGrafana)
+
+		return nil, err
+	}
+
+	r.entries.Set(key, &cachedResult{value: orgs})
+
+	return orgs, nil
+}
+
+// GetDatasource is not cached here since datasources are shared by every session, datasource.Resolver caches them
+// by uid instead.
+func (r *cachedRepo) GetDatasource(ctx context.Context, creds *Credentials, uid string) (*Datasource, error) {
+	return r.repo.GetDatasource(ctx, creds, uid)
+}
+
+// lookup returns the cached result of the lookup of kind. The key embeds the session credentials, it is never logged.
+func (r *cachedRepo) lookup(ctx context.Context, kind string, key string) (*cachedResult, bool) {
+	value, ok := r.entries.Get(key)
+	r.metrics.Record(ok)
+
+	if !ok {
+		return nil, false
+	}
+
+	r.logger.Ctx(ctx).Debugw("grafana lookup served from cache", "lookup", kind)
+
+	return value.(*cachedResult), true
+}
+
+// storeError caches err only when it is the rejection error returned by grafana for the session, transport errors
+// are not cached so the next request tries again.
+func (r *cachedRepo) storeError(key string, err, rejection error) {
+	if r.negativeTTL <= 0 || err != rejection {
+		return
+	}
+
+	r.entries.SetWithTTL(key, &cachedResult{err: err}, r.negativeTTL)
+}
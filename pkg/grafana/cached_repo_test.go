@@ -0,0 +1,102 @@
+package grafana
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+type countingRepo struct {
+	MockRepo
+	userCalls  int
+	teamsCalls int
+}
+
+func (c *countingRepo) GetUser(ctx context.Context, creds *Credentials) (*User, error) {
+	c.userCalls++
+
+	return c.MockRepo.GetUser(ctx, creds)
+}
+
+func (c *countingRepo) GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error) {
+	c.teamsCalls++
+
+	return c.MockRepo.GetUserTeams(ctx, creds, userID)
+}
+
+func TestCachedRepo_CachesLookupsPerSession(t *testing.T) {
+	inner := &countingRepo{
+		MockRepo: MockRepo{
+			User:  &User{ID: 1, Name: "user1"},
+			Teams: []*Team{{ID: 1, Name: "team1"}},
+		},
+	}
+
+	repo := NewCachedRepo(inner, &CacheOptions{TTL: time.Minute, MaxEntries: 10}, log.New("FATAL"))
+
+	for i := 0; i < 3; i++ {
+		user, err := repo.GetUser(context.Background(), &Credentials{Session: "session1"})
+		assert.NoError(t, err)
+		assert.Equal(t, inner.User, user)
+
+		teams, err := repo.GetUserTeams(context.Background(), &Credentials{Session: "session1"}, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, inner.Teams, teams)
+	}
+
+	assert.Equal(t, 1, inner.userCalls)
+	assert.Equal(t, 1, inner.teamsCalls)
+
+	_, err := repo.GetUser(context.Background(), &Credentials{Session: "session2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inner.userCalls)
+}
+
+func TestCachedRepo_NegativeCaching(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		negativeTTL   time.Duration
+		expectedCalls int
+	}{
+		{
+			name:          "it should cache sessions rejected by grafana",
+			err:           errors.ErrFailedtoCommunicateWithGrafana,
+			negativeTTL:   time.Minute,
+			expectedCalls: 1,
+		},
+		{
+			name:          "it should not cache rejected sessions when negative caching is disabled",
+			err:           errors.ErrFailedtoCommunicateWithGrafana,
+			expectedCalls: 2,
+		},
+		{
+			name:          "it should not cache transport errors",
+			err:           errors.ErrUnauthorized,
+			negativeTTL:   time.Minute,
+			expectedCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &countingRepo{MockRepo: MockRepo{Err: tt.err}}
+			repo := NewCachedRepo(inner, &CacheOptions{
+				TTL:         time.Minute,
+				NegativeTTL: tt.negativeTTL,
+				MaxEntries:  10,
+			}, log.New("FATAL"))
+
+			for i := 0; i < 2; i++ {
+				_, err := repo.GetUser(context.Background(), &Credentials{Session: "session"})
+				assert.Equal(t, tt.err, err)
+			}
+
+			assert.Equal(t, tt.expectedCalls, inner.userCalls)
+		})
+	}
+}
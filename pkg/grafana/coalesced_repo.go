@@ -0,0 +1,67 @@
+package grafana
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/singleflight"
+)
+
+type coalescedRepo struct {
+	repo  Repo
+	calls singleflight.Group
+}
+
+// NewCoalescedRepo wraps the given repo so the concurrent identical lookups, such as the ones of the panels of a
+// dashboard being loaded, share a single request to grafana.
+func NewCoalescedRepo(repo Repo) Repo {
+	return &coalescedRepo{repo: repo}
+}
+
+func (r *coalescedRepo) GetUser(ctx context.Context, creds *Credentials) (*User, error) {
+	user, err := r.calls.DoContext(ctx, "user:"+creds.key(), func(ctx context.Context) (interface{}, error) {
+		return r.repo.GetUser(ctx, creds)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user.(*User), nil
+}
+
+func (r *coalescedRepo) GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error) {
+	key := "teams:" + strconv.Itoa(userID) + ":" + creds.key()
+
+	teams, err := r.calls.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return r.repo.GetUserTeams(ctx, creds, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return teams.([]*Team), nil
+}
+
+func (r *coalescedRepo) GetUserOrgs(ctx context.Context, creds *Credentials) ([]*Org, error) {
+	orgs, err := r.calls.DoContext(ctx, "orgs:"+creds.key(), func(ctx context.Context) (interface{}, error) {
+		return r.repo.GetUserOrgs(ctx, creds)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return orgs.([]*Org), nil
+}
+
+func (r *coalescedRepo) GetDatasource(ctx context.Context, creds *Credentials, uid string) (*Datasource, error) {
+	key := "datasource:" + uid + ":" + creds.key()
+
+	datasource, err := r.calls.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return r.repo.GetDatasource(ctx, creds, uid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return datasource.(*Datasource), nil
+}
@@ -0,0 +1,55 @@
+package grafana
+
+import (
+	"context"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+)
+
+type instrumentedRepo struct {
+	repo     Repo
+	upstream *metrics.Upstream
+}
+
+// NewInstrumentedRepo wraps the given repo so the latency and the failures of its lookups are recorded to the metrics
+// registry. A rejected session or an unknown datasource is not a failure of grafana.
+func NewInstrumentedRepo(repo Repo, registry *metrics.Registry) Repo {
+	return &instrumentedRepo{repo: repo, upstream: metrics.NewUpstream(registry, "grafana")}
+}
+
+func (r *instrumentedRepo) GetUser(ctx context.Context, creds *Credentials) (*User, error) {
+	start := time.Now()
+
+	user, err := r.repo.GetUser(ctx, creds)
+	r.upstream.Observe("user", start, !isAnswer(err))
+
+	return user, err
+}
+
+func (r *instrumentedRepo) GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error) {
+	start := time.Now()
+
+	teams, err := r.repo.GetUserTeams(ctx, creds, userID)
+	r.upstream.Observe("teams", start, !isAnswer(err))
+
+	return teams, err
+}
+
+func (r *instrumentedRepo) GetUserOrgs(ctx context.Context, creds *Credentials) ([]*Org, error) {
+	start := time.Now()
+
+	orgs, err := r.repo.GetUserOrgs(ctx, creds)
+	r.upstream.Observe("orgs", start, !isAnswer(err))
+
+	return orgs, err
+}
+
+func (r *instrumentedRepo) GetDatasource(ctx context.Context, creds *Credentials, uid string) (*Datasource, error) {
+	start := time.Now()
+
+	datasource, err := r.repo.GetDatasource(ctx, creds, uid)
+	r.upstream.Observe("datasource", start, !isAnswer(err))
+
+	return datasource, err
+}
@@ -1,9 +1,11 @@
 package grafana
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/usegiam/giam-traefik-plugin/internal/errors"
@@ -19,16 +21,13 @@ func NewRepo(grafanaUrl string, logger *log.Logger) Repo {
 	return &repo{grafanaUrl: grafanaUrl, logger: logger}
 }
 
-func (r *repo) GetUser(session string) (*User, error) {
-	req, err := http.NewRequest(http.MethodGet, r.grafanaUrl+"/api/user", nil)
+func (r *repo) GetUser(ctx context.Context, creds *Credentials) (*User, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.grafanaUrl+"/api/user", nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.AddCookie(&http.Cookie{
-		Name:  "grafana_session",
-		Value: session,
-	})
+	creds.Apply(req)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -38,7 +37,11 @@ func (r *repo) GetUser(session string) (*User, error) {
 	}
 	defer resp.Body.Close()
 
-	r.logger.Debugf("grafana get user resp: %v", resp.StatusCode)
+	r.logger.Ctx(ctx).Debugw("grafana get user resp", "status", resp.StatusCode)
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("grafana user request failed with status %d", resp.StatusCode)
+	}
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.ErrFailedtoCommunicateWithGrafana
@@ -51,21 +54,20 @@ func (r *repo) GetUser(session string) (*User, error) {
 		return nil, fmt.Errorf("failed to decode the user with payload: %w", err)
 	}
 
-	r.logger.Debugf("fetched user: %v", user)
+	r.logger.Ctx(ctx).Debugw("fetched user", "user_id", user.ID, "login", user.Login)
 
 	return &user, nil
 }
 
-func (r *repo) GetUserTeams(session string, userID int) ([]*Team, error) {
-	req, err := http.NewRequest("GET", r.grafanaUrl+"/api/teams/search?userId="+strconv.Itoa(userID), nil)
+func (r *repo) GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error) {
+	teamsUrl := r.grafanaUrl + "/api/teams/search?userId=" + strconv.Itoa(userID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, teamsUrl, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.AddCookie(&http.Cookie{
-		Name:  "grafana_session",
-		Value: session,
-	})
+	creds.Apply(req)
 
 	client := &http.Client{}
 
@@ -76,6 +78,10 @@ func (r *repo) GetUserTeams(session string, userID int) ([]*Team, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("grafana teams request failed with status %d", resp.StatusCode)
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.ErrUserDoesntHaveAnyTeamAssigned
 	}
@@ -89,7 +95,83 @@ func (r *repo) GetUserTeams(session string, userID int) ([]*Team, error) {
 		return nil, fmt.Errorf("failed to decode the teams with payload: %w", err)
 	}
 
-	r.logger.Debugf("fetched user teams")
+	r.logger.Ctx(ctx).Debugw("fetched user teams", "user_id", userID, "teams", len(response.Teams))
 
 	return response.Teams, nil
 }
+
+func (r *repo) GetUserOrgs(ctx context.Context, creds *Credentials) ([]*Org, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.grafanaUrl+"/api/user/orgs", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.Apply(req)
+
+	client := &http.Client{}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send user orgs request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	r.logger.Ctx(ctx).Debugw("grafana get user orgs resp", "status", resp.StatusCode)
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("grafana user orgs request failed with status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.ErrFailedtoCommunicateWithGrafana
+	}
+
+	var orgs []*Org
+
+	err = json.NewDecoder(resp.Body).Decode(&orgs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the user orgs with payload: %w", err)
+	}
+
+	return orgs, nil
+}
+
+func (r *repo) GetDatasource(ctx context.Context, creds *Credentials, uid string) (*Datasource, error) {
+	datasourceUrl := r.grafanaUrl + "/api/datasources/uid/" + url.PathEscape(uid)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, datasourceUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.Apply(req)
+
+	client := &http.Client{}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send datasource request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	r.logger.Ctx(ctx).Debugw("grafana get datasource resp", "uid", uid, "status", resp.StatusCode)
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("grafana datasource request failed with status %d", resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.ErrDatasourceNotFound
+	}
+
+	var datasource Datasource
+
+	err = json.NewDecoder(resp.Body).Decode(&datasource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the datasource with payload: %w", err)
+	}
+
+	return &datasource, nil
+}
@@ -1,15 +1,40 @@
 package grafana
 
+import (
+	"context"
+
+	"github.com/usegiam/giam-traefik-plugin/internal/errors"
+)
+
 type MockRepo struct {
-	User  *User
-	Teams []*Team
-	Err   error
+	User        *User
+	Teams       []*Team
+	Orgs        []*Org
+	Datasources map[string]*Datasource
+	Err         error
 }
 
-func (g *MockRepo) GetUser(session string) (*User, error) {
+func (g *MockRepo) GetUser(ctx context.Context, creds *Credentials) (*User, error) {
 	return g.User, g.Err
 }
 
-func (g *MockRepo) GetUserTeams(session string, userID int) ([]*Team, error) {
+func (g *MockRepo) GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error) {
 	return g.Teams, g.Err
 }
+
+func (g *MockRepo) GetUserOrgs(ctx context.Context, creds *Credentials) ([]*Org, error) {
+	return g.Orgs, g.Err
+}
+
+func (g *MockRepo) GetDatasource(ctx context.Context, creds *Credentials, uid string) (*Datasource, error) {
+	if g.Err != nil {
+		return nil, g.Err
+	}
+
+	ds, ok := g.Datasources[uid]
+	if !ok {
+		return nil, errors.ErrDatasourceNotFound
+	}
+
+	return ds, nil
+}
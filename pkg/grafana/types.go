@@ -1,8 +1,55 @@
 package grafana
 
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// OrgIDHeader is the header grafana selects the organization of a request with.
+const OrgIDHeader = "X-Grafana-Org-Id"
+
 type Repo interface {
-	GetUser(session string) (*User, error)
-	GetUserTeams(session string, userID int) ([]*Team, error)
+	GetUser(ctx context.Context, creds *Credentials) (*User, error)
+	GetUserTeams(ctx context.Context, creds *Credentials, userID int) ([]*Team, error)
+	GetUserOrgs(ctx context.Context, creds *Credentials) ([]*Org, error)
+	GetDatasource(ctx context.Context, creds *Credentials, uid string) (*Datasource, error)
+}
+
+// Credentials authenticate the calls made to grafana on behalf of a request, with its grafana_session cookie or with
+// the header grafana authenticates it with, e.g. Authorization: Bearer <token>.
+type Credentials struct {
+	Session string
+	Header  string
+	Value   string
+	// OrgID selects the organization of the calls, the current organization of the user is used when it is 0.
+	OrgID int
+}
+
+// Apply authenticates the request to grafana with the credentials.
+func (c *Credentials) Apply(req *http.Request) {
+	if c.Session != "" {
+		req.AddCookie(&http.Cookie{Name: "grafana_session", Value: c.Session})
+	}
+
+	if c.Header != "" {
+		req.Header.Set(c.Header, c.Value)
+	}
+
+	if c.OrgID != 0 {
+		req.Header.Set(OrgIDHeader, strconv.Itoa(c.OrgID))
+	}
+}
+
+// key identifies the credentials in the cache and coalescing keys.
+func (c *Credentials) key() string {
+	org := strconv.Itoa(c.OrgID) + ":"
+
+	if c.Session != "" {
+		return org + "session:" + c.Session
+	}
+
+	return org + "header:" + c.Header + ":" + c.Value
 }
 
 type QueryReq struct {
@@ -21,17 +68,53 @@ type LabelValuesReq struct {
 	Status string   `json:"status"`
 }
 
+// MetadataReq holds the metadata of the metrics answered by prometheus, by metric name.
+type MetadataReq struct {
+	Data   map[string][]*MetricMetadata `json:"data"`
+	Status string                       `json:"status"`
+}
+
+type MetricMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// ExemplarsReq holds the exemplars answered by prometheus, grouped by the series they belong to.
+type ExemplarsReq struct {
+	Data   []*ExemplarSeries `json:"data"`
+	Status string            `json:"status"`
+}
+
+type ExemplarSeries struct {
+	SeriesLabels map[string]string `json:"seriesLabels"`
+	// Exemplars are kept as answered, with their labels, e.g. the trace id, value and timestamp.
+	Exemplars []interface{} `json:"exemplars"`
+}
+
 type User struct {
 	ID    int    `json:"id"`
+	Login string `json:"login"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
+	// OrgID is the current organization of the user.
+	OrgID int `json:"orgId"`
 }
 
 type Team struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	OrgID int    `json:"orgId"`
+}
+
+// Org is an organization the user is a member of, with their role in it, e.g. "Admin", "Editor" or "Viewer".
+type Org struct {
+	OrgID int    `json:"orgId"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
 }
 
 type Datasource struct {
-	UID string `json:"UID"`
+	UID  string `json:"UID"`
+	Type string `json:"type,omitempty"`
 }
@@ -1,9 +1,18 @@
 package log
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Log levels
@@ -14,81 +23,177 @@ const (
 	FATAL
 )
 
+// Log formats
+const (
+	// FormatText writes the message after the date and the caller, followed by the fields as key=value pairs.
+	FormatText = "text"
+	// FormatJSON writes one JSON object per line.
+	FormatJSON = "json"
+	// FormatLogfmt writes one line of key=value pairs.
+	FormatLogfmt = "logfmt"
+)
+
+var levelNames = map[int]string{DEBUG: "debug", INFO: "info", ERROR: "error", FATAL: "fatal"}
+
 // Logger struct to hold log level and output destination
 type Logger struct {
 	level  int
+	format string
 	logger *log.Logger
+	out    *output
+	// fields are the key-value pairs added to every line, e.g. the request id.
+	fields []interface{}
+	now    func() time.Time
+}
+
+// output serializes the lines of the structured formats written by a logger and the loggers derived from it.
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type Options struct {
+	Level string
+	// Format is one of FormatText, FormatJSON or FormatLogfmt, FormatText when empty.
+	Format string
+	// Output is where the lines are written, stderr when nil.
+	Output io.Writer
 }
 
 func New(level string) *Logger {
+	return NewLogger(&Options{Level: level})
+}
+
+func NewLogger(opts *Options) *Logger {
 	logLevel := INFO
 
-	switch level {
+	switch opts.Level {
 	case "INFO":
 		logLevel = INFO
-		break
 	case "DEBUG":
 		logLevel = DEBUG
-		break
 	case "ERROR":
 		logLevel = ERROR
-		break
 	case "FATAL":
 		logLevel = FATAL
-		break
 	default:
 		logLevel = INFO
 	}
 
-	return &Logger{level: logLevel, logger: log.New(os.Stderr, "", log.LstdFlags|log.Lshortfile)}
+	format := opts.Format
+	if format != FormatJSON && format != FormatLogfmt {
+		format = FormatText
+	}
+
+	w := opts.Output
+	if w == nil {
+		w = os.Stderr
+	}
+
+	return &Logger{
+		level:  logLevel,
+		format: format,
+		logger: log.New(w, "", log.LstdFlags|log.Lshortfile),
+		out:    &output{w: w},
+		now:    time.Now,
+	}
+}
+
+// With returns a logger adding the key-value pairs to every line, e.g. With("request_id", id).
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+
+	child := *l
+	child.fields = fields
+
+	return &child
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger, e.g. a logger with the id of the request.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// Ctx returns the logger carried by ctx, or l when ctx doesn't carry any.
+func (l *Logger) Ctx(ctx context.Context) *Logger {
+	if ctxLogger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return ctxLogger
+	}
+
+	return l
 }
 
 // Debug logs debug-level messages
 func (l *Logger) Debug(msg string) {
 	if l.level <= DEBUG {
-		l.logger.Output(2, msg)
+		l.output(DEBUG, msg, nil)
 	}
 }
 
 // Debugf logs formatted messages at DEBUG level
 func (l *Logger) Debugf(format string, v ...interface{}) {
 	if l.level <= DEBUG {
-		l.logger.Output(2, fmt.Sprintf(format, v...))
+		l.output(DEBUG, fmt.Sprintf(format, v...), nil)
+	}
+}
+
+// Debugw logs the message along with the key-value pairs at DEBUG level
+func (l *Logger) Debugw(msg string, keyvals ...interface{}) {
+	if l.level <= DEBUG {
+		l.output(DEBUG, msg, keyvals)
 	}
 }
 
 // Info logs info-level messages
 func (l *Logger) Info(msg string) {
 	if l.level <= INFO {
-		l.logger.Output(2, msg)
+		l.output(INFO, msg, nil)
 	}
 }
 
 // Infof logs formatted messages at INFO level
 func (l *Logger) Infof(format string, v ...interface{}) {
 	if l.level <= INFO {
-		l.logger.Output(2, fmt.Sprintf(format, v...))
+		l.output(INFO, fmt.Sprintf(format, v...), nil)
+	}
+}
+
+// Infow logs the message along with the key-value pairs at INFO level
+func (l *Logger) Infow(msg string, keyvals ...interface{}) {
+	if l.level <= INFO {
+		l.output(INFO, msg, keyvals)
 	}
 }
 
 // Error logs error-level messages
 func (l *Logger) Error(msg string) {
 	if l.level <= ERROR {
-		l.logger.Output(2, msg)
+		l.output(ERROR, msg, nil)
 	}
 }
 
 // Errorf logs formatted message error-level
 func (l *Logger) Errorf(format string, v ...interface{}) {
 	if l.level <= ERROR {
-		l.logger.Output(2, fmt.Sprintf(format, v...))
+		l.output(ERROR, fmt.Sprintf(format, v...), nil)
+	}
+}
+
+// Errorw logs the message along with the key-value pairs at ERROR level
+func (l *Logger) Errorw(msg string, keyvals ...interface{}) {
+	if l.level <= ERROR {
+		l.output(ERROR, msg, keyvals)
 	}
 }
 
 // Fatal logs fatal-level messages and exits the program
 func (l *Logger) Fatal(msg string) {
 	if l.level <= FATAL {
-		l.logger.Output(2, msg)
+		l.output(FATAL, msg, nil)
 		os.Exit(1)
 	}
 }
@@ -96,7 +201,114 @@ func (l *Logger) Fatal(msg string) {
 // Fatalf logs formatted messages at fatal-level and exits the program
 func (l *Logger) Fatalf(format string, v ...interface{}) {
 	if l.level <= FATAL {
-		l.logger.Output(2, fmt.Sprintf(format, v...))
+		l.output(FATAL, fmt.Sprintf(format, v...), nil)
 		os.Exit(1)
 	}
 }
+
+// output writes a line, it must be called directly by the exported methods so the caller is the right one.
+func (l *Logger) output(level int, msg string, keyvals []interface{}) {
+	fields := l.fields
+	if len(keyvals) > 0 {
+		fields = append(fields[:len(fields):len(fields)], keyvals...)
+	}
+
+	if l.format == FormatText {
+		var sb strings.Builder
+
+		sb.WriteString(msg)
+		writeLogfmtFields(&sb, fields)
+
+		l.logger.Output(3, sb.String())
+
+		return
+	}
+
+	caller := "???"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+
+	header := []interface{}{
+		"time", l.now().UTC().Format(time.RFC3339Nano),
+		"level", levelNames[level],
+		"caller", caller,
+		"msg", msg,
+	}
+
+	var sb strings.Builder
+
+	if l.format == FormatJSON {
+		writeJSON(&sb, header, fields)
+	} else {
+		writeLogfmtFields(&sb, header)
+		writeLogfmtFields(&sb, fields)
+	}
+
+	sb.WriteByte('\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	io.WriteString(l.out.w, strings.TrimPrefix(sb.String(), " "))
+}
+
+// pairs calls fn with every key-value pair, a key without value gets "(MISSING)".
+func pairs(keyvals []interface{}, fn func(key string, value interface{})) {
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+
+		fn(fmt.Sprint(keyvals[i]), value)
+	}
+}
+
+func writeJSON(sb *strings.Builder, header []interface{}, fields []interface{}) {
+	sb.WriteByte('{')
+
+	first := true
+	write := func(key string, value interface{}) {
+		if !first {
+			sb.WriteByte(',')
+		}
+
+		first = false
+
+		k, _ := json.Marshal(key)
+		sb.Write(k)
+		sb.WriteByte(':')
+
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+
+		sb.Write(v)
+	}
+
+	pairs(header, write)
+	pairs(fields, write)
+
+	sb.WriteByte('}')
+}
+
+func writeLogfmtFields(sb *strings.Builder, keyvals []interface{}) {
+	pairs(keyvals, func(key string, value interface{}) {
+		sb.WriteByte(' ')
+		sb.WriteString(key)
+		sb.WriteByte('=')
+
+		s := fmt.Sprint(value)
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+			s = strconv.Quote(s)
+		}
+
+		sb.WriteString(s)
+	})
+}
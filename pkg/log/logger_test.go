@@ -0,0 +1,57 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+func TestLogger_Formats(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{
+			name:   "it should write a JSON object per line",
+			format: FormatJSON,
+			expected: `{"time":"2024-05-01T12:00:00Z","level":"debug","caller":"logger_test.go:42","msg":"unable to authorize",` +
+				`"request_id":"abc","datasource":"loki","err":"giam is down"}` + "\n",
+		},
+		{
+			name:   "it should write a logfmt line",
+			format: FormatLogfmt,
+			expected: `time=2024-05-01T12:00:00Z level=debug caller=logger_test.go:42 msg="unable to authorize" ` +
+				`request_id=abc datasource=loki err="giam is down"` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			logger := NewLogger(&Options{Level: "DEBUG", Format: tt.format, Output: &buf})
+			logger.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+
+			ctx := NewContext(context.Background(), logger.With("request_id", "abc"))
+
+			logger.Ctx(ctx).Debugw("unable to authorize", "datasource", "loki", "err", errors.New("giam is down"))
+
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := NewLogger(&Options{Level: "ERROR", Format: FormatLogfmt, Output: &buf})
+	logger.Debugw("hidden")
+	logger.Infof("hidden %d", 1)
+
+	assert.Equal(t, "", buf.String())
+}
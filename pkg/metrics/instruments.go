@@ -0,0 +1,82 @@
+package metrics
+
+import "time"
+
+// Upstream records the latency and the failures of the calls made to an upstream service, e.g. giam or grafana. A nil
+// Upstream records nothing.
+type Upstream struct {
+	name     string
+	duration *Histogram
+	errors   *Counter
+}
+
+// NewUpstream returns the instruments of the named upstream, or nil when r is nil.
+func NewUpstream(r *Registry, name string) *Upstream {
+	if r == nil {
+		return nil
+	}
+
+	return &Upstream{
+		name: name,
+		duration: r.NewHistogram(
+			"giam_upstream_request_duration_seconds",
+			"Latency of the calls made by the plugin to its upstreams.",
+			DefaultBuckets,
+			"upstream", "operation",
+		),
+		errors: r.NewCounter(
+			"giam_upstream_errors_total",
+			"Calls made by the plugin to its upstreams which failed.",
+			"upstream", "operation",
+		),
+	}
+}
+
+// Observe records a call of the operation started at start.
+func (u *Upstream) Observe(operation string, start time.Time, failed bool) {
+	if u == nil {
+		return
+	}
+
+	u.duration.Observe(time.Since(start).Seconds(), u.name, operation)
+
+	if failed {
+		u.errors.Inc(u.name, operation)
+	}
+}
+
+// Cache records the hits and misses of the named cache, the hit ratio being hits over lookups. A nil Cache records
+// nothing.
+type Cache struct {
+	name     string
+	requests *Counter
+}
+
+// NewCache returns the instruments of the named cache, or nil when r is nil.
+func NewCache(r *Registry, name string) *Cache {
+	if r == nil {
+		return nil
+	}
+
+	return &Cache{
+		name: name,
+		requests: r.NewCounter(
+			"giam_cache_requests_total",
+			"Lookups of the plugin caches by result, hit or miss.",
+			"cache", "result",
+		),
+	}
+}
+
+// Record records a lookup of the cache.
+func (c *Cache) Record(hit bool) {
+	if c == nil {
+		return
+	}
+
+	if hit {
+		c.requests.Inc(c.name, "hit")
+	} else {
+		c.requests.Inc(c.name, "miss")
+	}
+}
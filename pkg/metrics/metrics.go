@@ -0,0 +1,284 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of the latency histograms.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics exposed in the Prometheus text format. Every method of a nil Registry, and of the nil
+// metrics it returns, does nothing, so the components don't need to know whether the metrics are enabled.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	byName  map[string]metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: map[string]metric{}}
+}
+
+// NewCounter returns the counter with the given name, registered on its first call.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.byName[name].(*Counter); ok {
+		return existing
+	}
+
+	c := &Counter{series: newSeries(name, help, "counter", labels)}
+	r.register(name, c)
+
+	return c
+}
+
+// NewHistogram returns the histogram with the given name, registered on its first call. buckets are the upper bounds
+// of the buckets, in increasing order.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.byName[name].(*Histogram); ok {
+		return existing
+	}
+
+	h := &Histogram{series: newSeries(name, help, "histogram", labels), buckets: buckets}
+	r.register(name, h)
+
+	return h
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.byName[name] = m
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.Write(rw)
+}
+
+// series holds the values of a metric by label values.
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+func newSeries(name, help, kind string, labels []string) *series {
+	return &series{name: name, help: help, kind: kind, labels: labels, values: map[string]interface{}{}}
+}
+
+// get returns the value of the label values, created with create on first use. It returns nil when the number of
+// label values doesn't match the labels of the metric. It must be called with mu held.
+func (s *series) get(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(s.labels) {
+		return nil
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v, ok := s.values[key]
+	if !ok {
+		v = create()
+		s.values[key] = v
+	}
+
+	return v
+}
+
+// sortedKeys returns the keys of the values in a stable order, so the output doesn't depend on map iteration order.
+func (s *series) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (s *series) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + s.name + " " + s.help + "\n")
+	w.WriteString("# TYPE " + s.name + " " + s.kind + "\n")
+}
+
+// labelPairs formats the labels of a key, with the extra pair when it is not empty, e.g. {endpoint="query",le="1"}.
+func (s *series) labelPairs(key string, extra string) string {
+	var pairs []string
+
+	if len(s.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, s.labels[i]+`="`+escape(value)+`"`)
+		}
+	}
+
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value by label values.
+type Counter struct {
+	*series
+}
+
+type counterValue struct {
+	value float64
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if c == nil || delta < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := c.get(labelValues, func() interface{} { return &counterValue{} })
+	if v == nil {
+		return
+	}
+
+	v.(*counterValue).value += delta
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+
+	for _, key := range c.sortedKeys() {
+		w.WriteString(c.name + c.labelPairs(key, "") + " " + formatFloat(c.values[key].(*counterValue).value) + "\n")
+	}
+}
+
+// Histogram counts the observed values in buckets by label values, along with their sum and count.
+type Histogram struct {
+	*series
+	buckets []float64
+}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v := h.get(labelValues, func() interface{} { return &histogramValue{counts: make([]uint64, len(h.buckets))} })
+	if v == nil {
+		return
+	}
+
+	hv := v.(*histogramValue)
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hv.counts[i]++
+		}
+	}
+
+	hv.sum += value
+	hv.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+
+	for _, key := range h.sortedKeys() {
+		hv := h.values[key].(*histogramValue)
+
+		for i, bound := range h.buckets {
+			le := `le="` + formatFloat(bound) + `"`
+			w.WriteString(h.name + "_bucket" + h.labelPairs(key, le) + " " + strconv.FormatUint(hv.counts[i], 10) + "\n")
+		}
+
+		w.WriteString(h.name + "_bucket" + h.labelPairs(key, `le="+Inf"`) + " " + strconv.FormatUint(hv.count, 10) + "\n")
+		w.WriteString(h.name + "_sum" + h.labelPairs(key, "") + " " + formatFloat(hv.sum) + "\n")
+		w.WriteString(h.name + "_count" + h.labelPairs(key, "") + " " + strconv.FormatUint(hv.count, 10) + "\n")
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("giam_requests_total", "Requests.", "endpoint", "decision")
+	requests.Inc("series", "deny")
+	requests.Inc("query", "allow")
+	requests.Add(2, "query", "allow")
+	// Observations with the wrong number of label values are dropped.
+	requests.Inc("query")
+
+	duration := r.NewHistogram("giam_request_duration_seconds", "Latency.", []float64{0.1, 1}, "endpoint")
+	duration.Observe(0.05, "query")
+	duration.Observe(0.5, "query")
+	duration.Observe(3, "query")
+
+	// The metrics are registered once by name.
+	r.NewCounter("giam_requests_total", "Requests.", "endpoint", "decision").Inc("series", "deny")
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+
+	expected := `# HELP giam_requests_total Requests.
+# TYPE giam_requests_total counter
+giam_requests_total{endpoint="query",decision="allow"} 3
+giam_requests_total{endpoint="series",decision="deny"} 2
+# HELP giam_request_duration_seconds Latency.
+# TYPE giam_request_duration_seconds histogram
+giam_request_duration_seconds_bucket{endpoint="query",le="0.1"} 1
+giam_request_duration_seconds_bucket{endpoint="query",le="1"} 2
+giam_request_duration_seconds_bucket{endpoint="query",le="+Inf"} 3
+giam_request_duration_seconds_sum{endpoint="query"} 3.55
+giam_request_duration_seconds_count{endpoint="query"} 3
+`
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRegistry_EscapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("giam_upstream_errors_total", "Errors.", "operation").Inc("a\"b\\c\nd")
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+
+	assert.Equal(t, `# HELP giam_upstream_errors_total Errors.
+# TYPE giam_upstream_errors_total counter
+giam_upstream_errors_total{operation="a\"b\\c\nd"} 1
+`, buf.String())
+}
+
+func TestRegistry_Nil(t *testing.T) {
+	var r *Registry
+
+	r.NewCounter("giam_requests_total", "Requests.").Inc()
+	r.NewHistogram("giam_request_duration_seconds", "Latency.", DefaultBuckets).Observe(1)
+	NewUpstream(r, "giam").Observe("/policies", time.Now(), true)
+	NewCache(r, "grafana").Record(true)
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+	assert.Equal(t, "", buf.String())
+}
@@ -0,0 +1,69 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+)
+
+// Header carries the id of a request, from the client to the upstream and to Giam, and back in the response.
+const Header = "X-Request-Id"
+
+// maxLength bounds the ids accepted from the clients, longer ones are replaced.
+const maxLength = 128
+
+type contextKey struct{}
+
+// WithID returns a copy of ctx carrying the id of the request.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the id of the request, empty when ctx doesn't carry any.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+
+	return id
+}
+
+// New returns a random 128-bit id, hex encoded.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Middleware gives every request an id, the one sent by the client in the Header or a new one, and a logger adding
+// it to every line, found with log.Logger.Ctx. The id is forwarded upstream and returned in the response.
+type Middleware struct {
+	next   http.Handler
+	logger *log.Logger
+}
+
+type MiddlewareDeps struct {
+	Next   http.Handler
+	Logger *log.Logger
+}
+
+func NewMiddleware(deps *MiddlewareDeps) http.Handler {
+	return &Middleware{next: deps.Next, logger: deps.Logger}
+}
+
+func (m *Middleware) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	id := req.Header.Get(Header)
+	if id == "" || len(id) > maxLength {
+		id = New()
+		req.Header.Set(Header, id)
+	}
+
+	rw.Header().Set(Header, id)
+
+	ctx := WithID(req.Context(), id)
+	ctx = log.NewContext(ctx, m.logger.With("request_id", id))
+
+	m.next.ServeHTTP(rw, req.WithContext(ctx))
+}
@@ -0,0 +1,55 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		requestID  string
+		expectedID string
+	}{
+		{
+			name:       "it should keep the id sent by the client",
+			requestID:  "abc-123",
+			expectedID: "abc-123",
+		},
+		{
+			name: "it should generate an id when the client sent none",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamID, ctxID string
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				upstreamID = req.Header.Get(Header)
+				ctxID = FromContext(req.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/ds/query", nil)
+			if tt.requestID != "" {
+				req.Header.Set(Header, tt.requestID)
+			}
+
+			rec := httptest.NewRecorder()
+			NewMiddleware(&MiddlewareDeps{Next: next, Logger: log.New("FATAL")}).ServeHTTP(rec, req)
+
+			if tt.expectedID != "" {
+				assert.Equal(t, tt.expectedID, ctxID)
+			} else {
+				assert.Equal(t, 32, len(ctxID))
+			}
+
+			assert.Equal(t, ctxID, upstreamID)
+			assert.Equal(t, ctxID, rec.Header().Get(Header))
+		})
+	}
+}
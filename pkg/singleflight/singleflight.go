@@ -0,0 +1,98 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrPanicked is returned to the callers waiting for a call which panicked.
+var ErrPanicked = errors.New("the shared call panicked")
+
+// Group coalesces the concurrent calls made with the same key into a single call whose result is shared by all of
+// them. Results aren't kept once the call returned, see cache.Cache for that.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+	// dups is the number of callers waiting for the call.
+	dups int
+}
+
+// Do calls fn unless a call with the same key is already in flight, in which case it waits for that call and returns
+// its result. shared reports whether the result was returned to several callers.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	g.mu.Lock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+
+		return c.value, c.err, true
+	}
+
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.doCall(c, key, fn)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return c.value, c.err, c.dups > 0
+}
+
+// DoContext is Do for the calls bound to the context of a request. The shared call runs with the context of the caller
+// which started it, so when that caller is cancelled the other callers make the call again with their own context
+// instead of failing with the cancellation.
+func (g *Group) DoContext(
+	ctx context.Context,
+	key string,
+	fn func(ctx context.Context) (interface{}, error),
+) (interface{}, error) {
+	value, err, shared := g.Do(key, func() (interface{}, error) {
+		return fn(ctx)
+	})
+
+	if shared && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return fn(ctx)
+	}
+
+	return value, err
+}
+
+// doCall releases the waiting callers even when fn panics. They get ErrPanicked, and the panic goes on in the caller
+// which made the call, as it would have without the group.
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			c.value, c.err = nil, fmt.Errorf("%w: %v", ErrPanicked, r)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		c.wg.Done()
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	c.value, c.err = fn()
+}
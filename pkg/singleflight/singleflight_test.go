@@ -0,0 +1,188 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/usegiam/giam-traefik-plugin/pkg/testutil/assert"
+)
+
+func TestGroup_Do(t *testing.T) {
+	var g Group
+
+	value, err, shared := g.Do("key", func() (interface{}, error) {
+		return "value", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	assert.False(t, shared)
+
+	_, err, _ = g.Do("key", func() (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	assert.Error(t, err)
+}
+
+func TestGroup_DoConcurrent(t *testing.T) {
+	var (
+		g       Group
+		calls   int32
+		wg      sync.WaitGroup
+		release = make(chan struct{})
+		started = make(chan struct{})
+	)
+
+	const callers = 10
+
+	results := make([]interface{}, callers)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			results[i], _, _ = g.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				close(started)
+				<-release
+
+				return "value", nil
+			})
+		}(i)
+	}
+
+	<-started
+
+	// Wait until every caller joined the call in flight before releasing it.
+	for {
+		g.mu.Lock()
+		dups := g.calls["key"].dups
+		g.mu.Unlock()
+
+		if dups == callers-1 {
+			break
+		}
+	}
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	for _, result := range results {
+		assert.Equal(t, "value", result)
+	}
+}
+
+func TestGroup_DoPanicked(t *testing.T) {
+	var (
+		g       Group
+		release = make(chan struct{})
+		started = make(chan struct{})
+		done    = make(chan interface{})
+	)
+
+	go func() {
+		defer func() { done <- recover() }()
+
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+
+			panic("boom")
+		})
+	}()
+
+	<-started
+
+	var err error
+
+	waiter := make(chan struct{})
+
+	go func() {
+		defer close(waiter)
+
+		_, err, _ = g.Do("key", func() (interface{}, error) {
+			return "value", nil
+		})
+	}()
+
+	// Wait until the caller joined the call in flight before releasing it.
+	for {
+		g.mu.Lock()
+		dups := g.calls["key"].dups
+		g.mu.Unlock()
+
+		if dups == 1 {
+			break
+		}
+	}
+
+	close(release)
+
+	// The panic goes on in the caller which made the call, the waiter gets an error.
+	assert.Equal(t, "boom", <-done)
+
+	<-waiter
+	assert.True(t, errors.Is(err, ErrPanicked))
+
+	// The call is no longer in flight.
+	value, err, _ := g.Do("key", func() (interface{}, error) {
+		return "value", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestGroup_DoContextLeaderCancelled(t *testing.T) {
+	var g Group
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		_, err := g.DoContext(leaderCtx, "key", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			<-ctx.Done()
+
+			return nil, ctx.Err()
+		})
+		assert.Equal(t, context.Canceled, err)
+	}()
+
+	<-started
+
+	result := make(chan interface{})
+
+	go func() {
+		value, err := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			return "value", nil
+		})
+		assert.NoError(t, err)
+
+		result <- value
+	}()
+
+	// Wait until the second caller joined the call in flight before cancelling the first one.
+	for {
+		g.mu.Lock()
+		dups := g.calls["key"].dups
+		g.mu.Unlock()
+
+		if dups == 1 {
+			break
+		}
+	}
+
+	cancel()
+
+	assert.Equal(t, "value", <-result)
+	<-done
+}
@@ -73,3 +73,29 @@ func CompareJson(t *testing.T, expected, actual interface{}) {
 		t.Fail()
 	}
 }
+
+// JsonEq compares the JSON documents, whatever the order of their keys.
+func JsonEq(t *testing.T, expected, actual string) {
+	t.Helper()
+
+	var expectedValue, actualValue interface{}
+
+	if err := json.Unmarshal([]byte(expected), &expectedValue); err != nil {
+		t.Logf("Expect a JSON document, but got %v: %v", expected, err)
+		t.Fail()
+
+		return
+	}
+
+	if err := json.Unmarshal([]byte(actual), &actualValue); err != nil {
+		t.Logf("Expect a JSON document, but got %v: %v", actual, err)
+		t.Fail()
+
+		return
+	}
+
+	if !reflect.DeepEqual(expectedValue, actualValue) {
+		t.Logf("Expect %v, but got %v", expected, actual)
+		t.Fail()
+	}
+}
@@ -3,101 +3,1131 @@ package giam_traefik_plugin
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/usegiam/giam-traefik-plugin/internal/audit"
+	"github.com/usegiam/giam-traefik-plugin/internal/authorization"
 	authorizationhandler "github.com/usegiam/giam-traefik-plugin/internal/authorization/handler"
 	authorizationservice "github.com/usegiam/giam-traefik-plugin/internal/authorization/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/bypass"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource"
+	datasourcehandler "github.com/usegiam/giam-traefik-plugin/internal/datasource/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/loki"
 	lokihandler "github.com/usegiam/giam-traefik-plugin/internal/datasource/loki/handler"
 	lokiservice "github.com/usegiam/giam-traefik-plugin/internal/datasource/loki/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus"
 	prometheushandler "github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/handler"
+	prometheusrepo "github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/repo"
 	prometheusservice "github.com/usegiam/giam-traefik-plugin/internal/datasource/prometheus/service"
+	"github.com/usegiam/giam-traefik-plugin/internal/failure"
 	"github.com/usegiam/giam-traefik-plugin/internal/handler"
+	"github.com/usegiam/giam-traefik-plugin/internal/identity"
+	"github.com/usegiam/giam-traefik-plugin/internal/policy"
+	policyservice "github.com/usegiam/giam-traefik-plugin/internal/policy/service"
+	"github.com/usegiam/giam-traefik-plugin/pkg/breaker"
+	"github.com/usegiam/giam-traefik-plugin/pkg/giam"
 	"github.com/usegiam/giam-traefik-plugin/pkg/grafana"
 	"github.com/usegiam/giam-traefik-plugin/pkg/hash"
 	"github.com/usegiam/giam-traefik-plugin/pkg/log"
+	"github.com/usegiam/giam-traefik-plugin/pkg/metrics"
+	"github.com/usegiam/giam-traefik-plugin/pkg/requestid"
 )
 
 type Config struct {
-	APIKey     string `yaml:"APIKey"`
-	APIUrl     string `yaml:"APIUrl"`
-	GrafanaUrl string `yaml:"GrafanaUrl"`
-	LogLevel   string `yaml:"LogLevel"`
+	APIKey             string               `yaml:"APIKey"`
+	APIUrl             string               `yaml:"APIUrl"`
+	GrafanaUrl         string               `yaml:"GrafanaUrl"`
+	LogLevel           string               `yaml:"LogLevel"`
+	LogFormat          string               `yaml:"LogFormat"`
+	GrafanaCache       GrafanaCacheConfig   `yaml:"GrafanaCache"`
+	DatasourceCacheTTL string               `yaml:"DatasourceCacheTTL"`
+	DecisionCacheTTL   string               `yaml:"DecisionCacheTTL"`
+	Shadow             bool                 `yaml:"Shadow"`
+	Prometheus         PrometheusConfig     `yaml:"Prometheus"`
+	Loki               LokiConfig           `yaml:"Loki"`
+	PolicySync         PolicySyncConfig     `yaml:"PolicySync"`
+	Failure            FailureConfig        `yaml:"Failure"`
+	GiamClient         GiamClientConfig     `yaml:"GiamClient"`
+	CircuitBreaker     CircuitBreakerConfig `yaml:"CircuitBreaker"`
+	Identity           IdentityConfig       `yaml:"Identity"`
+	Bypass             BypassConfig         `yaml:"Bypass"`
+	Audit              AuditConfig          `yaml:"Audit"`
+	Metrics            MetricsConfig        `yaml:"Metrics"`
+}
+
+// GrafanaCacheConfig configures the cache of the grafana user and team lookups, durations use the time.ParseDuration
+// format.
+type GrafanaCacheConfig struct {
+	Enabled     bool   `yaml:"Enabled"`
+	TTL         string `yaml:"TTL"`
+	NegativeTTL string `yaml:"NegativeTTL"`
+	MaxEntries  int    `yaml:"MaxEntries"`
+}
+
+// PrometheusConfig configures how prometheus queries are enforced. Mode is "remote" to rewrite the queries through
+// the Giam API, or "local" to fetch the policy from Giam and rewrite the queries in the plugin.
+type PrometheusConfig struct {
+	Mode      string       `yaml:"Mode"`
+	PolicyTTL string       `yaml:"PolicyTTL"`
+	Direct    DirectConfig `yaml:"Direct"`
+}
+
+// LokiConfig configures how loki queries are enforced. Mode is "remote" to rewrite the queries through the Giam API,
+// or "local" to fetch the policy from Giam and rewrite the queries in the plugin.
+type LokiConfig struct {
+	Mode      string       `yaml:"Mode"`
+	PolicyTTL string       `yaml:"PolicyTTL"`
+	Direct    DirectConfig `yaml:"Direct"`
+}
+
+// DirectConfig configures the enforcement of the requests sent to the HTTP API of the datasource directly, without
+// grafana in front. They are enforced with the policies of the grafana datasource DatasourceUID, and the API is served
+// under PathPrefix, e.g. "/prometheus".
+type DirectConfig struct {
+	Enabled       bool   `yaml:"Enabled"`
+	DatasourceUID string `yaml:"DatasourceUID"`
+	PathPrefix    string `yaml:"PathPrefix"`
+}
+
+// PolicySyncConfig configures the policy snapshot periodically synced from Giam and enforced by the plugin while the
+// Giam API is unreachable. The snapshot is persisted to Path when set, so it survives restarts.
+type PolicySyncConfig struct {
+	Enabled  bool   `yaml:"Enabled"`
+	Interval string `yaml:"Interval"`
+	Path     string `yaml:"Path"`
+}
+
+// FailureConfig configures what the plugin does with the requests it can't enforce because Giam can't be reached.
+// Modes are one of "deny", "allow-unmodified", "allow-read-only-metadata" or "serve-from-last-decision". Endpoints
+// override Datasources which override Default.
+type FailureConfig struct {
+	Default     string            `yaml:"Default"`
+	Datasources map[string]string `yaml:"Datasources"`
+	Endpoints   map[string]string `yaml:"Endpoints"`
+	DecisionTTL string            `yaml:"DecisionTTL"`
+}
+
+// GiamClientConfig configures the http client shared by every call to the Giam API. Only idempotent calls are
+// retried, after a connection error or a 5xx response.
+type GiamClientConfig struct {
+	DialTimeout     string `yaml:"DialTimeout"`
+	ResponseTimeout string `yaml:"ResponseTimeout"`
+	MaxRetries      int    `yaml:"MaxRetries"`
+	RetryBackoff    string `yaml:"RetryBackoff"`
+	MaxRetryBackoff string `yaml:"MaxRetryBackoff"`
+}
+
+// CircuitBreakerConfig configures the circuit breakers of the Giam API and of Grafana, each upstream having its own.
+// A breaker opens when FailureRatePercent of the calls of a Window failed, provided at least MinRequests were made,
+// and lets a trial call through after CoolDown.
+type CircuitBreakerConfig struct {
+	Enabled            bool   `yaml:"Enabled"`
+	FailureRatePercent int    `yaml:"FailureRatePercent"`
+	MinRequests        int    `yaml:"MinRequests"`
+	Window             string `yaml:"Window"`
+	CoolDown           string `yaml:"CoolDown"`
+}
+
+// IdentityConfig configures how the identity of a request is resolved. Providers are "grafana_session",
+// "grafana_token", "jwt" and "auth_proxy", they are tried in order and the first one whose credentials the request
+// carries resolves it.
+type IdentityConfig struct {
+	Providers []string        `yaml:"Providers"`
+	JWT       JWTConfig       `yaml:"JWT"`
+	AuthProxy AuthProxyConfig `yaml:"AuthProxy"`
+}
+
+// JWTConfig configures the verification of the RS256 and ES256 JWTs, with the keys of the JWKS file or url, and the
+// claims the grafana user and teams are read from.
+type JWTConfig struct {
+	JWKSPath            string          `yaml:"JWKSPath"`
+	JWKSUrl             string          `yaml:"JWKSUrl"`
+	JWKSRefreshInterval string          `yaml:"JWKSRefreshInterval"`
+	Header              string          `yaml:"Header"`
+	Issuer              string          `yaml:"Issuer"`
+	Audience            string          `yaml:"Audience"`
+	Claims              JWTClaimsConfig `yaml:"Claims"`
+	OrgID               int             `yaml:"OrgID"`
+	GroupTeams          map[string]int  `yaml:"GroupTeams"`
+}
+
+// AuthProxyConfig configures the headers an auth proxy authenticates the requests with. They are only trusted when the
+// request comes from one of the TrustedCIDRs. Groups are comma separated.
+type AuthProxyConfig struct {
+	UserHeader   string         `yaml:"UserHeader"`
+	EmailHeader  string         `yaml:"EmailHeader"`
+	GroupsHeader string         `yaml:"GroupsHeader"`
+	RoleHeader   string         `yaml:"RoleHeader"`
+	TrustedCIDRs []string       `yaml:"TrustedCIDRs"`
+	GroupTeams   map[string]int `yaml:"GroupTeams"`
+}
+
+type JWTClaimsConfig struct {
+	UserID string `yaml:"UserID"`
+	Login  string `yaml:"Login"`
+	Name   string `yaml:"Name"`
+	Email  string `yaml:"Email"`
+	Groups string `yaml:"Groups"`
+	Role   string `yaml:"Role"`
+	Org    string `yaml:"Org"`
+}
+
+// BypassConfig configures the users whose requests skip enforcement, by grafana org role, team name or login. Every
+// bypass is recorded to the audit log.
+type BypassConfig struct {
+	Roles      []string         `yaml:"Roles"`
+	Teams      []string         `yaml:"Teams"`
+	Logins     []string         `yaml:"Logins"`
+	BreakGlass BreakGlassConfig `yaml:"BreakGlass"`
+}
+
+// BreakGlassConfig configures the break-glass tokens, signed with the shared Secret, which let the user they are
+// issued for skip enforcement until they expire. Tokens valid for longer than MaxDuration are rejected. Break-glass is
+// disabled when Secret is empty.
+type BreakGlassConfig struct {
+	Header      string `yaml:"Header"`
+	Secret      string `yaml:"Secret"`
+	MaxDuration string `yaml:"MaxDuration"`
+}
+
+// AuditConfig configures the audit log, which records one JSON event per request enforced by the plugin. Sink is
+// "stderr", "file" or "webhook".
+type AuditConfig struct {
+	Enabled bool               `yaml:"Enabled"`
+	Sink    string             `yaml:"Sink"`
+	File    AuditFileConfig    `yaml:"File"`
+	Webhook AuditWebhookConfig `yaml:"Webhook"`
+}
+
+// AuditFileConfig configures the local file the events are appended to, rotated once it reaches MaxSizeMB. MaxBackups
+// rotated files are kept.
+type AuditFileConfig struct {
+	Path       string `yaml:"Path"`
+	MaxSizeMB  int    `yaml:"MaxSizeMB"`
+	MaxBackups int    `yaml:"MaxBackups"`
+}
+
+// AuditWebhookConfig configures the webhook the events are posted to in batches of up to BatchSize, at least every
+// FlushInterval. At most MaxPending events wait to be sent, the newer ones are dropped.
+type AuditWebhookConfig struct {
+	URL           string            `yaml:"URL"`
+	Headers       map[string]string `yaml:"Headers"`
+	BatchSize     int               `yaml:"BatchSize"`
+	MaxPending    int               `yaml:"MaxPending"`
+	FlushInterval string            `yaml:"FlushInterval"`
+	Timeout       string            `yaml:"Timeout"`
+}
+
+// MetricsConfig configures the metrics of the plugin, served in the Prometheus text format on Path. The requests to
+// Path are answered by the plugin and never reach the upstream.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"Enabled"`
+	Path    string `yaml:"Path"`
 }
 
 func CreateConfig() *Config {
-	return &Config{}
+	return &Config{
+		LogFormat:          log.FormatText,
+		DatasourceCacheTTL: "5m",
+		DecisionCacheTTL:   "0s",
+		Prometheus: PrometheusConfig{
+			Mode:      prometheus.ModeRemote,
+			PolicyTTL: "1m",
+		},
+		Loki: LokiConfig{
+			Mode:      loki.ModeRemote,
+			PolicyTTL: "1m",
+		},
+		GrafanaCache: GrafanaCacheConfig{
+			Enabled:     false,
+			TTL:         "1m",
+			NegativeTTL: "10s",
+			MaxEntries:  1000,
+		},
+		PolicySync: PolicySyncConfig{
+			Enabled:  false,
+			Interval: "1m",
+		},
+		Failure: FailureConfig{
+			Default:     string(failure.ModeDeny),
+			DecisionTTL: "10m",
+		},
+		GiamClient: GiamClientConfig{
+			DialTimeout:     "2s",
+			ResponseTimeout: "5s",
+			MaxRetries:      2,
+			RetryBackoff:    "100ms",
+			MaxRetryBackoff: "1s",
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			Enabled:            false,
+			FailureRatePercent: 50,
+			MinRequests:        20,
+			Window:             "30s",
+			CoolDown:           "10s",
+		},
+		Identity: IdentityConfig{
+			Providers: []string{identity.ProviderGrafanaSession},
+			JWT: JWTConfig{
+				JWKSRefreshInterval: "5m",
+				Header:              "Authorization",
+				Claims: JWTClaimsConfig{
+					UserID: "sub",
+					Login:  "preferred_username",
+					Name:   "name",
+					Email:  "email",
+					Groups: "groups",
+					Role:   "role",
+					Org:    "org_id",
+				},
+			},
+			AuthProxy: AuthProxyConfig{
+				UserHeader: "X-WEBAUTH-USER",
+			},
+		},
+		Bypass: BypassConfig{
+			BreakGlass: BreakGlassConfig{
+				Header:      "X-Giam-Break-Glass",
+				MaxDuration: "4h",
+			},
+		},
+		Audit: AuditConfig{
+			Enabled: false,
+			Sink:    audit.SinkStderr,
+			File: AuditFileConfig{
+				MaxSizeMB:  100,
+				MaxBackups: 5,
+			},
+			Webhook: AuditWebhookConfig{
+				BatchSize:     100,
+				MaxPending:    10000,
+				FlushInterval: "5s",
+				Timeout:       "10s",
+			},
+		},
+		Metrics: MetricsConfig{
+			Enabled: false,
+			Path:    "/giam/metrics",
+		},
+	}
 }
 
 type Plugin struct {
-	next     http.Handler
-	name     string
-	config   *Config
-	handlers []handler.Handler
+	next       http.Handler
+	name       string
+	config     *Config
+	handlers   []handler.Handler
+	identities identity.Resolver
+	bypass     *bypass.Policy
+	metrics    *metrics.Registry
 }
 
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
 	hashSvc := hash.NewService()
-	logger := log.New(config.LogLevel)
-	lokiSvc := lokiservice.New(&lokiservice.Deps{
-		APIUrl: config.APIUrl,
-		APIKey: config.APIKey,
-		Logger: logger,
+	logger, err := newLogger(config)
+	if err != nil {
+		return nil, err
+	}
+
+	registry, err := newMetricsRegistry(config)
+	if err != nil {
+		return nil, err
+	}
+
+	giamBreaker, err := newBreaker(config, "giam", logger)
+	if err != nil {
+		return nil, err
+	}
+
+	grafanaBreaker, err := newBreaker(config, "grafana", logger)
+	if err != nil {
+		return nil, err
+	}
+
+	giamClient, err := newGiamClient(config, giamBreaker, registry, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := newPolicyStore(ctx, config, giamClient, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	lokiSvc, err := newLokiService(config, giamClient, policies, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	prometheusSvc, err := newPrometheusService(config, giamClient, policies, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	prometheusRepo := prometheusrepo.NewRepo(config.GrafanaUrl, logger)
+
+	authorizationSvc := newAuthorizationService(giamClient, policies, logger)
+	grafanaRepo, err := newGrafanaRepo(config, grafanaBreaker, registry, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := newIdentityResolver(ctx, config, grafanaRepo, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	datasourceCacheTTL, err := time.ParseDuration(config.DatasourceCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DatasourceCacheTTL: %w", err)
+	}
+
+	decisionCacheTTL, err := time.ParseDuration(config.DecisionCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DecisionCacheTTL: %w", err)
+	}
+
+	failurePolicy, err := newFailurePolicy(config, hashSvc, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	bypassPolicy, err := newBypassPolicy(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := datasource.NewResolver(&datasource.ResolverDeps{
+		GrafanaRepo: grafanaRepo,
+		Logger:      logger,
+		CacheTTL:    datasourceCacheTTL,
+		Metrics:     registry,
 	})
-	prometheusSvc := prometheusservice.New(&prometheusservice.Deps{
-		APIUrl: config.APIUrl,
-		APIKey: config.APIKey,
-		Logger: logger,
+
+	lokiAuthorizer := lokihandler.NewQueryAuthorizer(&lokihandler.QueryAuthorizerDeps{
+		Service:     lokiSvc,
+		HashSvc:     hashSvc,
+		DecisionTTL: decisionCacheTTL,
+		Metrics:     registry,
+		Logger:      logger,
 	})
-	authorizationSvc := authorizationservice.NewService(&authorizationservice.Deps{
-		APIUrl: config.APIUrl,
-		APIKey: config.APIKey,
-		Logger: logger,
+
+	prometheusAuthorizer := prometheushandler.NewQueryAuthorizer(&prometheushandler.QueryAuthorizerDeps{
+		Logger:        logger,
+		HashSvc:       hashSvc,
+		PrometheusSvc: prometheusSvc,
+		DecisionTTL:   decisionCacheTTL,
+		Metrics:       registry,
 	})
-	grafanaRepo := grafana.NewRepo(config.GrafanaUrl, logger)
+
 	handlers := []handler.Handler{
 		authorizationhandler.NewDatasourceHandler(&authorizationhandler.DatasourceHandlerDeps{
-			Logger:      logger,
-			GrafanaRepo: grafanaRepo,
-			Service:     authorizationSvc,
+			Logger:        logger,
+			Identities:    identities,
+			Service:       authorizationSvc,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
 		}),
-		lokihandler.NewQueryHandler(&lokihandler.QueryHandlerDeps{
-			Service:     lokiSvc,
-			GrafanaRepo: grafanaRepo,
-			Logger:      logger,
+		datasourcehandler.NewQueryHandler(&datasourcehandler.QueryHandlerDeps{
+			Identities: identities,
+			Resolver:   resolver,
+			Authorizers: map[datasource.Datasource]datasource.QueryAuthorizer{
+				datasource.Loki:       lokiAuthorizer,
+				datasource.Prometheus: prometheusAuthorizer,
+			},
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+			Logger:        logger,
 		}),
 		lokihandler.NewSeriesHandler(&lokihandler.SeriesHandlerDeps{
-			Service:     lokiSvc,
-			GrafanaRepo: grafanaRepo,
-			Logger:      logger,
+			Service:       lokiSvc,
+			Identities:    identities,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+			Logger:        logger,
 		}),
 		lokihandler.NewLabelValueHandler(&lokihandler.LabelValuesHandlerDeps{
-			Service:     lokiSvc,
-			GrafanaRepo: grafanaRepo,
-			Logger:      logger,
-		}),
-		prometheushandler.NewQueryHandler(&prometheushandler.QueryHandlerDeps{
+			Service:       lokiSvc,
+			Identities:    identities,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
 			Logger:        logger,
-			GrafanaRepo:   grafanaRepo,
-			HashSvc:       hashSvc,
-			PrometheusSvc: prometheusSvc,
 		}),
 		prometheushandler.NewSeriesHandler(&prometheushandler.SeriesHandlerDeps{
-			Logger:      logger,
-			GrafanaRepo: grafanaRepo,
-			Service:     prometheusSvc,
+			Logger:        logger,
+			Identities:    identities,
+			Service:       prometheusSvc,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+		}),
+		prometheushandler.NewLabelValuesHandler(&prometheushandler.LabelValuesHandlerDeps{
+			Logger:        logger,
+			Identities:    identities,
+			Service:       prometheusSvc,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+		}),
+		prometheushandler.NewLabelNamesHandler(&prometheushandler.LabelNamesHandlerDeps{
+			Logger:        logger,
+			Identities:    identities,
+			Service:       prometheusSvc,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
 		}),
+		prometheushandler.NewMetricNamesHandler(&prometheushandler.MetricNamesHandlerDeps{
+			Logger:        logger,
+			Identities:    identities,
+			Service:       prometheusSvc,
+			Repo:          prometheusRepo,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+		}),
+		prometheushandler.NewMetadataHandler(&prometheushandler.MetadataHandlerDeps{
+			Logger:        logger,
+			Identities:    identities,
+			Service:       prometheusSvc,
+			Repo:          prometheusRepo,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+		}),
+		prometheushandler.NewExemplarsHandler(&prometheushandler.ExemplarsHandlerDeps{
+			Logger:        logger,
+			Identities:    identities,
+			Authorizer:    prometheusAuthorizer,
+			Service:       prometheusSvc,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+		}),
+	}
+
+	if config.Prometheus.Direct.Enabled {
+		if err := validateDirect("Prometheus.Direct", &config.Prometheus.Direct); err != nil {
+			return nil, err
+		}
+
+		handlers = append(handlers, prometheushandler.NewDirectHandler(&prometheushandler.DirectHandlerDeps{
+			Logger:        logger,
+			Identities:    identities,
+			Authorizer:    prometheusAuthorizer,
+			Service:       prometheusSvc,
+			DatasourceUID: config.Prometheus.Direct.DatasourceUID,
+			PathPrefix:    config.Prometheus.Direct.PathPrefix,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+		}))
+	}
+
+	if config.Loki.Direct.Enabled {
+		if err := validateDirect("Loki.Direct", &config.Loki.Direct); err != nil {
+			return nil, err
+		}
+
+		handlers = append(handlers, lokihandler.NewDirectHandler(&lokihandler.DirectHandlerDeps{
+			Logger:        logger,
+			Identities:    identities,
+			Authorizer:    lokiAuthorizer,
+			Service:       lokiSvc,
+			DatasourceUID: config.Loki.Direct.DatasourceUID,
+			PathPrefix:    config.Loki.Direct.PathPrefix,
+			FailurePolicy: failurePolicy,
+			Bypass:        bypassPolicy,
+			Shadow:        config.Shadow,
+		}))
 	}
 
 	finalHandler := handler.ChainHandlers(next, handlers...)
 
+	auditSink, err := newAuditSink(ctx, config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// The requests and decisions are counted from the audit events, so the middleware runs whenever either is enabled.
+	if sink := audit.NewMultiSink(auditSink, audit.NewMetricsSink(registry)); sink != nil {
+		finalHandler = audit.NewMiddleware(&audit.MiddlewareDeps{
+			Next:     finalHandler,
+			Handlers: handlers,
+			Sink:     sink,
+			Logger:   logger,
+		})
+	}
+
+	// Every request gets an id, which the audit events and the log lines of the request are correlated with.
+	finalHandler = requestid.NewMiddleware(&requestid.MiddlewareDeps{Next: finalHandler, Logger: logger})
+
 	return &Plugin{
-		next:     finalHandler,
-		name:     name,
-		config:   config,
-		handlers: handlers,
+		next:       finalHandler,
+		name:       name,
+		config:     config,
+		handlers:   handlers,
+		identities: identities,
+		bypass:     bypassPolicy,
+		metrics:    registry,
 	}, nil
 }
 
 func (p *Plugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	p.next.ServeHTTP(rw, req)
+	if p.metrics != nil && req.URL.Path == p.config.Metrics.Path {
+		p.metrics.ServeHTTP(rw, req)
+
+		return
+	}
+
+	// The headers only trusted from some sources are removed from every other request, including the requests no
+	// handler enforces, before they reach grafana.
+	if sanitizer, ok := p.identities.(identity.Sanitizer); ok {
+		sanitizer.Sanitize(req)
+	}
+
+	p.next.ServeHTTP(rw, p.bypass.WithToken(req))
+}
+
+func newLogger(config *Config) (*log.Logger, error) {
+	switch config.LogFormat {
+	case log.FormatText, log.FormatJSON, log.FormatLogfmt, "":
+	default:
+		return nil, fmt.Errorf("invalid LogFormat %q", config.LogFormat)
+	}
+
+	return log.NewLogger(&log.Options{Level: config.LogLevel, Format: config.LogFormat}), nil
+}
+
+// newMetricsRegistry returns the registry of the plugin metrics, or nil when the metrics are disabled.
+func newMetricsRegistry(config *Config) (*metrics.Registry, error) {
+	if !config.Metrics.Enabled {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(config.Metrics.Path, "/") {
+		return nil, fmt.Errorf("invalid Metrics.Path %q, it must start with /", config.Metrics.Path)
+	}
+
+	return metrics.NewRegistry(), nil
+}
+
+// validateDirect validates the direct mode configured at name, e.g. "Prometheus.Direct".
+func validateDirect(name string, config *DirectConfig) error {
+	if config.DatasourceUID == "" {
+		return fmt.Errorf("invalid %s.DatasourceUID, it is required", name)
+	}
+
+	prefix := config.PathPrefix
+	if prefix != "" && (!strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/")) {
+		return fmt.Errorf("invalid %s.PathPrefix %q, it must start with / and not end with /", name, prefix)
+	}
+
+	return nil
+}
+
+func newGrafanaRepo(
+	config *Config,
+	b *breaker.Breaker,
+	registry *metrics.Registry,
+	logger *log.Logger,
+) (grafana.Repo, error) {
+	repo := grafana.NewRepo(config.GrafanaUrl, logger)
+
+	if registry != nil {
+		repo = grafana.NewInstrumentedRepo(repo, registry)
+	}
+
+	if b != nil {
+		repo = grafana.NewBreakerRepo(repo, b)
+	}
+
+	repo = grafana.NewCoalescedRepo(repo)
+
+	if !config.GrafanaCache.Enabled {
+		return repo, nil
+	}
+
+	ttl, err := time.ParseDuration(config.GrafanaCache.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GrafanaCache.TTL: %w", err)
+	}
+
+	negativeTTL, err := time.ParseDuration(config.GrafanaCache.NegativeTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GrafanaCache.NegativeTTL: %w", err)
+	}
+
+	return grafana.NewCachedRepo(repo, &grafana.CacheOptions{
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+		MaxEntries:  config.GrafanaCache.MaxEntries,
+		Metrics:     registry,
+	}, logger), nil
+}
+
+// newBreaker returns the circuit breaker of the named upstream, or nil when the circuit breakers are disabled.
+func newBreaker(config *Config, name string, logger *log.Logger) (*breaker.Breaker, error) {
+	if !config.CircuitBreaker.Enabled {
+		return nil, nil
+	}
+
+	if config.CircuitBreaker.FailureRatePercent <= 0 || config.CircuitBreaker.FailureRatePercent > 100 {
+		return nil, fmt.Errorf("invalid CircuitBreaker.FailureRatePercent %d", config.CircuitBreaker.FailureRatePercent)
+	}
+
+	window, err := time.ParseDuration(config.CircuitBreaker.Window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CircuitBreaker.Window: %w", err)
+	}
+
+	coolDown, err := time.ParseDuration(config.CircuitBreaker.CoolDown)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CircuitBreaker.CoolDown: %w", err)
+	}
+
+	return breaker.New(&breaker.Options{
+		Name:        name,
+		FailureRate: float64(config.CircuitBreaker.FailureRatePercent) / 100,
+		MinRequests: config.CircuitBreaker.MinRequests,
+		Window:      window,
+		CoolDown:    coolDown,
+		Logger:      logger,
+	}), nil
+}
+
+// newIdentityResolver returns the resolver of the configured identity providers, and starts refreshing the JWKS keys
+// until ctx is done.
+func newIdentityResolver(
+	ctx context.Context,
+	config *Config,
+	grafanaRepo grafana.Repo,
+	logger *log.Logger,
+) (identity.Resolver, error) {
+	if len(config.Identity.Providers) == 0 {
+		return nil, fmt.Errorf("invalid Identity.Providers, at least one provider is required")
+	}
+
+	resolvers := make([]identity.Resolver, 0, len(config.Identity.Providers))
+	// The org role is only looked up in grafana when a bypass rule needs it.
+	withRole := len(config.Bypass.Roles) > 0
+
+	for _, provider := range config.Identity.Providers {
+		switch provider {
+		case identity.ProviderGrafanaSession:
+			resolvers = append(resolvers, identity.NewSession(&identity.SessionDeps{
+				GrafanaRepo: grafanaRepo,
+				WithRole:    withRole,
+				Logger:      logger,
+			}))
+		case identity.ProviderGrafanaToken:
+			resolvers = append(resolvers, identity.NewToken(&identity.TokenDeps{
+				GrafanaRepo: grafanaRepo,
+				WithRole:    withRole,
+				Logger:      logger,
+			}))
+		case identity.ProviderJWT:
+			resolver, err := newJWTResolver(ctx, &config.Identity.JWT, logger)
+			if err != nil {
+				return nil, err
+			}
+
+			resolvers = append(resolvers, resolver)
+		case identity.ProviderAuthProxy:
+			resolver, err := newAuthProxyResolver(&config.Identity.AuthProxy, logger)
+			if err != nil {
+				return nil, err
+			}
+
+			resolvers = append(resolvers, resolver)
+		default:
+			return nil, fmt.Errorf("invalid Identity.Providers, unknown provider %q", provider)
+		}
+	}
+
+	return identity.NewChain(resolvers...), nil
+}
+
+func newAuthProxyResolver(config *AuthProxyConfig, logger *log.Logger) (identity.Resolver, error) {
+	if config.UserHeader == "" {
+		return nil, fmt.Errorf("invalid Identity.AuthProxy.UserHeader, it is required")
+	}
+
+	if len(config.TrustedCIDRs) == 0 {
+		return nil, fmt.Errorf("invalid Identity.AuthProxy.TrustedCIDRs, at least one network is required")
+	}
+
+	trusted := make([]*net.IPNet, len(config.TrustedCIDRs))
+
+	for i, cidr := range config.TrustedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Identity.AuthProxy.TrustedCIDRs: %w", err)
+		}
+
+		trusted[i] = network
+	}
+
+	return identity.NewAuthProxy(&identity.AuthProxyDeps{
+		UserHeader:   config.UserHeader,
+		EmailHeader:  config.EmailHeader,
+		GroupsHeader: config.GroupsHeader,
+		RoleHeader:   config.RoleHeader,
+		Trusted:      trusted,
+		GroupTeams:   config.GroupTeams,
+		Logger:       logger,
+	}), nil
+}
+
+func newJWTResolver(ctx context.Context, config *JWTConfig, logger *log.Logger) (identity.Resolver, error) {
+	if (config.JWKSPath == "") == (config.JWKSUrl == "") {
+		return nil, fmt.Errorf("invalid Identity.JWT, exactly one of JWKSPath and JWKSUrl is required")
+	}
+
+	interval, err := time.ParseDuration(config.JWKSRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Identity.JWT.JWKSRefreshInterval: %w", err)
+	}
+
+	keys := identity.NewKeySet(&identity.KeySetDeps{
+		Path:            config.JWKSPath,
+		URL:             config.JWKSUrl,
+		RefreshInterval: interval,
+		Logger:          logger,
+	})
+
+	// An unreachable JWKS url must not prevent the plugin from starting, the keys are downloaded again on refresh.
+	if err := keys.Load(ctx); err != nil {
+		if config.JWKSUrl == "" {
+			return nil, fmt.Errorf("invalid Identity.JWT.JWKSPath: %w", err)
+		}
+
+		logger.Ctx(ctx).Errorw("failed to load JWKS keys", "url", config.JWKSUrl, "err", err)
+	}
+
+	go keys.Run(ctx)
+
+	return identity.NewJWT(&identity.JWTDeps{
+		Keys:     keys,
+		Header:   config.Header,
+		Issuer:   config.Issuer,
+		Audience: config.Audience,
+		Claims: identity.Claims{
+			UserID: config.Claims.UserID,
+			Login:  config.Claims.Login,
+			Name:   config.Claims.Name,
+			Email:  config.Claims.Email,
+			Groups: config.Claims.Groups,
+			Role:   config.Claims.Role,
+			Org:    config.Claims.Org,
+		},
+		OrgID:      config.OrgID,
+		GroupTeams: config.GroupTeams,
+		Logger:     logger,
+	}), nil
+}
+
+func newGiamClient(
+	config *Config,
+	b *breaker.Breaker,
+	registry *metrics.Registry,
+	logger *log.Logger,
+) (*giam.Client, error) {
+	durations := map[string]string{
+		"DialTimeout":     config.GiamClient.DialTimeout,
+		"ResponseTimeout": config.GiamClient.ResponseTimeout,
+		"RetryBackoff":    config.GiamClient.RetryBackoff,
+		"MaxRetryBackoff": config.GiamClient.MaxRetryBackoff,
+	}
+
+	parsed := make(map[string]time.Duration, len(durations))
+
+	for name, value := range durations {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GiamClient.%s: %w", name, err)
+		}
+
+		parsed[name] = d
+	}
+
+	if config.GiamClient.MaxRetries < 0 {
+		return nil, fmt.Errorf("invalid GiamClient.MaxRetries %d", config.GiamClient.MaxRetries)
+	}
+
+	return giam.NewClient(&giam.Options{
+		APIUrl:          config.APIUrl,
+		APIKey:          config.APIKey,
+		DialTimeout:     parsed["DialTimeout"],
+		ResponseTimeout: parsed["ResponseTimeout"],
+		MaxRetries:      config.GiamClient.MaxRetries,
+		RetryBackoff:    parsed["RetryBackoff"],
+		MaxRetryBackoff: parsed["MaxRetryBackoff"],
+		Breaker:         b,
+		Metrics:         registry,
+		Logger:          logger,
+	}), nil
+}
+
+func newFailurePolicy(config *Config, hashSvc hash.Service, logger *log.Logger) (*failure.Policy, error) {
+	decisionTTL, err := time.ParseDuration(config.Failure.DecisionTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Failure.DecisionTTL: %w", err)
+	}
+
+	datasources := make(map[string]failure.Mode, len(config.Failure.Datasources))
+	for ds, mode := range config.Failure.Datasources {
+		datasources[ds] = failure.Mode(mode)
+	}
+
+	endpoints := make(map[string]failure.Mode, len(config.Failure.Endpoints))
+	for endpoint, mode := range config.Failure.Endpoints {
+		endpoints[endpoint] = failure.Mode(mode)
+	}
+
+	defaultMode := failure.Mode(config.Failure.Default)
+	if defaultMode == "" {
+		defaultMode = failure.ModeDeny
+	}
+
+	return failure.NewPolicy(&failure.PolicyDeps{
+		Default:     defaultMode,
+		Datasources: datasources,
+		Endpoints:   endpoints,
+		DecisionTTL: decisionTTL,
+		HashSvc:     hashSvc,
+		Logger:      logger,
+	})
+}
+
+// newAuditSink returns the sink of the audit events, and starts sending the webhook batches until ctx is done, or nil
+// when the audit log is disabled.
+func newAuditSink(ctx context.Context, config *Config, logger *log.Logger) (audit.Sink, error) {
+	if !config.Audit.Enabled {
+		return nil, nil
+	}
+
+	switch config.Audit.Sink {
+	case audit.SinkStderr, "":
+		return audit.NewWriterSink(os.Stderr), nil
+	case audit.SinkFile:
+		if config.Audit.File.Path == "" {
+			return nil, fmt.Errorf("invalid Audit.File.Path, it is required")
+		}
+
+		sink, err := audit.NewFileSink(&audit.FileSinkDeps{
+			Path:       config.Audit.File.Path,
+			MaxSize:    int64(config.Audit.File.MaxSizeMB) << 20,
+			MaxBackups: config.Audit.File.MaxBackups,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid Audit.File: %w", err)
+		}
+
+		return sink, nil
+	case audit.SinkWebhook:
+		return newAuditWebhookSink(ctx, &config.Audit.Webhook, logger)
+	default:
+		return nil, fmt.Errorf("invalid Audit.Sink %q", config.Audit.Sink)
+	}
+}
+
+func newAuditWebhookSink(ctx context.Context, config *AuditWebhookConfig, logger *log.Logger) (audit.Sink, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("invalid Audit.Webhook.URL, it is required")
+	}
+
+	if config.BatchSize <= 0 || config.MaxPending < config.BatchSize {
+		return nil, fmt.Errorf("invalid Audit.Webhook, BatchSize must be positive and at most MaxPending")
+	}
+
+	interval, err := time.ParseDuration(config.FlushInterval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid Audit.Webhook.FlushInterval %q", config.FlushInterval)
+	}
+
+	timeout, err := time.ParseDuration(config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Audit.Webhook.Timeout: %w", err)
+	}
+
+	sink := audit.NewWebhookSink(&audit.WebhookSinkDeps{
+		URL:           config.URL,
+		Headers:       config.Headers,
+		BatchSize:     config.BatchSize,
+		MaxPending:    config.MaxPending,
+		FlushInterval: interval,
+		Timeout:       timeout,
+		Logger:        logger,
+	})
+
+	go sink.Run(ctx)
+
+	return sink, nil
+}
+
+// newBypassPolicy returns the policy of the requests skipping enforcement, or nil when no bypass is configured.
+func newBypassPolicy(config *Config, logger *log.Logger) (*bypass.Policy, error) {
+	maxDuration, err := time.ParseDuration(config.Bypass.BreakGlass.MaxDuration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Bypass.BreakGlass.MaxDuration: %w", err)
+	}
+
+	bypassPolicy, err := bypass.NewPolicy(&bypass.PolicyDeps{
+		Roles:                 config.Bypass.Roles,
+		Teams:                 config.Bypass.Teams,
+		Logins:                config.Bypass.Logins,
+		BreakGlassHeader:      config.Bypass.BreakGlass.Header,
+		BreakGlassSecret:      config.Bypass.BreakGlass.Secret,
+		BreakGlassMaxDuration: maxDuration,
+		Logger:                logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid Bypass.BreakGlass: %w", err)
+	}
+
+	return bypassPolicy, nil
+}
+
+// newPolicyStore returns the policy snapshot store and starts syncing it until ctx is done, or nil when the policy
+// sync is disabled.
+func newPolicyStore(
+	ctx context.Context,
+	config *Config,
+	giamClient *giam.Client,
+	logger *log.Logger,
+) (*policy.Store, error) {
+	if !config.PolicySync.Enabled {
+		return nil, nil
+	}
+
+	interval, err := time.ParseDuration(config.PolicySync.Interval)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid PolicySync.Interval %q", config.PolicySync.Interval)
+	}
+
+	store := policy.NewStore(&policy.StoreDeps{
+		Service: policyservice.New(&policyservice.Deps{
+			Client: giamClient,
+			Logger: logger,
+		}),
+		Path:     config.PolicySync.Path,
+		Interval: interval,
+		Logger:   logger,
+	})
+
+	// A corrupted snapshot must not prevent the plugin from starting, the next sync replaces it.
+	if err := store.Load(); err != nil {
+		logger.Ctx(ctx).Errorw("failed to load policy snapshot", "path", config.PolicySync.Path, "err", err)
+	}
+
+	go store.Run(ctx)
+
+	return store, nil
+}
+
+func newAuthorizationService(
+	giamClient *giam.Client,
+	policies *policy.Store,
+	logger *log.Logger,
+) authorization.Service {
+	svc := authorizationservice.NewService(&authorizationservice.Deps{
+		Client: giamClient,
+		Logger: logger,
+	})
+
+	if policies == nil {
+		return svc
+	}
+
+	return authorizationservice.NewOffline(&authorizationservice.OfflineDeps{
+		Service:  svc,
+		Policies: policies,
+		Logger:   logger,
+	})
+}
+
+func newLokiService(
+	config *Config,
+	giamClient *giam.Client,
+	policies *policy.Store,
+	logger *log.Logger,
+) (loki.Service, error) {
+	svc := lokiservice.NewCoalesced(lokiservice.New(&lokiservice.Deps{
+		Client: giamClient,
+		Logger: logger,
+	}))
+
+	if policies != nil {
+		svc = lokiservice.NewOffline(&lokiservice.OfflineDeps{
+			Service:  svc,
+			Policies: policies,
+			Logger:   logger,
+		})
+	}
+
+	switch config.Loki.Mode {
+	case loki.ModeRemote, "":
+		return svc, nil
+	case loki.ModeLocal:
+		policyTTL, err := time.ParseDuration(config.Loki.PolicyTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Loki.PolicyTTL: %w", err)
+		}
+
+		return lokiservice.NewLocal(&lokiservice.LocalDeps{
+			Service:   svc,
+			PolicyTTL: policyTTL,
+			Logger:    logger,
+		}), nil
+	default:
+		return nil, fmt.Errorf("invalid Loki.Mode %q", config.Loki.Mode)
+	}
+}
+
+func newPrometheusService(
+	config *Config,
+	giamClient *giam.Client,
+	policies *policy.Store,
+	logger *log.Logger,
+) (prometheus.Service, error) {
+	svc := prometheusservice.NewCoalesced(prometheusservice.New(&prometheusservice.Deps{
+		Client: giamClient,
+		Logger: logger,
+	}))
+
+	if policies != nil {
+		svc = prometheusservice.NewOffline(&prometheusservice.OfflineDeps{
+			Service:  svc,
+			Policies: policies,
+			Logger:   logger,
+		})
+	}
+
+	switch config.Prometheus.Mode {
+	case prometheus.ModeRemote, "":
+		return svc, nil
+	case prometheus.ModeLocal:
+		policyTTL, err := time.ParseDuration(config.Prometheus.PolicyTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Prometheus.PolicyTTL: %w", err)
+		}
+
+		return prometheusservice.NewLocal(&prometheusservice.LocalDeps{
+			Service:   svc,
+			PolicyTTL: policyTTL,
+			Logger:    logger,
+		}), nil
+	default:
+		return nil, fmt.Errorf("invalid Prometheus.Mode %q", config.Prometheus.Mode)
+	}
 }